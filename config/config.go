@@ -1,59 +1,460 @@
+// Package config loads the server's configuration from, in order of precedence,
+// command-line flags, environment variables and an optional dotenv file, on top
+// of defaults that run the server against a local database.
 package config
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
-	"regexp"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
 )
 
-const projectDirName = "draw" // change to relevant project name
+const (
+	TileCacheR2     = "r2"
+	TileCacheMemory = "memory"
+)
+
+const (
+	// PixelStorageRows keeps one row per pixel, see storage.NewPGPixelStore.
+	PixelStorageRows = "rows"
+	// PixelStorageChunks keeps one row per 64x64 chunk, see storage.NewPGChunkPixelStore.
+	PixelStorageChunks = "chunks"
+)
+
+// DefaultFile is the dotenv file read when no -config flag is given, if it exists.
+const DefaultFile = ".env"
+
+var ErrInvalid = errors.New("invalid configuration")
 
 type Config struct {
-	R2_ACCOUNT_ID                 string
-	R2_ACCESS_KEY_ID              string
-	R2_ACCESS_KEY_SECRET          string
-	R2_TILECACHE_BUCKET_NAME      string
-	R2_TILECACHE_BUCKET_NAME_TEST string
+	Port   int
+	AppURL string
+
+	Database     storage.PGConfig
+	PixelStorage string
+	TileCache    TileCacheConfig
+
+	TileSides   []int64
+	MaxTileSide int64
+
+	CORSOrigins []string
+
+	PrecacheInterval    time.Duration
+	PrecacheConcurrency int
+
+	Limits LimitsConfig
+
+	SMTP         SMTPConfig
+	OIDC         OIDCConfig
+	AdminUserIDs []string
+}
+
+// TileCacheConfig picks where rendered tiles are cached: in an R2 bucket, or
+// in memory for development and tests.
+type TileCacheConfig struct {
+	Backend           string
+	R2AccountID       string
+	R2AccessKeyID     string
+	R2AccessKeySecret string
+	R2Bucket          string
+	// R2TestBucket is the bucket tests use, so that they never touch real tiles.
+	R2TestBucket string
+}
+
+// LimitsConfig bounds what users on probation can do, see services.ProbationPolicy.
+type LimitsConfig struct {
+	ProbationMaxPixelsPerMinute int
+	ProbationPromoteAfterPixels int64
+	ProbationPromoteAfter       time.Duration
+}
+
+// SMTPConfig is where emails are sent, they are written to a local outbox if Host is empty.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// OIDCConfig enables sign-in with an OpenID Connect provider if Issuer is set.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Default returns the configuration used for whatever is not configured.
+func Default() Config {
+	return Config{
+		Port:         1001,
+		Database:     storage.DefaultPGConfig(),
+		PixelStorage: PixelStorageRows,
+		TileCache: TileCacheConfig{
+			Backend: TileCacheR2,
+		},
+		TileSides:           append([]int64{}, core.DefaultTileSides...),
+		MaxTileSide:         core.DefaultMaxTileSide,
+		CORSOrigins:         []string{"*"},
+		PrecacheInterval:    time.Minute,
+		PrecacheConcurrency: 8,
+		Limits: LimitsConfig{
+			ProbationMaxPixelsPerMinute: 600,
+			ProbationPromoteAfterPixels: 1000,
+			ProbationPromoteAfter:       72 * time.Hour,
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+	}
 }
 
-var config *Config
+// option is a setting, named NAME in the environment and dotenv files and
+// -name on the command line.
+type option struct {
+	env string
+	// aliases are names the setting used to go by, still read if env is unset.
+	aliases []string
+	usage   string
+	set     func(value string) error
+}
 
-func init() {
-	config = getConfig()
+func (o option) flag() string {
+	return strings.ReplaceAll(strings.ToLower(o.env), "_", "-")
 }
 
-func loadEnv() {
-	projectName := regexp.MustCompile(`^(.*` + projectDirName + `)`)
-	currentWorkDirectory, _ := os.Getwd()
-	rootPath := projectName.Find([]byte(currentWorkDirectory))
+func (c *Config) options() []option {
+	return []option{
+		{env: "PORT", usage: "port to listen on", set: intValue(&c.Port)},
+		{env: "APP_URL", usage: "URL of the frontend, linked to from emails", set: stringValue(&c.AppURL)},
+
+		{env: "DATABASE_URL", usage: "postgres:// URL or key=value connection string", set: stringValue(&c.Database.DSN)},
+		{env: "DB_MAX_OPEN_CONNS", usage: "maximum open connections", set: intValue(&c.Database.MaxOpenConns)},
+		{env: "DB_MAX_IDLE_CONNS", usage: "maximum idle connections", set: intValue(&c.Database.MaxIdleConns)},
+		{env: "DB_CONN_MAX_LIFETIME", usage: "how long connections are reused", set: durationValue(&c.Database.ConnMaxLifetime)},
+		{env: "DB_CONN_MAX_IDLE_TIME", usage: "how long connections stay idle", set: durationValue(&c.Database.ConnMaxIdleTime)},
+		{env: "DB_STATEMENT_TIMEOUT", usage: "cancel statements running longer, 0 for never", set: durationValue(&c.Database.StatementTimeout)},
+		{env: "DB_QUERY_TIMEOUT", usage: "cancel queries made for requests running longer, including the wait for a connection, 0 for never", set: durationValue(&c.Database.QueryTimeout)},
+		{env: "DB_CONNECT_ATTEMPTS", usage: "how many times to try connecting at startup", set: intValue(&c.Database.ConnectAttempts)},
+		{env: "DB_CONNECT_BACKOFF", usage: "wait after the first failed connection attempt, doubled after each", set: durationValue(&c.Database.ConnectBackoff)},
+		{env: "PIXEL_STORAGE", usage: "how pixels are stored, rows or chunks", set: stringValue(&c.PixelStorage)},
 
-	err := godotenv.Load(string(rootPath) + `/.env`)
+		{env: "TILE_CACHE_BACKEND", usage: "where to cache tiles, r2 or memory", set: stringValue(&c.TileCache.Backend)},
+		{env: "R2_ACCOUNT_ID", aliases: []string{"R2_AWS_ACCOUNT_ID"}, usage: "R2 account", set: stringValue(&c.TileCache.R2AccountID)},
+		{env: "R2_ACCESS_KEY_ID", aliases: []string{"R2_AWS_ACCESS_KEY_ID"}, usage: "R2 access key", set: stringValue(&c.TileCache.R2AccessKeyID)},
+		{env: "R2_ACCESS_KEY_SECRET", aliases: []string{"R2_AWS_ACCESS_KEY_SECRET"}, usage: "R2 access key secret", set: stringValue(&c.TileCache.R2AccessKeySecret)},
+		{env: "R2_TILECACHE_BUCKET_NAME", usage: "R2 bucket of the tile cache", set: stringValue(&c.TileCache.R2Bucket)},
+		{env: "R2_TILECACHE_BUCKET_NAME_TEST", usage: "R2 bucket used by tests", set: stringValue(&c.TileCache.R2TestBucket)},
 
+		{env: "TILE_SIDES", usage: "comma-separated tile sides canvases serve", set: int64ListValue(&c.TileSides)},
+		{env: "MAX_TILE_SIDE", usage: "largest tile side canvases serve", set: int64Value(&c.MaxTileSide)},
+		{env: "CORS_ORIGINS", usage: "comma-separated origins allowed to call the API", set: listValue(&c.CORSOrigins)},
+		{env: "PRECACHE_INTERVAL", usage: "how often changed tiles are precached", set: durationValue(&c.PrecacheInterval)},
+		{env: "PRECACHE_CONCURRENCY", usage: "how many tiles are precached at once", set: intValue(&c.PrecacheConcurrency)},
+
+		{env: "PROBATION_MAX_PIXELS_PER_MINUTE", usage: "drawing rate of users on probation", set: intValue(&c.Limits.ProbationMaxPixelsPerMinute)},
+		{env: "PROBATION_PROMOTE_AFTER_PIXELS", usage: "pixels drawn to leave probation", set: int64Value(&c.Limits.ProbationPromoteAfterPixels)},
+		{env: "PROBATION_PROMOTE_AFTER_HOURS", usage: "account age in hours to leave probation", set: hoursValue(&c.Limits.ProbationPromoteAfter)},
+
+		{env: "SMTP_HOST", usage: "SMTP server, emails go to ./outbox if unset", set: stringValue(&c.SMTP.Host)},
+		{env: "SMTP_PORT", usage: "SMTP port", set: intValue(&c.SMTP.Port)},
+		{env: "SMTP_USERNAME", usage: "SMTP username", set: stringValue(&c.SMTP.Username)},
+		{env: "SMTP_PASSWORD", usage: "SMTP password", set: stringValue(&c.SMTP.Password)},
+		{env: "SMTP_FROM", usage: "sender of emails", set: stringValue(&c.SMTP.From)},
+
+		{env: "OIDC_ISSUER", usage: "OpenID Connect provider, sign-in with it is disabled if unset", set: stringValue(&c.OIDC.Issuer)},
+		{env: "OIDC_CLIENT_ID", usage: "OpenID Connect client ID", set: stringValue(&c.OIDC.ClientID)},
+		{env: "OIDC_CLIENT_SECRET", usage: "OpenID Connect client secret", set: stringValue(&c.OIDC.ClientSecret)},
+		{env: "OIDC_REDIRECT_URL", usage: "frontend page the provider sends users back to", set: stringValue(&c.OIDC.RedirectURL)},
+
+		{env: "ADMIN_USER_IDS", usage: "comma-separated users who are admins without a stored role", set: listValue(&c.AdminUserIDs)},
+	}
+}
+
+// Load reads the configuration from the command-line arguments (without the
+// program name), the environment through lookupEnv, usually os.LookupEnv, and
+// the dotenv file given with -config, or DefaultFile if it exists, then validates
+// it. It returns the arguments left after the flags, e.g. a subcommand.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, []string, error) {
+	config, rest, err := Read(args, lookupEnv)
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		return nil, nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
 	}
+	return config, rest, nil
 }
 
-func getConfig() *Config {
-	loadEnv()
+// Read is Load without validation, for tests that only need part of the
+// configuration and skip when that part is missing.
+func Read(args []string, lookupEnv func(string) (string, bool)) (*Config, []string, error) {
+	config := Default()
+	options := config.options()
+
+	flags := flag.NewFlagSet("draw", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	file := flags.String("config", "", "dotenv file to read settings from")
+	fromFlags := map[string]string{}
+	for _, opt := range options {
+		env := opt.env
+		flags.Func(opt.flag(), opt.usage, func(value string) error {
+			fromFlags[env] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	fromFile, err := readFile(*file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	var errs []error
+	for _, opt := range options {
+		value, ok := lookup(opt, fromFlags, lookupEnv, fromFile)
+		if !ok {
+			continue
+		}
+		if err := opt.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", opt.env, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalid, errors.Join(errs...))
+	}
 
-	R2_ACCOUNT_ID := os.Getenv("R2_ACCOUNT_ID")
-	R2_ACCESS_KEY_ID := os.Getenv("R2_ACCESS_KEY_ID")
-	R2_ACCESS_KEY_SECRET := os.Getenv("R2_ACCESS_KEY_SECRET")
-	R2_TILECACHE_BUCKET_NAME := os.Getenv("R2_TILECACHE_BUCKET_NAME")
-	R2_TILECACHE_BUCKET_NAME_TEST := os.Getenv("R2_TILECACHE_BUCKET_NAME_TEST")
+	return &config, flags.Args(), nil
+}
 
-	return &Config{
-		R2_ACCOUNT_ID:                 R2_ACCOUNT_ID,
-		R2_ACCESS_KEY_ID:              R2_ACCESS_KEY_ID,
-		R2_ACCESS_KEY_SECRET:          R2_ACCESS_KEY_SECRET,
-		R2_TILECACHE_BUCKET_NAME:      R2_TILECACHE_BUCKET_NAME,
-		R2_TILECACHE_BUCKET_NAME_TEST: R2_TILECACHE_BUCKET_NAME_TEST,
+// lookup finds the option's value, from flags first, then the environment, then the file.
+func lookup(opt option, fromFlags map[string]string, lookupEnv func(string) (string, bool), fromFile map[string]string) (string, bool) {
+	if value, ok := fromFlags[opt.env]; ok {
+		return value, true
 	}
+	for _, name := range append([]string{opt.env}, opt.aliases...) {
+		if value, ok := lookupEnv(name); ok {
+			return value, true
+		}
+	}
+	for _, name := range append([]string{opt.env}, opt.aliases...) {
+		if value, ok := fromFile[name]; ok {
+			return value, true
+		}
+	}
+	return "", false
 }
 
-func GetConfig() *Config {
-	return config
+// readFile reads the dotenv file at path, or DefaultFile if path is empty, which
+// unlike an explicit path may not exist.
+func readFile(path string) (map[string]string, error) {
+	if path == "" {
+		path = findDefaultFile()
+		if path == "" {
+			return map[string]string{}, nil
+		}
+	}
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// findDefaultFile looks for DefaultFile in the working directory and its parents
+// up to the module root, so that tests, which run in their package's directory,
+// find the same file as the server. It returns "" if there is none.
+func findDefaultFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, DefaultFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Usage writes the flags and environment variables Load understands to w.
+func Usage(w io.Writer) {
+	config := Default()
+	fmt.Fprintln(w, "  -config FILE")
+	fmt.Fprintf(w, "        dotenv file to read settings from (default %s if it exists)\n", DefaultFile)
+	for _, opt := range config.options() {
+		fmt.Fprintf(w, "  -%s, %s\n        %s\n", opt.flag(), opt.env, opt.usage)
+	}
+}
+
+// Validate checks the configuration is complete and consistent, listing every problem at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Port > 0 && c.Port < 65536, "PORT: %d is not a valid port", c.Port)
+	if c.AppURL != "" {
+		u, err := url.Parse(c.AppURL)
+		check(err == nil && u.Scheme != "" && u.Host != "", "APP_URL: %q is not an absolute URL", c.AppURL)
+	}
+
+	check(c.Database.DSN != "", "DATABASE_URL: must be set")
+	check(c.Database.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS: must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS: must not be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns, "DB_MAX_IDLE_CONNS: must not exceed DB_MAX_OPEN_CONNS")
+	check(c.Database.StatementTimeout >= 0, "DB_STATEMENT_TIMEOUT: must not be negative")
+	check(c.Database.QueryTimeout >= 0, "DB_QUERY_TIMEOUT: must not be negative")
+	check(c.Database.ConnectAttempts > 0, "DB_CONNECT_ATTEMPTS: must be at least 1")
+	check(c.Database.ConnectBackoff > 0, "DB_CONNECT_BACKOFF: must be positive")
+	check(c.PixelStorage == PixelStorageRows || c.PixelStorage == PixelStorageChunks, "PIXEL_STORAGE: %q is not one of %s or %s", c.PixelStorage, PixelStorageRows, PixelStorageChunks)
+
+	switch c.TileCache.Backend {
+	case TileCacheMemory:
+	case TileCacheR2:
+		check(c.TileCache.R2AccountID != "", "R2_ACCOUNT_ID: must be set with the r2 tile cache")
+		check(c.TileCache.R2AccessKeyID != "", "R2_ACCESS_KEY_ID: must be set with the r2 tile cache")
+		check(c.TileCache.R2AccessKeySecret != "", "R2_ACCESS_KEY_SECRET: must be set with the r2 tile cache")
+		check(c.TileCache.R2Bucket != "", "R2_TILECACHE_BUCKET_NAME: must be set with the r2 tile cache")
+	default:
+		check(false, "TILE_CACHE_BACKEND: %q is not one of %s or %s", c.TileCache.Backend, TileCacheR2, TileCacheMemory)
+	}
+
+	check(c.MaxTileSide > 0, "MAX_TILE_SIDE: must be positive")
+	check(len(c.TileSides) > 0, "TILE_SIDES: must list at least one side")
+	for _, side := range c.TileSides {
+		check(side > 0 && side <= c.MaxTileSide, "TILE_SIDES: %d is not between 1 and MAX_TILE_SIDE", side)
+	}
+
+	check(len(c.CORSOrigins) > 0, "CORS_ORIGINS: must list at least one origin")
+	check(c.PrecacheInterval > 0, "PRECACHE_INTERVAL: must be positive")
+	check(c.PrecacheConcurrency > 0, "PRECACHE_CONCURRENCY: must be positive")
+
+	check(c.Limits.ProbationMaxPixelsPerMinute > 0, "PROBATION_MAX_PIXELS_PER_MINUTE: must be positive")
+	check(c.Limits.ProbationPromoteAfterPixels >= 0, "PROBATION_PROMOTE_AFTER_PIXELS: must not be negative")
+	check(c.Limits.ProbationPromoteAfter >= 0, "PROBATION_PROMOTE_AFTER_HOURS: must not be negative")
+
+	if c.SMTP.Host != "" {
+		check(c.SMTP.Port > 0 && c.SMTP.Port < 65536, "SMTP_PORT: %d is not a valid port", c.SMTP.Port)
+		check(c.SMTP.From != "", "SMTP_FROM: must be set with SMTP_HOST")
+	}
+
+	if c.OIDC.Issuer != "" {
+		check(c.OIDC.ClientID != "", "OIDC_CLIENT_ID: must be set with OIDC_ISSUER")
+		check(c.OIDC.ClientSecret != "", "OIDC_CLIENT_SECRET: must be set with OIDC_ISSUER")
+		check(c.OIDC.RedirectURL != "", "OIDC_REDIRECT_URL: must be set with OIDC_ISSUER")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalid, errors.Join(errs...))
+	}
+	return nil
+}
+
+func stringValue(p *string) func(string) error {
+	return func(value string) error {
+		*p = strings.TrimSpace(value)
+		return nil
+	}
+}
+
+func intValue(p *int) func(string) error {
+	return func(value string) error {
+		v, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*p = v
+		return nil
+	}
+}
+
+func int64Value(p *int64) func(string) error {
+	return func(value string) error {
+		v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*p = v
+		return nil
+	}
+}
+
+func durationValue(p *time.Duration) func(string) error {
+	return func(value string) error {
+		v, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", value)
+		}
+		*p = v
+		return nil
+	}
+}
+
+func hoursValue(p *time.Duration) func(string) error {
+	return func(value string) error {
+		v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number of hours", value)
+		}
+		*p = time.Duration(v) * time.Hour
+		return nil
+	}
+}
+
+// listValue splits a comma-separated list, ignoring empty items.
+func listValue(p *[]string) func(string) error {
+	return func(value string) error {
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+		return nil
+	}
+}
+
+func int64ListValue(p *[]int64) func(string) error {
+	return func(value string) error {
+		var items []string
+		listValue(&items)(value)
+
+		list := make([]int64, len(items))
+		for i, item := range items {
+			v, err := strconv.ParseInt(item, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not an integer", item)
+			}
+			list[i] = v
+		}
+		*p = list
+		return nil
+	}
 }
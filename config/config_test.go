@@ -0,0 +1,175 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// env returns a lookupEnv reading from values instead of the process environment.
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "test.env")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	file := writeFile(t, "")
+
+	cfg, rest, err := config.Load([]string{"-config", file, "-tile-cache-backend", "memory"}, env(nil))
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+
+	def := config.Default()
+	assert.Equal(t, 1001, cfg.Port)
+	assert.Equal(t, def.Database, cfg.Database)
+	assert.Equal(t, def.TileSides, cfg.TileSides)
+	assert.Equal(t, config.PixelStorageRows, cfg.PixelStorage)
+	assert.Equal(t, []string{"*"}, cfg.CORSOrigins)
+	assert.Equal(t, 72*time.Hour, cfg.Limits.ProbationPromoteAfter)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, "PORT=2001\nPRECACHE_CONCURRENCY=2\nPRECACHE_INTERVAL=2m\nTILE_CACHE_BACKEND=memory\n")
+	lookupEnv := env(map[string]string{
+		"PORT":                 "3001",
+		"PRECACHE_CONCURRENCY": "3",
+	})
+
+	cfg, _, err := config.Load([]string{"-config", file, "-port", "4001"}, lookupEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, 4001, cfg.Port, "flags win over the environment")
+	assert.Equal(t, 3, cfg.PrecacheConcurrency, "the environment wins over the file")
+	assert.Equal(t, 2*time.Minute, cfg.PrecacheInterval, "the file wins over defaults")
+}
+
+func TestLoad_LegacyR2Names(t *testing.T) {
+	file := writeFile(t, "R2_AWS_ACCOUNT_ID=legacy\nR2_ACCOUNT_ID=account\n")
+	lookupEnv := env(map[string]string{
+		"R2_AWS_ACCESS_KEY_ID":     "key",
+		"R2_AWS_ACCESS_KEY_SECRET": "secret",
+		"R2_TILECACHE_BUCKET_NAME": "tiles",
+	})
+
+	cfg, _, err := config.Load([]string{"-config", file}, lookupEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, "account", cfg.TileCache.R2AccountID, "the current name wins over the legacy one")
+	assert.Equal(t, "key", cfg.TileCache.R2AccessKeyID)
+	assert.Equal(t, "secret", cfg.TileCache.R2AccessKeySecret)
+	assert.Equal(t, "tiles", cfg.TileCache.R2Bucket)
+}
+
+func TestLoad_Lists(t *testing.T) {
+	file := writeFile(t, "")
+	lookupEnv := env(map[string]string{
+		"TILE_CACHE_BACKEND": "memory",
+		"TILE_SIDES":         "64, 256,",
+		"CORS_ORIGINS":       "https://a.example,https://b.example",
+		"ADMIN_USER_IDS":     " usr_1 ,usr_2",
+	})
+
+	cfg, _, err := config.Load([]string{"-config", file}, lookupEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{64, 256}, cfg.TileSides)
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, cfg.CORSOrigins)
+	assert.Equal(t, []string{"usr_1", "usr_2"}, cfg.AdminUserIDs)
+}
+
+func TestLoad_Subcommand(t *testing.T) {
+	file := writeFile(t, "TILE_CACHE_BACKEND=memory\n")
+
+	_, rest, err := config.Load([]string{"-config", file, "migrate", "down", "2"}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "down", "2"}, rest)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	file := writeFile(t, "")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "unparsable values",
+			args: []string{"-port", "http"},
+			env:  map[string]string{"PRECACHE_INTERVAL": "often", "TILE_SIDES": "64,big"},
+			want: []string{"PORT", "PRECACHE_INTERVAL", "TILE_SIDES"},
+		},
+		{
+			name: "r2 without credentials",
+			want: []string{"R2_ACCOUNT_ID", "R2_ACCESS_KEY_ID", "R2_ACCESS_KEY_SECRET", "R2_TILECACHE_BUCKET_NAME"},
+		},
+		{
+			name: "out of range",
+			env: map[string]string{
+				"TILE_CACHE_BACKEND": "memory",
+				"PORT":               "70000",
+				"MAX_TILE_SIDE":      "128",
+				"TILE_SIDES":         "64,256",
+				"DB_MAX_OPEN_CONNS":  "5",
+				"DB_MAX_IDLE_CONNS":  "10",
+				"DB_CONNECT_BACKOFF": "0s",
+			},
+			want: []string{"PORT", "TILE_SIDES: 256", "DB_MAX_IDLE_CONNS", "DB_CONNECT_BACKOFF"},
+		},
+		{
+			name: "unknown backend",
+			env:  map[string]string{"TILE_CACHE_BACKEND": "disk", "PIXEL_STORAGE": "blobs"},
+			want: []string{"TILE_CACHE_BACKEND", "PIXEL_STORAGE"},
+		},
+		{
+			name: "half-configured providers",
+			env: map[string]string{
+				"TILE_CACHE_BACKEND": "memory",
+				"SMTP_HOST":          "smtp.example",
+				"OIDC_ISSUER":        "https://id.example",
+			},
+			want: []string{"SMTP_FROM", "OIDC_CLIENT_ID", "OIDC_CLIENT_SECRET", "OIDC_REDIRECT_URL"},
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-colour", "red"},
+			want: []string{"colour"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := config.Load(append([]string{"-config", file}, tt.args...), env(tt.env))
+			assert.ErrorIs(t, err, config.ErrInvalid)
+			for _, want := range tt.want {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	_, _, err := config.Load([]string{"-config", filepath.Join(t.TempDir(), "missing.env")}, env(nil))
+	assert.ErrorIs(t, err, config.ErrInvalid)
+}
+
+func TestRead_SkipsValidation(t *testing.T) {
+	file := writeFile(t, "")
+
+	cfg, _, err := config.Read([]string{"-config", file}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, config.TileCacheR2, cfg.TileCache.Backend)
+	assert.Error(t, cfg.Validate())
+}
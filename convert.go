@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/lazharichir/draw/config"
+	"github.com/lazharichir/draw/storage"
+)
+
+const convertPixelsUsage = `usage: main [flags] convert-pixels [-delete] canvas_id...
+
+Copies the canvases' pixels from the row store to the chunked one, see PIXEL_STORAGE.
+It can be rerun: pixels already in chunks are only replaced by more recently drawn ones.
+
+  -delete   delete the converted rows from the row store`
+
+// runConvertPixels runs the convert-pixels subcommand and returns the process exit code.
+func runConvertPixels(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("convert-pixels", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, convertPixelsUsage) }
+	deleteRows := flags.Bool("delete", false, "delete the converted rows")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var canvasIDs []int64
+	for _, arg := range flags.Args() {
+		canvasID, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, convertPixelsUsage)
+			return 2
+		}
+		canvasIDs = append(canvasIDs, canvasID)
+	}
+	if len(canvasIDs) == 0 {
+		fmt.Fprintln(os.Stderr, convertPixelsUsage)
+		return 2
+	}
+
+	ctx := context.Background()
+	db, err := storage.OpenPG(ctx, cfg.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	for _, canvasID := range canvasIDs {
+		stats, err := storage.ConvertCanvasToChunks(ctx, db, canvasID, *deleteRows)
+		fmt.Printf("canvas %d: converted %d pixels into %d chunks\n", canvasID, stats.Pixels, stats.Chunks)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,54 @@
+package core
+
+import "time"
+
+// AccountDeletionCoolingOff is how long users have to change their mind after
+// asking for their account to be deleted.
+const AccountDeletionCoolingOff = 14 * 24 * time.Hour
+
+const UserStatusDeleted = "deleted"
+
+// AccountDeletion is a user's request to be deleted. Their leases go to
+// TransferLeasesTo if set, and are terminated otherwise.
+type AccountDeletion struct {
+	UserID           UserID
+	TransferLeasesTo *UserID
+	RequestedAt      time.Time
+	ScheduledFor     time.Time
+	CanceledAt       *time.Time
+	CompletedAt      *time.Time
+	// Attempts counts the runs that failed to delete the account, the last one
+	// at LastAttemptAt with LastError.
+	Attempts      int
+	LastAttemptAt *time.Time
+	LastError     string
+}
+
+func NewAccountDeletion(userID UserID, transferLeasesTo *UserID, at time.Time) AccountDeletion {
+	return AccountDeletion{
+		UserID:           userID,
+		TransferLeasesTo: transferLeasesTo,
+		RequestedAt:      at,
+		ScheduledFor:     at.Add(AccountDeletionCoolingOff),
+	}
+}
+
+// IsPending reports whether the deletion is neither canceled nor completed.
+func (d AccountDeletion) IsPending() bool {
+	return d.CanceledAt == nil && d.CompletedAt == nil
+}
+
+// IsDueAt reports whether the deletion is pending and its cooling-off period is over.
+func (d AccountDeletion) IsDueAt(at time.Time) bool {
+	return d.IsPending() && !at.Before(d.ScheduledFor)
+}
+
+// Anonymize strips the user of anything that identifies them, keeping the row
+// so that what refers to it (leases, history) stays consistent.
+func (u *User) Anonymize() {
+	u.Status = UserStatusDeleted
+	u.StatusReason = ""
+	u.StatusUntil = nil
+	u.Username = "deleted_" + u.ID.String()
+	u.Email = u.ID.String() + "@deleted.invalid"
+}
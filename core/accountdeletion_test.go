@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountDeletion(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	d := NewAccountDeletion("usr_123", nil, now)
+
+	assert.True(t, d.IsPending())
+	assert.False(t, d.IsDueAt(now))
+	assert.False(t, d.IsDueAt(now.Add(AccountDeletionCoolingOff-time.Second)))
+	assert.True(t, d.IsDueAt(now.Add(AccountDeletionCoolingOff)))
+
+	canceled := now.Add(time.Hour)
+	d.CanceledAt = &canceled
+	assert.False(t, d.IsPending())
+	assert.False(t, d.IsDueAt(now.Add(30*24*time.Hour)))
+}
+
+func TestUser_Anonymize(t *testing.T) {
+	user := User{ID: "usr_123", Status: UserStatusActive, Username: "ada", Email: "ada@example.com"}
+	user.Anonymize()
+
+	assert.Equal(t, UserID("usr_123"), user.ID)
+	assert.Equal(t, UserStatusDeleted, user.Status)
+	assert.NotContains(t, user.Username, "ada")
+	assert.NotContains(t, user.Email, "ada")
+	assert.True(t, user.IsRestrictedAt(time.Now()))
+}
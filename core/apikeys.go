@@ -0,0 +1,148 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazharichir/draw/utils"
+)
+
+const (
+	apiKeyTokenPrefix = "dk_"
+
+	DefaultAPIKeyRateLimit = 60
+	MaxAPIKeyRateLimit     = 1200
+	MaxAPIKeysPerUser      = 10
+	MaxAPIKeyNameLength    = 100
+)
+
+const (
+	ScopeTilesRead    = "tiles:read"
+	ScopeLeasesManage = "leases:manage"
+	ScopeMonitor      = "system:monitor"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// CanvasDrawScope is the scope allowing to draw and erase on the canvas.
+func CanvasDrawScope(canvasID int64) string {
+	return fmt.Sprintf("canvas:%d:draw", canvasID)
+}
+
+// ValidateScope checks the scope is one of tiles:read, leases:manage,
+// system:monitor or canvas:{id}:draw.
+func ValidateScope(scope string) error {
+	switch scope {
+	case ScopeTilesRead, ScopeLeasesManage, ScopeMonitor:
+		return nil
+	}
+
+	parts := strings.Split(scope, ":")
+	if len(parts) == 3 && parts[0] == "canvas" && parts[2] == "draw" {
+		if _, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: unknown scope '%s'", ErrInvalidAPIKey, scope)
+}
+
+// APIKey lets a user's bots and integrations act on their behalf, within its scopes.
+// Only a hash of its secret is kept, the full token is shown once at creation.
+type APIKey struct {
+	ID                 string
+	UserID             UserID
+	Name               string
+	SecretHash         []byte
+	Scopes             []string
+	RateLimitPerMinute int
+	CreatedAt          time.Time
+	LastUsedAt         *time.Time
+	RevokedAt          *time.Time
+}
+
+// NewAPIKey returns the key and its token, dk_<id>_<secret>.
+func NewAPIKey(userID UserID, name string, scopes []string, rateLimitPerMinute int) (APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxAPIKeyNameLength {
+		return APIKey{}, "", fmt.Errorf("%w: the name must be 1 to %d characters long", ErrInvalidAPIKey, MaxAPIKeyNameLength)
+	}
+	if len(scopes) == 0 {
+		return APIKey{}, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKey)
+	}
+	for _, scope := range scopes {
+		if err := ValidateScope(scope); err != nil {
+			return APIKey{}, "", err
+		}
+	}
+	if rateLimitPerMinute == 0 {
+		rateLimitPerMinute = DefaultAPIKeyRateLimit
+	}
+	if rateLimitPerMinute < 0 || rateLimitPerMinute > MaxAPIKeyRateLimit {
+		return APIKey{}, "", fmt.Errorf("%w: the rate limit must be between 1 and %d requests per minute", ErrInvalidAPIKey, MaxAPIKeyRateLimit)
+	}
+
+	id := utils.NewAPIKeyID()
+	secret := utils.NewAPIKeySecret()
+	key := APIKey{
+		ID:                 id,
+		UserID:             userID,
+		Name:               name,
+		SecretHash:         hashAPIKeySecret(secret),
+		Scopes:             scopes,
+		RateLimitPerMinute: rateLimitPerMinute,
+		CreatedAt:          time.Now().UTC(),
+	}
+
+	return key, apiKeyTokenPrefix + id + "_" + secret, nil
+}
+
+// IsAPIKeyToken reports whether the bearer token looks like an API key rather than a session.
+func IsAPIKeyToken(token string) bool {
+	return strings.HasPrefix(token, apiKeyTokenPrefix)
+}
+
+// ParseAPIKeyToken splits a dk_<id>_<secret> token.
+func ParseAPIKeyToken(token string) (id string, secret string, err error) {
+	rest, ok := strings.CutPrefix(token, apiKeyTokenPrefix)
+	if !ok {
+		return "", "", ErrInvalidAPIKey
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", ErrInvalidAPIKey
+	}
+	return id, secret, nil
+}
+
+func hashAPIKeySecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func (k APIKey) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare(k.SecretHash, hashAPIKeySecret(secret)) == 1
+}
+
+func (k APIKey) IsActive() bool {
+	return k.RevokedAt == nil
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) Revoke() {
+	now := time.Now().UTC()
+	k.RevokedAt = &now
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, token, err := NewAPIKey("usr_123", "my bot", []string{ScopeTilesRead, CanvasDrawScope(7)}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAPIKeyRateLimit, key.RateLimitPerMinute)
+	assert.True(t, IsAPIKeyToken(token))
+	assert.True(t, key.HasScope("canvas:7:draw"))
+	assert.False(t, key.HasScope("canvas:8:draw"))
+
+	id, secret, err := ParseAPIKeyToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, id)
+	assert.True(t, key.Matches(secret))
+	assert.False(t, key.Matches(secret+"x"))
+	assert.NotContains(t, string(key.SecretHash), secret)
+
+	assert.True(t, key.IsActive())
+	key.Revoke()
+	assert.False(t, key.IsActive())
+}
+
+func TestNewAPIKey_Invalid(t *testing.T) {
+	cases := map[string]func() error{
+		"no name":       func() error { _, _, err := NewAPIKey("usr_123", " ", []string{ScopeTilesRead}, 0); return err },
+		"no scopes":     func() error { _, _, err := NewAPIKey("usr_123", "bot", nil, 0); return err },
+		"unknown scope": func() error { _, _, err := NewAPIKey("usr_123", "bot", []string{"canvas:x:draw"}, 0); return err },
+		"rate too high": func() error {
+			_, _, err := NewAPIKey("usr_123", "bot", []string{ScopeTilesRead}, MaxAPIKeyRateLimit+1)
+			return err
+		},
+	}
+	for name, newKey := range cases {
+		assert.True(t, errors.Is(newKey(), ErrInvalidAPIKey), name)
+	}
+}
+
+func TestParseAPIKeyToken(t *testing.T) {
+	for _, token := range []string{"", "ses_abc", "dk_", "dk_abc", "dk_abc_", "dk__secret"} {
+		_, _, err := ParseAPIKeyToken(token)
+		assert.Error(t, err, token)
+	}
+}
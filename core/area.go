@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -20,6 +21,25 @@ func NewAreaSquare(min Point, side int64) Area {
 	return NewArea(min, max)
 }
 
+// ParseArea parses an area written as "minX,minY,maxX,maxY".
+func ParseArea(str string) (Area, error) {
+	parts := strings.Split(str, ",")
+	if len(parts) != 4 {
+		return Area{}, fmt.Errorf("invalid area '%s': expected minX,minY,maxX,maxY", str)
+	}
+
+	coords := make([]int64, len(parts))
+	for i, part := range parts {
+		coord, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return Area{}, fmt.Errorf("invalid area '%s': %w", str, err)
+		}
+		coords[i] = coord
+	}
+
+	return NewArea(Pt(coords[0], coords[1]), Pt(coords[2], coords[3])), nil
+}
+
 type Area struct {
 	Min Point
 	Max Point
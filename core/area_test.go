@@ -34,6 +34,22 @@ func TestNewArea(t *testing.T) {
 	assert.Equal(t, expected, actual)
 }
 
+func TestParseArea(t *testing.T) {
+	{
+		// Test that an area is parsed and canonicalised.
+		actual, err := ParseArea("100, -20,-50,30")
+		assert.NoError(t, err)
+		assert.Equal(t, NewArea(Pt(-50, -20), Pt(100, 30)), actual)
+	}
+	{
+		// Test that malformed areas are rejected.
+		for _, str := range []string{"", "1,2,3", "1,2,3,4,5", "a,2,3,4"} {
+			_, err := ParseArea(str)
+			assert.Error(t, err, str)
+		}
+	}
+}
+
 func TestNewAreaWH(t *testing.T) {
 	topLeft := Point{X: 0, Y: 0}
 	width := int64(10)
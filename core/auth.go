@@ -2,19 +2,41 @@ package core
 
 import (
 	"fmt"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/lazharichir/draw/utils"
 )
 
+// UserID identifies a user (e.g., usr_xxx, see utils.NewUserID) wherever one is
+// referenced: users, sessions, tokens, leases and drawn pixels.
+// The zero value stands for an anonymous user.
+type UserID string
+
+func NewUserID() UserID {
+	return UserID(utils.NewUserID())
+}
+
+func (id UserID) IsAnonymous() bool {
+	return id == ""
+}
+
+func (id UserID) String() string {
+	return string(id)
+}
+
 type User struct {
-	ID               string
-	Status           string // probation, active, suspended
+	ID               UserID
+	Status           string // probation, active, suspended, banned or deleted, see UserStatus*
+	StatusReason     string
+	StatusUntil      *time.Time // end of a suspension or ban, nil if indefinite
 	Username         string
 	Email            string
 	CreatedAt        time.Time
 	LastSignedInAt   time.Time
 	LastDrawnPixelAt time.Time
+	PixelsDrawn      int64
 }
 
 type UserProfile struct {
@@ -33,7 +55,14 @@ type UserProfile struct {
 	YouTubeURL   string
 }
 
-func NewVerificationToken(userID string, kind string) (VerificationToken, error) {
+const (
+	VerificationTokenKindSignup        = "signup"
+	VerificationTokenKindSignin        = "signin"
+	VerificationTokenKindChangeEmail   = "change_email"
+	VerificationTokenKindResetPassword = "reset_password"
+)
+
+func NewVerificationToken(userID UserID, kind string) (VerificationToken, error) {
 	vt := VerificationToken{
 		Kind:      kind,
 		UserID:    userID,
@@ -42,16 +71,16 @@ func NewVerificationToken(userID string, kind string) (VerificationToken, error)
 	}
 
 	switch kind {
-	case "signup":
+	case VerificationTokenKindSignup:
 		vt.Token = utils.NewVerificationTokenSignup()
 		vt.ExpiresAt = vt.CreatedAt.Add(30 * time.Minute)
-	case "signin":
+	case VerificationTokenKindSignin:
 		vt.Token = utils.NewVerificationTokenSignin()
 		vt.ExpiresAt = vt.CreatedAt.Add(30 * time.Minute)
-	case "change_email":
+	case VerificationTokenKindChangeEmail:
 		vt.Token = utils.NewVerificationTokenChangeEmail()
 		vt.ExpiresAt = vt.CreatedAt.Add(30 * time.Minute)
-	case "reset_password":
+	case VerificationTokenKindResetPassword:
 		vt.Token = utils.NewVerificationTokenResetPassword()
 		vt.ExpiresAt = vt.CreatedAt.Add(30 * time.Minute)
 	default:
@@ -64,7 +93,7 @@ func NewVerificationToken(userID string, kind string) (VerificationToken, error)
 type VerificationToken struct {
 	Token     string
 	Kind      string
-	UserID    string
+	UserID    UserID
 	Email     *string
 	CreatedAt time.Time
 	ExpiresAt time.Time
@@ -91,3 +120,75 @@ func (vt VerificationToken) hasBeenUsed() bool {
 func (vt VerificationToken) hasExpired() bool {
 	return vt.ExpiresAt.Before(time.Now())
 }
+
+// NormalizeEmail validates an email address and returns it trimmed and lowercased.
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		return "", fmt.Errorf("invalid email address '%s'", email)
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// UsernameFromEmail derives a username from the local part of an email address,
+// keeping lowercase letters, digits and underscores only.
+func UsernameFromEmail(email string) string {
+	local, _, _ := strings.Cut(email, "@")
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(local) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		case r == '.' || r == '-' || r == '+':
+			b.WriteRune('_')
+		}
+		if b.Len() == 20 {
+			break
+		}
+	}
+
+	username := strings.Trim(b.String(), "_")
+	if username == "" {
+		return "user"
+	}
+	return username
+}
+
+func NewSession(userID UserID) Session {
+	now := time.Now()
+	return Session{
+		Token:     utils.NewSessionToken(),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(30 * 24 * time.Hour),
+	}
+}
+
+// Session is a signed-in user's opaque bearer token.
+type Session struct {
+	Token     string
+	UserID    UserID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+func (s Session) IsActive() bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(time.Now())
+}
+
+func (s *Session) Revoke() {
+	now := time.Now()
+	s.RevokedAt = &now
+}
+
+// UserIdentity links a user to their account at an external OpenID Connect provider.
+type UserIdentity struct {
+	Provider       string
+	Subject        string
+	UserID         UserID
+	Email          string
+	CreatedAt      time.Time
+	LastSignedInAt time.Time
+}
@@ -1 +1,82 @@
 package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewVerificationToken(t *testing.T) {
+	vt, err := NewVerificationToken(UserID("usr_123"), VerificationTokenKindSignin)
+	assert.NoError(t, err)
+	assert.Equal(t, UserID("usr_123"), vt.UserID)
+	assert.NotEmpty(t, vt.Token)
+	assert.True(t, vt.IsActive())
+
+	vt.Used()
+	assert.False(t, vt.IsActive())
+
+	_, err = NewVerificationToken(UserID("usr_123"), "unknown")
+	assert.Error(t, err)
+}
+
+func TestVerificationToken_Expired(t *testing.T) {
+	vt, err := NewVerificationToken(UserID("usr_123"), VerificationTokenKindSignup)
+	assert.NoError(t, err)
+
+	vt.ExpiresAt = time.Now().Add(-time.Second)
+	assert.False(t, vt.IsActive())
+}
+
+func TestUserID(t *testing.T) {
+	id := NewUserID()
+	assert.Equal(t, "usr_", id.String()[:4])
+	assert.False(t, id.IsAnonymous())
+	assert.True(t, UserID("").IsAnonymous())
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := NormalizeEmail("  Jane.Doe@Example.COM ")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", email)
+
+	for _, invalid := range []string{"", "jane", "jane@", "Jane <jane@example.com>"} {
+		_, err := NormalizeEmail(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestUsernameFromEmail(t *testing.T) {
+	testCases := []struct {
+		email    string
+		expected string
+	}{
+		{"jane.doe@example.com", "jane_doe"},
+		{"Jane+draw@example.com", "jane_draw"},
+		{"a-very-long-local-part-indeed@example.com", "a_very_long_local_pa"},
+		{"...@example.com", "user"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.email, func(t *testing.T) {
+			assert.Equal(t, tc.expected, UsernameFromEmail(tc.email))
+		})
+	}
+}
+
+func TestSession_IsActive(t *testing.T) {
+	session := NewSession(UserID("usr_123"))
+	assert.Equal(t, UserID("usr_123"), session.UserID)
+	assert.True(t, session.IsActive())
+
+	session.ExpiresAt = time.Now().Add(-time.Second)
+	assert.False(t, session.IsActive())
+}
+
+func TestSession_Revoke(t *testing.T) {
+	session := NewSession(UserID("usr_123"))
+	session.Revoke()
+	assert.NotNil(t, session.RevokedAt)
+	assert.False(t, session.IsActive())
+}
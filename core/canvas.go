@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+var ErrInvalidTileSide = errors.New("invalid tile side")
+
+// DefaultTileSides are the square tile sides a canvas serves unless it is configured otherwise.
+var DefaultTileSides = []int64{256, 512, 1024}
+
+// DefaultMaxTileSide is the largest tile side a canvas serves unless it is configured otherwise.
+const DefaultMaxTileSide int64 = 1024
+
+func NewCanvas(id int64) Canvas {
+	return Canvas{
+		ID:          id,
+		TileSides:   slices.Clone(DefaultTileSides),
+		MaxTileSide: DefaultMaxTileSide,
+		TileFormats: slices.Clone(DefaultTileFormats),
+	}
+}
+
+// Canvas holds the per-canvas settings that define its canonical tile grid.
+// A canonical tile is a square whose side is one of TileSides (and not larger
+// than MaxTileSide) and whose origin is aligned on a multiple of that side.
+// Tiles are served in one of TileFormats, the first being the default.
+type Canvas struct {
+	ID          int64
+	TileSides   []int64
+	MaxTileSide int64
+	TileFormats []TileFormat
+}
+
+// IsTileSide returns true if the canvas serves tiles with the given side.
+func (c Canvas) IsTileSide(side int64) bool {
+	if side <= 0 || side > c.MaxTileSide {
+		return false
+	}
+	return slices.Contains(c.TileSides, side)
+}
+
+// CanonicalTile returns the canonical tile of the given side that contains the point.
+func (c Canvas) CanonicalTile(pt Point, side int64) (Area, error) {
+	if !c.IsTileSide(side) {
+		return Area{}, fmt.Errorf("%w: %d is not one of %v (max %d)", ErrInvalidTileSide, side, c.TileSides, c.MaxTileSide)
+	}
+	return GetTileAreaFromPoint(pt, side), nil
+}
+
+// IsCanonicalTile returns true if the area is a tile of the canvas's grid.
+func (c Canvas) IsCanonicalTile(area Area) bool {
+	if !area.IsSquare() {
+		return false
+	}
+	canonical, err := c.CanonicalTile(area.Min, area.Width())
+	if err != nil {
+		return false
+	}
+	return canonical.Equal(area)
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanvas_IsTileSide(t *testing.T) {
+	canvas := Canvas{ID: 1, TileSides: []int64{256, 512, 1024, 2048}, MaxTileSide: 1024}
+
+	testCases := []struct {
+		name     string
+		side     int64
+		expected bool
+	}{
+		{"configured side", 256, true},
+		{"max side", 1024, true},
+		{"configured side above max", 2048, false},
+		{"unconfigured side", 300, false},
+		{"zero", 0, false},
+		{"negative", -256, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, canvas.IsTileSide(tc.side))
+		})
+	}
+}
+
+func TestCanvas_CanonicalTile(t *testing.T) {
+	canvas := NewCanvas(0)
+
+	area, err := canvas.CanonicalTile(Pt(300, -5), 256)
+	assert.NoError(t, err)
+	assert.Equal(t, NewArea(Pt(256, -256), Pt(512, 0)), area)
+
+	_, err = canvas.CanonicalTile(Pt(300, -5), 100)
+	assert.True(t, errors.Is(err, ErrInvalidTileSide))
+}
+
+func TestCanvas_IsCanonicalTile(t *testing.T) {
+	canvas := NewCanvas(0)
+
+	testCases := []struct {
+		name     string
+		area     Area
+		expected bool
+	}{
+		{"aligned tile", NewAreaSquare(Pt(512, 1024), 512), true},
+		{"aligned negative tile", NewAreaSquare(Pt(-1024, -2048), 1024), true},
+		{"unaligned origin", NewAreaSquare(Pt(10, 0), 256), false},
+		{"unknown side", NewAreaSquare(Pt(0, 0), 100), false},
+		{"side above max", NewAreaSquare(Pt(0, 0), 4096), false},
+		{"not a square", NewAreaWH(Pt(0, 0), 256, 512), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, canvas.IsCanonicalTile(tc.area))
+		})
+	}
+}
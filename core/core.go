@@ -11,27 +11,12 @@ import (
 // e.g., GetTileAreaFromPoint(Point{X: 50, Y: 50}, 1024) returns tile area (0, 0) -> (1024, 1024)
 // e.g., GetTileAreaFromPoint(Point{X: 1600, Y: 1700}, 1024) returns tile area (1024, 1024) -> (2048, 2048)
 func GetTileAreaFromPoint(pt Point, side int64) Area {
-	// Calculate the tile coordinates.
-	tileX := pt.X / side
-	tileY := pt.Y / side
-
-	// Calculate the minimum and maximum coordinates of the tile.
-	minX := tileX * side
-	minY := tileY * side
-	maxX := minX + side
-	maxY := minY + side
-
-	// Adjust the minimum and maximum coordinates for negative coordinates.
-	if pt.X < 0 {
-		minX -= side
-		maxX -= side
-	}
-	if pt.Y < 0 {
-		minY -= side
-		maxY -= side
-	}
+	// Calculate the minimum coordinates of the tile, rounding towards negative
+	// infinity so that negative points land in the tile on their left/top.
+	minX := FloorDiv(pt.X, side) * side
+	minY := FloorDiv(pt.Y, side) * side
 
-	return Area{Min: Pt(minX, minY), Max: Pt(maxX, maxY)}
+	return Area{Min: Pt(minX, minY), Max: Pt(minX+side, minY+side)}
 }
 
 func GetTileAreasFromPoints(side int64, points ...Point) []Area {
@@ -23,6 +23,9 @@ func TestGetTileAreaFromPoint(t *testing.T) {
 		{"", Pt(512, 512), 1024, NewArea(Pt(0, 0), Pt(1024, 1024))},
 		{"", Pt(1600, 1700), 1024, NewArea(Pt(1024, 1024), Pt(2048, 2048))},
 		{"big point", Pt(122221, 2047), 1024, NewArea(Pt(121856, 1024), Pt(122880, 2048))},
+		{"negative point", Pt(-50, -50), 1024, NewArea(Pt(-1024, -1024), Pt(0, 0))},
+		{"negative tile origin", Pt(-1024, -1024), 1024, NewArea(Pt(-1024, -1024), Pt(0, 0))},
+		{"negative tile edge", Pt(-1025, -1), 1024, NewArea(Pt(-2048, -1024), Pt(-1024, 0))},
 	}
 
 	for _, tc := range testCases {
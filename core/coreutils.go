@@ -28,3 +28,13 @@ func Min(a, b int64) int64 {
 	}
 	return b
 }
+
+// FloorDiv divides a by b and rounds the result towards negative infinity,
+// unlike Go's integer division which truncates towards zero.
+func FloorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
@@ -88,3 +88,26 @@ func TestMin(t *testing.T) {
 		})
 	}
 }
+
+func TestFloorDiv(t *testing.T) {
+	testCases := []struct {
+		name     string
+		inputA   int64
+		inputB   int64
+		expected int64
+	}{
+		{"positive exact", 2048, 1024, 2},
+		{"positive remainder", 2047, 1024, 1},
+		{"zero", 0, 1024, 0},
+		{"negative exact", -1024, 1024, -1},
+		{"negative remainder", -1, 1024, -1},
+		{"negative remainder past one tile", -1025, 1024, -2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := FloorDiv(tc.inputA, tc.inputB)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
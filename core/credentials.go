@@ -0,0 +1,92 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lazharichir/draw/utils"
+)
+
+const (
+	MinPasswordLength = 10
+	// MaxPasswordLength is bcrypt's limit, longer passwords would be silently truncated.
+	MaxPasswordLength = 72
+	// MaxFailedPasswordAttempts is how many wrong passwords in a row lock the credential.
+	MaxFailedPasswordAttempts = 5
+	// PasswordLockoutDuration is how long a locked credential stays locked.
+	PasswordLockoutDuration = 15 * time.Minute
+)
+
+var ErrWeakPassword = errors.New("weak password")
+
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("%w: it must be at most %d bytes long", ErrWeakPassword, MaxPasswordLength)
+	}
+	return nil
+}
+
+func NewPasswordCredential(userID UserID, password string) (PasswordCredential, error) {
+	cred := PasswordCredential{UserID: userID}
+	if err := cred.SetPassword(password); err != nil {
+		return cred, err
+	}
+	return cred, nil
+}
+
+// PasswordCredential is a user's optional password, along with its brute-force lockout state.
+type PasswordCredential struct {
+	UserID         UserID
+	Hash           []byte
+	FailedAttempts int
+	LockedUntil    *time.Time
+	UpdatedAt      time.Time
+}
+
+// SetPassword replaces the password and clears the lockout state.
+func (c *PasswordCredential) SetPassword(password string) error {
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	c.Hash = hash
+	c.FailedAttempts = 0
+	c.LockedUntil = nil
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (c PasswordCredential) Matches(password string) bool {
+	return utils.CheckPassword(c.Hash, password)
+}
+
+func (c PasswordCredential) IsLockedAt(at time.Time) bool {
+	return c.LockedUntil != nil && c.LockedUntil.After(at)
+}
+
+// RecordFailure counts a wrong password and locks the credential once there were too many in a row.
+func (c *PasswordCredential) RecordFailure(at time.Time) {
+	c.FailedAttempts++
+	if c.FailedAttempts >= MaxFailedPasswordAttempts {
+		lockedUntil := at.Add(PasswordLockoutDuration)
+		c.LockedUntil = &lockedUntil
+		c.FailedAttempts = 0
+	}
+	c.UpdatedAt = at
+}
+
+// RecordSuccess clears the failed attempts after a correct password.
+func (c *PasswordCredential) RecordSuccess(at time.Time) {
+	c.FailedAttempts = 0
+	c.LockedUntil = nil
+	c.UpdatedAt = at
+}
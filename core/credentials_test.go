@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, ValidatePassword("long enough"))
+	assert.True(t, errors.Is(ValidatePassword("short"), ErrWeakPassword))
+	assert.True(t, errors.Is(ValidatePassword(strings.Repeat("a", 73)), ErrWeakPassword))
+}
+
+func TestPasswordCredential_Matches(t *testing.T) {
+	cred, err := NewPasswordCredential("usr_123", "correct horse battery staple")
+	assert.NoError(t, err)
+	assert.Equal(t, UserID("usr_123"), cred.UserID)
+	assert.True(t, cred.Matches("correct horse battery staple"))
+	assert.False(t, cred.Matches("wrong horse battery staple"))
+
+	_, err = NewPasswordCredential("usr_123", "short")
+	assert.Error(t, err)
+}
+
+func TestPasswordCredential_Lockout(t *testing.T) {
+	cred := PasswordCredential{UserID: "usr_123"}
+	now := time.Now()
+
+	for i := 1; i < MaxFailedPasswordAttempts; i++ {
+		cred.RecordFailure(now)
+		assert.Equal(t, i, cred.FailedAttempts)
+		assert.False(t, cred.IsLockedAt(now))
+	}
+
+	cred.RecordFailure(now)
+	assert.True(t, cred.IsLockedAt(now))
+	assert.True(t, cred.IsLockedAt(now.Add(PasswordLockoutDuration-time.Second)))
+	assert.False(t, cred.IsLockedAt(now.Add(PasswordLockoutDuration)))
+
+	cred.RecordSuccess(now)
+	assert.Equal(t, 0, cred.FailedAttempts)
+	assert.False(t, cred.IsLockedAt(now))
+}
+
+func TestPasswordCredential_SetPasswordUnlocks(t *testing.T) {
+	cred, err := NewPasswordCredential("usr_123", "correct horse battery staple")
+	assert.NoError(t, err)
+
+	for i := 0; i < MaxFailedPasswordAttempts; i++ {
+		cred.RecordFailure(time.Now())
+	}
+	assert.True(t, cred.IsLockedAt(time.Now()))
+
+	assert.NoError(t, cred.SetPassword("another long password"))
+	assert.False(t, cred.IsLockedAt(time.Now()))
+	assert.True(t, cred.Matches("another long password"))
+}
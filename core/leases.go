@@ -15,7 +15,7 @@ const (
 
 type Lease struct {
 	ID            string
-	LeaseholderID int64
+	LeaseholderID UserID
 	CanvasID      int64
 	Area          Area
 	Status        LeaseStatus
@@ -24,11 +24,28 @@ type Lease struct {
 	Price         int64
 	Metadata      Metadata
 	UpdatedAt     time.Time
-	UpdatedBy     int64
+	UpdatedBy     UserID
 	CreatedAt     time.Time
-	CreatedBy     int64
+	CreatedBy     UserID
 }
 
 func (l Lease) IsActiveAt(at time.Time) bool {
 	return l.Status == LeaseStatusActive && at.After(l.Start) && at.Before(l.End)
 }
+
+// Terminate ends the lease early.
+func (l *Lease) Terminate(at time.Time, by UserID) {
+	l.Status = LeaseStatusTerminated
+	if l.End.After(at) {
+		l.End = at
+	}
+	l.UpdatedAt = at
+	l.UpdatedBy = by
+}
+
+// TransferTo hands the lease over to another leaseholder, on the same terms.
+func (l *Lease) TransferTo(leaseholderID UserID, at time.Time, by UserID) {
+	l.LeaseholderID = leaseholderID
+	l.UpdatedAt = at
+	l.UpdatedBy = by
+}
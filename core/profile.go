@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxBioLength      = 500
+	MaxNameLength     = 100
+	MaxGenderLength   = 50
+	MaxProfileURLSize = 2048
+)
+
+var ErrInvalidProfile = errors.New("invalid profile")
+
+// socialHosts lists the domains each social link must point to (subdomains included).
+var socialHosts = map[string][]string{
+	"facebook_url":  {"facebook.com", "fb.com"},
+	"twitter_url":   {"twitter.com", "x.com"},
+	"instagram_url": {"instagram.com"},
+	"linkedin_url":  {"linkedin.com"},
+	"tiktok_url":    {"tiktok.com"},
+	"youtube_url":   {"youtube.com", "youtu.be"},
+}
+
+// DisplayName is the user's full name if they gave one, and their username otherwise.
+func (p UserProfile) DisplayName(username string) string {
+	name := strings.TrimSpace(p.FirstName + " " + p.LastName)
+	if name == "" {
+		return username
+	}
+	return name
+}
+
+// Normalize trims surrounding whitespace from every text field.
+func (p *UserProfile) Normalize() {
+	for _, field := range []*string{
+		&p.FirstName, &p.LastName, &p.Gender, &p.Bio,
+		&p.WebsiteURL, &p.FacebookURL, &p.TwitterURL, &p.InstagramURL, &p.LinkedInURL, &p.TikTokURL, &p.YouTubeURL,
+	} {
+		*field = strings.TrimSpace(*field)
+	}
+}
+
+// Validate checks field lengths, that the date of birth is in the past and that
+// links are absolute http(s) URLs, social ones pointing to their network.
+func (p UserProfile) Validate() error {
+	if utf8.RuneCountInString(p.FirstName) > MaxNameLength || utf8.RuneCountInString(p.LastName) > MaxNameLength {
+		return fmt.Errorf("%w: names must be at most %d characters long", ErrInvalidProfile, MaxNameLength)
+	}
+	if utf8.RuneCountInString(p.Gender) > MaxGenderLength {
+		return fmt.Errorf("%w: gender must be at most %d characters long", ErrInvalidProfile, MaxGenderLength)
+	}
+	if utf8.RuneCountInString(p.Bio) > MaxBioLength {
+		return fmt.Errorf("%w: bio must be at most %d characters long", ErrInvalidProfile, MaxBioLength)
+	}
+	if !p.DateOfBirth.IsZero() && p.DateOfBirth.After(time.Now()) {
+		return fmt.Errorf("%w: date of birth is in the future", ErrInvalidProfile)
+	}
+
+	if err := validateProfileURL("website_url", p.WebsiteURL, nil); err != nil {
+		return err
+	}
+
+	for field, value := range map[string]string{
+		"facebook_url":  p.FacebookURL,
+		"twitter_url":   p.TwitterURL,
+		"instagram_url": p.InstagramURL,
+		"linkedin_url":  p.LinkedInURL,
+		"tiktok_url":    p.TikTokURL,
+		"youtube_url":   p.YouTubeURL,
+	} {
+		if err := validateProfileURL(field, value, socialHosts[field]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateProfileURL(field string, value string, hosts []string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > MaxProfileURLSize {
+		return fmt.Errorf("%w: %s is too long", ErrInvalidProfile, field)
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("%w: %s must be an http or https link", ErrInvalidProfile, field)
+	}
+
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s must link to %s", ErrInvalidProfile, field, strings.Join(hosts, " or "))
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleAdmin       Role = "admin"
+	RoleModerator   Role = "moderator"
+	RoleCanvasOwner Role = "canvas_owner"
+	RoleUser        Role = "user"
+)
+
+type Permission string
+
+const (
+	PermissionDraw          Permission = "canvas:draw"
+	PermissionImportImage   Permission = "canvas:import"
+	PermissionManageLeases  Permission = "leases:manage"
+	PermissionPrecache      Permission = "tiles:precache"
+	PermissionModerateUsers Permission = "users:moderate"
+	PermissionManageRoles   Permission = "roles:manage"
+	PermissionMonitor       Permission = "system:monitor"
+	PermissionBackupCanvas  Permission = "canvas:backup"
+)
+
+// RolePermissions lists what each role is allowed to do. A role granted on a
+// canvas only allows it on that canvas.
+var RolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermissionDraw, PermissionImportImage, PermissionManageLeases,
+		PermissionPrecache, PermissionModerateUsers, PermissionManageRoles,
+		PermissionMonitor, PermissionBackupCanvas,
+	},
+	RoleModerator:   {PermissionDraw, PermissionModerateUsers},
+	RoleCanvasOwner: {PermissionDraw, PermissionImportImage, PermissionManageLeases},
+	RoleUser:        {PermissionDraw},
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := RolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role '%s'", s)
+	}
+	return role, nil
+}
+
+// IsGlobal reports whether the role can only be granted globally, since what
+// it allows is not about any one canvas.
+func (r Role) IsGlobal() bool {
+	return r == RoleAdmin || r == RoleModerator
+}
+
+func (r Role) Allows(perm Permission) bool {
+	for _, p := range RolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleGrant gives a user a role everywhere, or on a single canvas if CanvasID is set.
+type RoleGrant struct {
+	UserID    UserID
+	Role      Role
+	CanvasID  *int64
+	GrantedAt time.Time
+	GrantedBy UserID
+}
+
+// Allows reports whether the grant allows the permission on the canvas, or
+// globally if canvasID is nil. Canvas grants never allow global permissions.
+func (g RoleGrant) Allows(perm Permission, canvasID *int64) bool {
+	if g.CanvasID != nil && (canvasID == nil || *g.CanvasID != *canvasID) {
+		return false
+	}
+	return g.Role.Allows(perm)
+}
+
+type RoleGrants []RoleGrant
+
+func (grants RoleGrants) Allow(perm Permission, canvasID *int64) bool {
+	for _, g := range grants {
+		if g.Allows(perm, canvasID) {
+			return true
+		}
+	}
+	return false
+}
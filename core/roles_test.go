@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleGrants_Allow(t *testing.T) {
+	canvas1, canvas2 := int64(1), int64(2)
+
+	grants := RoleGrants{
+		{UserID: "usr_123", Role: RoleUser},
+		{UserID: "usr_123", Role: RoleCanvasOwner, CanvasID: &canvas1},
+	}
+
+	assert.True(t, grants.Allow(PermissionDraw, nil))
+	assert.True(t, grants.Allow(PermissionDraw, &canvas2))
+	assert.True(t, grants.Allow(PermissionImportImage, &canvas1))
+	assert.False(t, grants.Allow(PermissionImportImage, &canvas2))
+	assert.False(t, grants.Allow(PermissionImportImage, nil))
+	assert.False(t, grants.Allow(PermissionPrecache, &canvas1))
+
+	admin := RoleGrants{{UserID: "usr_456", Role: RoleAdmin}}
+	assert.True(t, admin.Allow(PermissionPrecache, nil))
+	assert.True(t, admin.Allow(PermissionImportImage, &canvas2))
+	assert.True(t, admin.Allow(PermissionManageRoles, nil))
+	assert.True(t, admin.Allow(PermissionMonitor, nil))
+	assert.True(t, admin.Allow(PermissionBackupCanvas, &canvas1))
+	assert.False(t, grants.Allow(PermissionBackupCanvas, &canvas1))
+	assert.False(t, grants.Allow(PermissionMonitor, nil))
+
+	assert.False(t, RoleGrants{}.Allow(PermissionDraw, nil))
+}
+
+func TestRole_IsGlobal(t *testing.T) {
+	assert.True(t, RoleAdmin.IsGlobal())
+	assert.True(t, RoleModerator.IsGlobal())
+	assert.False(t, RoleCanvasOwner.IsGlobal())
+	assert.False(t, RoleUser.IsGlobal())
+}
+
+func TestParseRole(t *testing.T) {
+	role, err := ParseRole("moderator")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleModerator, role)
+
+	_, err = ParseRole("owner")
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"image"
 	"image/color"
+	"image/draw"
 )
 
 func NewTile(area Area) Tile {
@@ -54,3 +55,19 @@ func (t Tile) AsImage() image.Image {
 
 	return img
 }
+
+// DrawOnto returns a copy of a previously rendered image of the tile with the
+// tile's pixels written over it. Pixels are set, not blended, so a transparent
+// pixel clears whatever was there before.
+func (t Tile) DrawOnto(base image.Image) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, int(t.Width()), int(t.Height())))
+	draw.Draw(img, img.Bounds(), base, base.Bounds().Min, draw.Src)
+
+	for _, pixel := range t.Pixels {
+		localX := pixel.X - t.GetMinX()
+		localY := pixel.Y - t.GetMinY()
+		img.SetRGBA(int(localX), int(localY), pixel.RGBA)
+	}
+
+	return img
+}
@@ -79,3 +79,30 @@ func TestTile_AsImage(t *testing.T) {
 	actual := tile.AsImage().At(0, 0)
 	assert.Equal(t, expected, actual)
 }
+
+func TestTile_DrawOnto(t *testing.T) {
+	base := NewTilePWH(Pt(-10, 20), 4, 4)
+	base.AddPixels(
+		NewPixel(-10, 20, color.RGBA{R: 255, G: 0, B: 0, A: 255}),
+		NewPixel(-9, 21, color.RGBA{R: 0, G: 255, B: 0, A: 255}),
+		NewPixel(-8, 22, color.RGBA{R: 0, G: 0, B: 255, A: 255}),
+	)
+	baseImg := base.AsImage()
+
+	delta := NewTilePWH(Pt(-10, 20), 4, 4)
+	delta.AddPixels(
+		NewPixel(-9, 21, color.RGBA{R: 0, G: 0, B: 0, A: 0}),
+		NewPixel(-7, 23, color.RGBA{R: 10, G: 20, B: 30, A: 255}),
+		NewPixel(-6, 24, color.RGBA{R: 10, G: 20, B: 30, A: 255}), // outside of the tile
+	)
+	img := delta.DrawOnto(baseImg)
+
+	assert.Equal(t, baseImg.Bounds(), img.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, G: 0, B: 0, A: 255}, img.At(0, 0))
+	assert.Equal(t, color.RGBA{R: 0, G: 0, B: 0, A: 0}, img.At(1, 1))
+	assert.Equal(t, color.RGBA{R: 0, G: 0, B: 255, A: 255}, img.At(2, 2))
+	assert.Equal(t, color.RGBA{R: 10, G: 20, B: 30, A: 255}, img.At(3, 3))
+
+	// the base image is left untouched
+	assert.Equal(t, color.RGBA{R: 0, G: 255, B: 0, A: 255}, baseImg.At(1, 1))
+}
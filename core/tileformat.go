@@ -0,0 +1,125 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+
+	"github.com/lazharichir/draw/utils"
+)
+
+var ErrUnsupportedTileFormat = errors.New("unsupported tile format")
+
+type TileFormat string
+
+const (
+	// TileFormatPNG is a compressed png.
+	TileFormatPNG TileFormat = "png"
+	// TileFormatPalettedPNG is a palette-indexed png, or a compressed png when the tile has more than 256 colors.
+	TileFormatPalettedPNG TileFormat = "png8"
+	// TileFormatRGBA is the raw RGBA format read by the canvas renderer (see utils.EncodeRGBA).
+	TileFormatRGBA TileFormat = "rgba"
+)
+
+// DefaultTileFormats are the formats a canvas serves unless it is configured otherwise.
+var DefaultTileFormats = []TileFormat{TileFormatPalettedPNG, TileFormatPNG, TileFormatRGBA}
+
+func ParseTileFormat(str string) (TileFormat, error) {
+	switch f := TileFormat(strings.ToLower(str)); f {
+	case TileFormatPNG, TileFormatPalettedPNG, TileFormatRGBA:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w '%s'", ErrUnsupportedTileFormat, str)
+	}
+}
+
+func (f TileFormat) ContentType() string {
+	if f == TileFormatRGBA {
+		return "application/x-draw-rgba"
+	}
+	return "image/png"
+}
+
+// Ext is the extension of the tile's objects in the tile cache.
+func (f TileFormat) Ext() string {
+	return string(f)
+}
+
+func (f TileFormat) Encode(img image.Image) ([]byte, error) {
+	switch f {
+	case TileFormatPNG:
+		return utils.EncodePNG(img, png.DefaultCompression)
+	case TileFormatPalettedPNG:
+		if paletted, ok := utils.ToPaletted(img); ok {
+			return utils.EncodePNG(paletted, png.BestCompression)
+		}
+		return utils.EncodePNG(img, png.DefaultCompression)
+	case TileFormatRGBA:
+		return utils.EncodeRGBA(img), nil
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrUnsupportedTileFormat, f)
+	}
+}
+
+// NegotiateTileFormat picks the format to serve a tile in. An explicitly requested
+// format wins, otherwise the first of the canvas's formats acceptable per the
+// Accept header is used, in the canvas's order of preference.
+func (c Canvas) NegotiateTileFormat(requested string, accept string) (TileFormat, error) {
+	if len(c.TileFormats) == 0 {
+		return "", ErrUnsupportedTileFormat
+	}
+
+	if requested != "" {
+		f, err := ParseTileFormat(requested)
+		if err != nil {
+			return "", err
+		}
+		for _, served := range c.TileFormats {
+			if served == f {
+				return f, nil
+			}
+		}
+		return "", fmt.Errorf("%w '%s' on canvas %d", ErrUnsupportedTileFormat, requested, c.ID)
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return c.TileFormats[0], nil
+	}
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if isZeroQuality(params[1:]) {
+			continue
+		}
+		for _, served := range c.TileFormats {
+			if mediaTypeMatches(mediaType, served.ContentType()) {
+				return served, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%w for '%s'", ErrUnsupportedTileFormat, accept)
+}
+
+func isZeroQuality(params []string) bool {
+	for _, param := range params {
+		param = strings.ReplaceAll(strings.TrimSpace(param), " ", "")
+		if param == "q=0" || strings.HasPrefix(param, "q=0.") && strings.Trim(param[4:], "0") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func mediaTypeMatches(mediaRange, contentType string) bool {
+	if mediaRange == "*/*" || mediaRange == contentType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/lazharichir/draw/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTileFormat(t *testing.T) {
+	f, err := ParseTileFormat("PNG8")
+	assert.NoError(t, err)
+	assert.Equal(t, TileFormatPalettedPNG, f)
+
+	_, err = ParseTileFormat("webp")
+	assert.True(t, errors.Is(err, ErrUnsupportedTileFormat))
+}
+
+func TestTileFormat_Encode(t *testing.T) {
+	tile := NewTilePWH(Pt(0, 0), 8, 8)
+	tile.NewPixel(1, 1, color.RGBA{R: 255, G: 0, B: 0, A: 255})
+	img := tile.AsImage()
+
+	for _, f := range []TileFormat{TileFormatPNG, TileFormatPalettedPNG} {
+		data, err := f.Encode(img)
+		assert.NoError(t, err)
+		decoded, err := png.Decode(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.True(t, utils.CompareColors(img.At(1, 1), decoded.At(1, 1)), "format %s", f)
+		assert.True(t, utils.CompareColors(img.At(2, 2), decoded.At(2, 2)), "format %s", f)
+	}
+
+	// a tile with few colors is palette-indexed
+	data, err := TileFormatPalettedPNG.Encode(img)
+	assert.NoError(t, err)
+	decoded, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.IsType(t, &image.Paletted{}, decoded)
+
+	data, err = TileFormatRGBA.Encode(img)
+	assert.NoError(t, err)
+	raw, err := utils.DecodeRGBA(data)
+	assert.NoError(t, err)
+	assert.Equal(t, color.RGBA{R: 255, G: 0, B: 0, A: 255}, raw.At(1, 1))
+}
+
+func TestCanvas_NegotiateTileFormat(t *testing.T) {
+	canvas := Canvas{ID: 1, TileFormats: []TileFormat{TileFormatPNG, TileFormatRGBA}}
+
+	testCases := []struct {
+		name      string
+		requested string
+		accept    string
+		expected  TileFormat
+		err       bool
+	}{
+		{"default", "", "", TileFormatPNG, false},
+		{"any", "", "*/*", TileFormatPNG, false},
+		{"browser", "", "image/avif,image/webp,image/*,*/*;q=0.8", TileFormatPNG, false},
+		{"raw rgba", "", "application/x-draw-rgba", TileFormatRGBA, false},
+		{"raw rgba first", "", "application/x-draw-rgba, image/png;q=0.5", TileFormatRGBA, false},
+		{"refused", "", "image/png;q=0, application/x-draw-rgba", TileFormatRGBA, false},
+		{"unacceptable", "", "image/webp", "", true},
+		{"requested", "rgba", "image/png", TileFormatRGBA, false},
+		{"requested but not served", "png8", "", "", true},
+		{"requested but unknown", "webp", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := canvas.NegotiateTileFormat(tc.requested, tc.accept)
+			if tc.err {
+				assert.True(t, errors.Is(err, ErrUnsupportedTileFormat))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
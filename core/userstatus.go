@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	UserStatusProbation = "probation"
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusBanned    = "banned"
+)
+
+var ErrUserRestricted = errors.New("account restricted")
+
+// IsRestrictedAt reports whether the user is suspended, banned or deleted at the
+// given time. Suspensions and bans with an end date lift themselves once it has passed.
+func (u User) IsRestrictedAt(at time.Time) bool {
+	if u.Status == UserStatusDeleted {
+		return true
+	}
+	if u.Status != UserStatusSuspended && u.Status != UserStatusBanned {
+		return false
+	}
+	return u.StatusUntil == nil || at.Before(*u.StatusUntil)
+}
+
+// IsOnProbationAt reports whether the user has the reduced limits of new accounts.
+// A suspension or ban that lapsed on its own puts the user back on probation
+// until they are promoted again.
+func (u User) IsOnProbationAt(at time.Time) bool {
+	switch u.Status {
+	case UserStatusProbation:
+		return true
+	case UserStatusSuspended, UserStatusBanned:
+		return !u.IsRestrictedAt(at)
+	}
+	return false
+}
+
+// CanSignInAt reports whether the user may start a new session at the given time.
+// Deleted users and banned ones cannot, suspended ones can but not write.
+func (u User) CanSignInAt(at time.Time) bool {
+	if u.Status == UserStatusDeleted {
+		return false
+	}
+	return u.Status != UserStatusBanned || !u.IsRestrictedAt(at)
+}
+
+// RestrictionError describes why the user cannot write, and until when.
+func (u User) RestrictionError() error {
+	err := fmt.Errorf("%w: %s", ErrUserRestricted, u.Status)
+	if u.StatusReason != "" {
+		err = fmt.Errorf("%w (%s)", err, u.StatusReason)
+	}
+	if u.StatusUntil != nil {
+		err = fmt.Errorf("%w until %s", err, u.StatusUntil.UTC().Format(time.RFC3339))
+	}
+	return err
+}
+
+// Suspend restricts the user until the given time, or indefinitely if it is nil.
+func (u *User) Suspend(reason string, until *time.Time) {
+	u.Status = UserStatusSuspended
+	u.StatusReason = reason
+	u.StatusUntil = until
+}
+
+// Ban restricts the user until the given time, or indefinitely if it is nil.
+func (u *User) Ban(reason string, until *time.Time) {
+	u.Status = UserStatusBanned
+	u.StatusReason = reason
+	u.StatusUntil = until
+}
+
+// CanBeReinstated reports whether the user has a suspension or ban to lift.
+func (u User) CanBeReinstated() bool {
+	return u.Status == UserStatusSuspended || u.Status == UserStatusBanned
+}
+
+// Reinstate lifts any suspension or ban.
+func (u *User) Reinstate() {
+	u.Status = UserStatusActive
+	u.StatusReason = ""
+	u.StatusUntil = nil
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserStatus(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	user := User{ID: "usr_123", Status: UserStatusProbation}
+	assert.False(t, user.IsRestrictedAt(now))
+	assert.True(t, user.IsOnProbationAt(now))
+
+	user.Suspend("spam", &later)
+	assert.True(t, user.IsRestrictedAt(now))
+	assert.False(t, user.IsOnProbationAt(now))
+	assert.True(t, errors.Is(user.RestrictionError(), ErrUserRestricted))
+	assert.Contains(t, user.RestrictionError().Error(), "spam")
+
+	// a lapsed suspension puts the user back on probation
+	assert.False(t, user.IsRestrictedAt(later.Add(time.Second)))
+	assert.True(t, user.IsOnProbationAt(later.Add(time.Second)))
+
+	assert.True(t, user.CanSignInAt(now), "suspended users can sign in")
+
+	user.Ban("vandalism", nil)
+	assert.True(t, user.IsRestrictedAt(now.Add(100*365*24*time.Hour)))
+	assert.False(t, user.CanSignInAt(now))
+
+	user.Ban("vandalism", &later)
+	assert.True(t, user.CanSignInAt(later.Add(time.Second)), "lapsed bans allow signing in")
+
+	assert.True(t, user.CanBeReinstated())
+	user.Reinstate()
+	assert.False(t, user.CanBeReinstated())
+	assert.Equal(t, UserStatusActive, user.Status)
+	assert.Nil(t, user.StatusUntil)
+	assert.False(t, user.IsRestrictedAt(now))
+	assert.False(t, user.IsOnProbationAt(now))
+	assert.True(t, user.CanSignInAt(now))
+
+	user.Status = UserStatusDeleted
+	assert.False(t, user.CanSignInAt(now))
+	assert.False(t, user.CanBeReinstated())
+
+	user.Status = UserStatusProbation
+	assert.False(t, user.CanBeReinstated())
+}
@@ -3,13 +3,14 @@ module github.com/lazharichir/draw
 go 1.21
 
 require (
+	github.com/aws/aws-sdk-go-v2 v1.21.0
 	github.com/go-chi/chi/v5 v5.0.8
 	github.com/go-chi/cors v1.2.1
 	github.com/huandu/go-sqlbuilder v1.21.0
+	golang.org/x/crypto v0.14.0
 )
 
 require (
-	github.com/aws/aws-sdk-go-v2 v1.21.0 // indirect
 	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.4.13 // indirect
 	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.13.11 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/configsources v1.1.41 // indirect
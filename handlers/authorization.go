@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+// Require rejects requests whose user lacks the permission globally. Requests
+// made with an API key also need the matching scope, see apiKeyScope.
+func (h *handlers) Require(perm core.Permission) func(http.Handler) http.Handler {
+	return h.require(perm, func(*http.Request) *int64 { return nil })
+}
+
+// RequireOnCanvas is Require on the canvas the request names (see
+// canvasFromRequest), and globally if it names none. Only routes acting on that
+// canvas may use it, since any canvas grant allows its permissions there.
+func (h *handlers) RequireOnCanvas(perm core.Permission) func(http.Handler) http.Handler {
+	return h.require(perm, canvasFromRequest)
+}
+
+func (h *handlers) require(perm core.Permission, canvasOf func(r *http.Request) *int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if currentUser(r) == nil {
+				respondError(w, http.StatusUnauthorized, errors.New("sign in required"))
+				return
+			}
+
+			canvasID := canvasOf(r)
+			if key := identityFromContext(r.Context()).APIKey; key != nil {
+				if scope := apiKeyScope(perm, canvasID); scope == "" || !key.HasScope(scope) {
+					respondError(w, http.StatusForbidden, services.ErrForbidden)
+					return
+				}
+			}
+
+			ok, err := h.authorizer.Can(r.Context(), currentUserID(r), perm, canvasID)
+			if err != nil {
+				fmt.Println("Require", perm, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			} else if !ok {
+				respondError(w, http.StatusForbidden, services.ErrForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiKeyScope is the API key scope a permission needs, or "" if API keys can never have it.
+func apiKeyScope(perm core.Permission, canvasID *int64) string {
+	switch perm {
+	case core.PermissionManageLeases:
+		return core.ScopeLeasesManage
+	case core.PermissionMonitor:
+		return core.ScopeMonitor
+	case core.PermissionDraw:
+		if canvasID != nil {
+			return core.CanvasDrawScope(*canvasID)
+		}
+	}
+	return ""
+}
+
+// canvasFromRequest returns the canvas a request is about, from the canvasID
+// route parameter or the cid query parameter, or nil if it has neither.
+func canvasFromRequest(r *http.Request) *int64 {
+	str := chi.URLParam(r, "canvasID")
+	if str == "" {
+		str = r.URL.Query().Get("cid")
+	}
+	canvasID, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &canvasID
+}
+
+type roleGrantBody struct {
+	Role      core.Role   `json:"role"`
+	CanvasID  *int64      `json:"canvas_id,omitempty"`
+	GrantedBy core.UserID `json:"granted_by,omitempty"`
+}
+
+func (h *handlers) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	grants, err := h.authorizer.Grants(r.Context(), core.UserID(chi.URLParam(r, "userID")))
+	if err != nil {
+		fmt.Println("GetUserRoles", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]roleGrantBody, len(grants))
+	for i, grant := range grants {
+		response[i] = roleGrantBody{Role: grant.Role, CanvasID: grant.CanvasID, GrantedBy: grant.GrantedBy}
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+func (h *handlers) GrantUserRole(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Role     string `json:"role"`
+		CanvasID *int64 `json:"canvas_id"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	role, err := core.ParseRole(body.Role)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.authorizer.Grant(r.Context(), currentUserID(r), core.UserID(chi.URLParam(r, "userID")), role, body.CanvasID)
+	if errors.Is(err, services.ErrGlobalRole) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if errors.Is(err, services.ErrUserNotFound) {
+		respondError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		fmt.Println("GrantUserRole", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeUserRole revokes /admin/users/{userID}/roles/{role}, on ?canvas_id= if given.
+func (h *handlers) RevokeUserRole(w http.ResponseWriter, r *http.Request) {
+	role, err := core.ParseRole(chi.URLParam(r, "role"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var canvasID *int64
+	if str := r.URL.Query().Get("canvas_id"); str != "" {
+		id, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid canvas_id '%s'", str))
+			return
+		}
+		canvasID = &id
+	}
+
+	if err := h.authorizer.Revoke(r.Context(), core.UserID(chi.URLParam(r, "userID")), role, canvasID); err != nil {
+		fmt.Println("RevokeUserRole", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
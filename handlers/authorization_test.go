@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdminRouter routes the admin endpoints like main does, for requests made by user.
+func newAdminRouter(h *handlers, user *core.User) http.Handler {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), identity{User: user})))
+		})
+	})
+	r.Route("/admin", func(r chi.Router) {
+		r.With(h.RequireOnCanvas(core.PermissionImportImage)).Get("/image", ok)
+		r.With(h.Require(core.PermissionModerateUsers)).Post("/users/{userID}/ban", ok)
+		r.With(h.Require(core.PermissionManageRoles)).Post("/users/{userID}/roles", h.GrantUserRole)
+	})
+	return r
+}
+
+func TestRequire_CanvasGrants(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.DB(t)
+	iam := storage.NewIAMStorePG()
+	h := &handlers{authorizer: services.NewAuthorizer(db, iam)}
+
+	canvasID := int64(1)
+	user := core.User{ID: core.NewUserID(), Status: core.UserStatusActive, CreatedAt: time.Now().UTC()}
+	user.Username = user.ID.String()
+	user.Email = user.ID.String() + "@example.com"
+	require.NoError(t, iam.SaveUser(ctx, db, user))
+	t.Cleanup(func() { iam.DeleteUserData(ctx, db, user.ID) })
+
+	// grants made on a canvas before global roles were refused there
+	for _, role := range []core.Role{core.RoleAdmin, core.RoleModerator, core.RoleCanvasOwner} {
+		require.NoError(t, iam.SaveRoleGrant(ctx, db, core.RoleGrant{UserID: user.ID, Role: role, CanvasID: &canvasID, GrantedAt: time.Now().UTC()}))
+	}
+	router := newAdminRouter(h, &user)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"on the granted canvas", http.MethodGet, "/admin/image?cid=1", "", http.StatusNoContent},
+		{"on another canvas", http.MethodGet, "/admin/image?cid=2", "", http.StatusForbidden},
+		{"moderating with a canvas", http.MethodPost, "/admin/users/usr_other/ban?cid=1", "", http.StatusForbidden},
+		{"granting admin to oneself with a canvas", http.MethodPost, "/admin/users/" + user.ID.String() + "/roles?cid=1", `{"role":"admin"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	grants, err := h.authorizer.Grants(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, grants.Allow(core.PermissionManageRoles, nil), "the user is still no global admin")
+}
+
+func TestGrantUserRole_GlobalRoleOnCanvas(t *testing.T) {
+	h := &handlers{authorizer: services.NewAuthorizer(nil, nil)}
+	admin := &core.User{ID: "usr_admin"}
+
+	for _, role := range []string{"admin", "moderator"} {
+		body := `{"role":"` + role + `","canvas_id":1}`
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/users/usr_other/roles", strings.NewReader(body))
+		r = r.WithContext(withIdentity(r.Context(), identity{User: admin}))
+		h.GrantUserRole(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code, role)
+	}
+}
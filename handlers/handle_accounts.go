@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+type accountDeletionResponse struct {
+	RequestedAt      time.Time    `json:"requested_at"`
+	ScheduledFor     time.Time    `json:"scheduled_for"`
+	TransferLeasesTo *core.UserID `json:"transfer_leases_to"`
+}
+
+func newAccountDeletionResponse(d core.AccountDeletion) accountDeletionResponse {
+	return accountDeletionResponse{
+		RequestedAt:      d.RequestedAt,
+		ScheduledFor:     d.ScheduledFor,
+		TransferLeasesTo: d.TransferLeasesTo,
+	}
+}
+
+func (h *handlers) ExportMyData(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="draw-%s.zip"`, user.ID))
+
+	// the archive is streamed, an error past this point can only cut it short
+	if err := h.accounts.Export(r.Context(), user.ID, w); err != nil {
+		fmt.Println("ExportMyData", err)
+	}
+}
+
+func (h *handlers) GetMyDeletion(w http.ResponseWriter, r *http.Request) {
+	deletion, err := h.accounts.GetDeletion(r.Context(), currentUserID(r))
+	if err != nil {
+		fmt.Println("GetMyDeletion", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if deletion == nil {
+		respondError(w, http.StatusNotFound, services.ErrNoPendingDeletion)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newAccountDeletionResponse(*deletion))
+}
+
+func (h *handlers) RequestMyDeletion(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TransferLeasesTo *core.UserID `json:"transfer_leases_to"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	deletion, err := h.accounts.RequestDeletion(r.Context(), currentUserID(r), body.TransferLeasesTo)
+	if errors.Is(err, services.ErrInvalidLeaseTransfer) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		fmt.Println("RequestMyDeletion", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, newAccountDeletionResponse(*deletion))
+}
+
+func (h *handlers) CancelMyDeletion(w http.ResponseWriter, r *http.Request) {
+	err := h.accounts.CancelDeletion(r.Context(), currentUserID(r))
+	if errors.Is(err, services.ErrNoPendingDeletion) {
+		respondError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		fmt.Println("CancelMyDeletion", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+type apiKeyResponse struct {
+	ID                 string     `json:"id"`
+	Name               string     `json:"name"`
+	Scopes             []string   `json:"scopes"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+	CreatedAt          time.Time  `json:"created_at"`
+	LastUsedAt         *time.Time `json:"last_used_at"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+	// Token is only ever returned once, when the key is created.
+	Token string `json:"token,omitempty"`
+}
+
+func newAPIKeyResponse(key core.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:                 key.ID,
+		Name:               key.Name,
+		Scopes:             key.Scopes,
+		RateLimitPerMinute: key.RateLimitPerMinute,
+		CreatedAt:          key.CreatedAt,
+		LastUsedAt:         key.LastUsedAt,
+		RevokedAt:          key.RevokedAt,
+	}
+}
+
+func (h *handlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.apiKeys.List(r.Context(), currentUserID(r))
+	if err != nil {
+		fmt.Println("ListAPIKeys", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]apiKeyResponse, len(keys))
+	for i, key := range keys {
+		response[i] = newAPIKeyResponse(key)
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+func (h *handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name               string   `json:"name"`
+		Scopes             []string `json:"scopes"`
+		RateLimitPerMinute int      `json:"rate_limit_per_minute"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	key, token, err := h.apiKeys.Create(r.Context(), currentUserID(r), body.Name, body.Scopes, body.RateLimitPerMinute)
+	if errors.Is(err, core.ErrInvalidAPIKey) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if errors.Is(err, services.ErrTooManyAPIKeys) {
+		respondError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		fmt.Println("CreateAPIKey", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := newAPIKeyResponse(*key)
+	response.Token = token
+	respondJSON(w, http.StatusCreated, response)
+}
+
+func (h *handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := h.apiKeys.Revoke(r.Context(), currentUserID(r), chi.URLParam(r, "keyID"))
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		respondError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		fmt.Println("RevokeAPIKey", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
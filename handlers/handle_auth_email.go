@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+const sessionCookieName = "draw_session"
+
+type sessionResponse struct {
+	UserID    core.UserID `json:"user_id"`
+	Username  string      `json:"username"`
+	Token     string      `json:"token"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+func setSessionCookie(w http.ResponseWriter, session core.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *handlers) RequestEmailSignIn(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := core.NormalizeEmail(body.Email); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.emailAuth.RequestSignIn(r.Context(), body.Email); err != nil {
+		fmt.Println("RequestEmailSignIn", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the response never tells whether the address belongs to a user
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handlers) RedeemEmailToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, session, err := h.emailAuth.Redeem(r.Context(), body.Token)
+	if errors.Is(err, services.ErrInvalidVerificationToken) {
+		respondError(w, http.StatusUnauthorized, err)
+		return
+	} else if errors.Is(err, services.ErrSignInDisabled) {
+		respondError(w, http.StatusForbidden, err)
+		return
+	} else if err != nil {
+		fmt.Println("RedeemEmailToken", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookie(w, *session)
+	respondJSON(w, http.StatusOK, sessionResponse{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Token:     session.Token,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+func (h *handlers) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := core.NormalizeEmail(body.Email); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.emailAuth.RequestEmailChange(r.Context(), currentUserID(r), body.Email)
+	if errors.Is(err, services.ErrEmailTaken) {
+		respondError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		fmt.Println("RequestEmailChange", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handlers) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.emailAuth.ConfirmEmailChange(r.Context(), body.Token)
+	if errors.Is(err, services.ErrInvalidVerificationToken) {
+		respondError(w, http.StatusUnauthorized, err)
+		return
+	} else if errors.Is(err, services.ErrEmailTaken) {
+		respondError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		fmt.Println("ConfirmEmailChange", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newUserResponse(*user))
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/services/oidc"
+)
+
+const (
+	oidcLoginCookieName = "draw_oidc"
+	oidcLoginTTL        = 10 * time.Minute
+)
+
+// OIDCLogin sends the user to the provider to sign in. The state, nonce and PKCE
+// verifier are kept in a short-lived cookie until they come back.
+func (h *handlers) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, login := h.oidcAuth.Begin()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookieName,
+		Value:    strings.Join([]string{login.State, login.Nonce, login.Verifier}, "."),
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes the sign-in with the code and state the provider sent
+// the user back to the frontend with.
+func (h *handlers) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	login, ok := oidcLoginFromCookie(r)
+	// the login can only be completed once
+	http.SetCookie(w, &http.Cookie{Name: oidcLoginCookieName, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true, Secure: true})
+	if !ok || subtle.ConstantTimeCompare([]byte(login.State), []byte(body.State)) != 1 {
+		respondError(w, http.StatusBadRequest, errors.New("invalid or expired sign-in state, please try again"))
+		return
+	}
+
+	// signed-in users link the provider's identity to their account
+	id := identityFromContext(r.Context())
+	signedIn := id.User
+	if id.Session == nil {
+		signedIn = nil
+	}
+
+	user, session, err := h.oidcAuth.Complete(r.Context(), login, body.Code, signedIn)
+	switch {
+	case errors.Is(err, oidc.ErrExchangeFailed), errors.Is(err, oidc.ErrInvalidIDToken):
+		respondError(w, http.StatusUnauthorized, errors.New("the provider could not sign you in"))
+		return
+	case errors.Is(err, services.ErrIdentityLinkedElsewhere):
+		respondError(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, services.ErrIdentityEmailRequired), errors.Is(err, services.ErrSignInDisabled):
+		respondError(w, http.StatusForbidden, err)
+		return
+	case err != nil:
+		fmt.Println("OIDCCallback", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookie(w, *session)
+	respondJSON(w, http.StatusOK, sessionResponse{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Token:     session.Token,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+func oidcLoginFromCookie(r *http.Request) (services.OIDCLogin, bool) {
+	cookie, err := r.Cookie(oidcLoginCookieName)
+	if err != nil {
+		return services.OIDCLogin{}, false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return services.OIDCLogin{}, false
+	}
+	return services.OIDCLogin{State: parts[0], Nonce: parts[1], Verifier: parts[2]}, true
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+func (h *handlers) PasswordSignIn(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, session, err := h.passwordAuth.SignIn(r.Context(), body.Email, body.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		respondError(w, http.StatusUnauthorized, err)
+		return
+	} else if errors.Is(err, services.ErrCredentialsLocked) {
+		respondLocked(w, err)
+		return
+	} else if errors.Is(err, services.ErrSignInDisabled) {
+		respondError(w, http.StatusForbidden, err)
+		return
+	} else if err != nil {
+		fmt.Println("PasswordSignIn", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookie(w, *session)
+	respondJSON(w, http.StatusOK, sessionResponse{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Token:     session.Token,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+func (h *handlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.passwordAuth.ChangePassword(r.Context(), currentUserID(r), body.CurrentPassword, body.NewPassword)
+	if errors.Is(err, core.ErrWeakPassword) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if errors.Is(err, services.ErrInvalidCredentials) {
+		respondError(w, http.StatusForbidden, err)
+		return
+	} else if errors.Is(err, services.ErrCredentialsLocked) {
+		respondLocked(w, err)
+		return
+	} else if err != nil {
+		fmt.Println("ChangePassword", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlers) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := core.NormalizeEmail(body.Email); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.passwordAuth.RequestPasswordReset(r.Context(), body.Email); err != nil {
+		fmt.Println("RequestPasswordReset", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the response never tells whether the address belongs to a user
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := h.passwordAuth.ResetPassword(r.Context(), body.Token, body.NewPassword)
+	if errors.Is(err, core.ErrWeakPassword) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if errors.Is(err, services.ErrInvalidVerificationToken) {
+		respondError(w, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		fmt.Println("ResetPassword", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// every session was revoked, including the one this browser may have had
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondLocked tells the client to wait out the lockout, at most core.PasswordLockoutDuration.
+func respondLocked(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(core.PasswordLockoutDuration.Seconds())))
+	respondError(w, http.StatusTooManyRequests, err)
+}
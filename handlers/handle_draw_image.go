@@ -1,19 +1,22 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"image/png"
 	"net/http"
+
+	"github.com/lazharichir/draw/services"
 )
 
 func (h *handlers) DrawImage(w http.ResponseWriter, r *http.Request) {
-	// get a tile (e.g., http://localhost:1001/image?cid=0&x=-1000&y=-1000&src=https://freshman.tech/images/dp-illustration.png)
+	// get a tile (e.g., http://localhost:1001/admin/image?cid=0&x=-1000&y=-1000&src=https://freshman.tech/images/dp-illustration.png)
 
 	canvasID := chiURLQueryInt64(r, "cid")
 	x := chiURLQueryInt64(r, "x")
 	y := chiURLQueryInt64(r, "y")
 	src := r.URL.Query().Get("src")
-	fmt.Println("GET /image", canvasID, x, y, src)
+	fmt.Println("GET /admin/image", canvasID, x, y, src)
 
 	img, err := loadImageFromURL(src)
 	if err != nil {
@@ -24,13 +27,38 @@ func (h *handlers) DrawImage(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("image size", img.Bounds().Max.X, img.Bounds().Max.Y)
 
+	if !h.checkWrite(w, r, img.Bounds().Dx()*img.Bounds().Dy()) {
+		return
+	}
+
 	// get the pixels from the image
 	tile := buildTileFromImage(int64(x), int64(y), img)
-	if err := h.storage.DrawPixels(canvasID, tile.Pixels); err != nil {
+	var forbidden error
+	err = h.unitOfWork.Do(r.Context(), func(ctx context.Context, work services.Work) error {
+		if ok, err := work.LandRegistry.CanDrawInArea(ctx, canvasID, currentUserID(r), tile.Area); err != nil {
+			return err
+		} else if !ok {
+			forbidden = services.ErrCannotDrawInArea(currentUserID(r), tile.Area.Min, tile.Area.Max.Translate(-1, -1))
+			return nil
+		}
+
+		if err := work.Pixels.DrawPixels(ctx, canvasID, currentUserID(r), tile.Pixels); err != nil {
+			return err
+		}
+		return h.markTilesChanged(ctx, work.Pixels, canvasID, tile.Pixels...)
+	})
+	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if forbidden != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(forbidden.Error()))
+		return
+	}
+
+	h.recordDrawing(r, len(tile.Pixels))
 
 	// write the image to the response
 	w.Header().Set("Content-Type", "image/png")
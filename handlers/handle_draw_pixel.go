@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"image/color"
 	"net/http"
@@ -26,23 +27,39 @@ func (h *handlers) DrawPixel(w http.ResponseWriter, r *http.Request) {
 
 	pixel := core.NewPixel(x, y, color)
 
-	// check if the pixel can be drawn
-	if ok, err := h.landRegistry.CanDrawPixel(r.Context(), 0, 0, pixel); err != nil {
-		fmt.Println(err)
-		w.Write([]byte(err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	} else if !ok {
-		err := services.ErrCannotDrawInArea(0, pixel.Point, pixel.Point)
-		fmt.Println(err)
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte(err.Error()))
+	if !checkScope(w, r, core.CanvasDrawScope(canvasID)) || !h.checkWrite(w, r, 1) {
 		return
 	}
 
-	if err := h.storage.DrawPixels(canvasID, []core.Pixel{pixel}); err != nil {
+	// check the pixel can be drawn, draw it and mark its tiles changed in one go:
+	// the check locks the canvas's leases until the pixel is drawn, so that a lease
+	// granted meanwhile is not drawn over
+	var forbidden error
+	err := h.unitOfWork.Do(r.Context(), func(ctx context.Context, work services.Work) error {
+		if ok, err := work.LandRegistry.CanDrawPixel(ctx, canvasID, currentUserID(r), pixel); err != nil {
+			return err
+		} else if !ok {
+			forbidden = services.ErrCannotDrawInArea(currentUserID(r), pixel.Point, pixel.Point)
+			return nil
+		}
+
+		if err := work.Pixels.DrawPixels(ctx, canvasID, currentUserID(r), []core.Pixel{pixel}); err != nil {
+			return err
+		}
+		return h.markTilesChanged(ctx, work.Pixels, canvasID, pixel)
+	})
+	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if forbidden != nil {
+		fmt.Println(forbidden)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(forbidden.Error()))
 		return
 	}
+
+	h.recordDrawing(r, 1)
 }
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
+	"image/color"
 	"net/http"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
 )
 
 func (h *handlers) ErasePixel(w http.ResponseWriter, r *http.Request) {
@@ -10,9 +15,34 @@ func (h *handlers) ErasePixel(w http.ResponseWriter, r *http.Request) {
 	x := chiURLParamInt64(r, "x")
 	y := chiURLParamInt64(r, "y")
 
-	if err := h.storage.ErasePixel(canvasID, x, y); err != nil {
+	if !checkScope(w, r, core.CanvasDrawScope(canvasID)) || !h.checkWrite(w, r, 1) {
+		return
+	}
+
+	// erasing draws a transparent pixel, the leases apply the same as to DrawPixel
+	pixel := core.NewPixel(x, y, color.RGBA{})
+	var forbidden error
+	err := h.unitOfWork.Do(r.Context(), func(ctx context.Context, work services.Work) error {
+		if ok, err := work.LandRegistry.CanDrawPixel(ctx, canvasID, currentUserID(r), pixel); err != nil {
+			return err
+		} else if !ok {
+			forbidden = services.ErrCannotDrawInArea(currentUserID(r), pixel.Point, pixel.Point)
+			return nil
+		}
+
+		if err := work.Pixels.ErasePixel(ctx, canvasID, currentUserID(r), x, y); err != nil {
+			return err
+		}
+		return h.markTilesChanged(ctx, work.Pixels, canvasID, pixel)
+	})
+	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if forbidden != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(forbidden.Error()))
+		return
+	}
 }
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/utils"
+)
+
+func (h *handlers) ExportArea(w http.ResponseWriter, r *http.Request) {
+	// export an area (e.g., http://localhost:1001/canvas/0/export?area=-100,-100,100,100&scale=4&format=png)
+
+	canvasID := chiURLParamInt64(r, "canvasID")
+	area, err := core.ParseArea(r.URL.Query().Get("area"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	scale := float64(1)
+	if str := r.URL.Query().Get("scale"); str != "" {
+		scale, err = strconv.ParseFloat(str, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid scale"))
+			return
+		}
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "gif" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("unsupported format (only png and gif are supported)"))
+		return
+	}
+
+	fmt.Println("GET /canvas/export", canvasID, area, scale, format)
+
+	img, err := h.exporter.Export(r.Context(), canvasID, area, scale)
+	if errors.Is(err, services.ErrExportTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(err.Error()))
+		return
+	} else if errors.Is(err, services.ErrInvalidExport) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		fmt.Println("ExportArea", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var data []byte
+	switch format {
+	case "gif":
+		data, err = utils.EncodeGIF(img)
+	default:
+		data, err = utils.EncodePNG(img, png.BestCompression)
+	}
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("canvas-%d_%s.%s", canvasID, area.ObjectName(), format)
+	w.Header().Set("Content-Type", "image/"+format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Write(data)
+}
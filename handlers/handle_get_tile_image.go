@@ -2,67 +2,79 @@ package handlers
 
 import (
 	"fmt"
-	"image"
-	"image/png"
 	"net/http"
 
 	"github.com/lazharichir/draw/core"
 )
 
-func (h *handlers) respondWithImage(w http.ResponseWriter, r *http.Request, img image.Image) {
-	// render the tile as a png image
-	w.Header().Set("Content-Type", "image/png")
-	encoder := png.Encoder{}
-	encoder.CompressionLevel = png.NoCompression
-	if err := encoder.Encode(w, img); err != nil {
-		fmt.Println("respondWithImage", err)
+func (h *handlers) respondWithTile(w http.ResponseWriter, format core.TileFormat, data []byte) {
+	w.Header().Set("Content-Type", format.ContentType())
+	if _, err := w.Write(data); err != nil {
+		fmt.Println("respondWithTile", err)
 	}
 }
 
+// tileImagePath returns the URL path of a tile image served by GetTileImage.
+func tileImagePath(area core.Area) string {
+	return fmt.Sprintf("/tile/%dx%d_%d.png", area.Min.X, area.Min.Y, area.Width())
+}
+
 func (h *handlers) GetTileImage(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	canvasID := int64(0)
 	x := chiURLParamInt64(r, "x")
 	y := chiURLParamInt64(r, "y")
 	d := chiURLParamInt64(r, "d")
-	area := core.NewAreaSquare(core.Pt(x, y), d)
 
-	// check if the tile is in the cache
-	cached, err := h.tileCache.GetTile(ctx, canvasID, area)
+	// only serve tiles of the canvas's canonical grid
+	canvas := h.canvases.Get(canvasID)
+	area, err := canvas.CanonicalTile(core.Pt(x, y), d)
 	if err != nil {
-		fmt.Println("GetTileImage.tileCache.GetTile", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	// if a tile was cached, return it
-	if cached != nil {
-		h.respondWithImage(w, r, cached)
+	// redirect unaligned origins to the canonical tile that contains them
+	if !area.Min.Equals(core.Pt(x, y)) {
+		redirectURL := tileImagePath(area)
+		if r.URL.RawQuery != "" {
+			redirectURL += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
 		return
 	}
 
-	// if not, get the pixels from the storage
-	pixels, err := h.storage.GetPixelsFromTopLeft(canvasID, x, y, d)
+	// pick the format from the ?format= query or the Accept header
+	w.Header().Add("Vary", "Accept")
+	format, err := canvas.NegotiateTileFormat(r.URL.Query().Get("format"), r.Header.Get("Accept"))
 	if err != nil {
-		fmt.Println("GetTileImage.storage.GetPixelsFromTopLeft", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	// create a new tile and add the pixels to it
-	newTile := core.NewTilePWH(core.Pt(x, y), d, d)
-	newTile.AddPixels(pixels...)
+	// check if the tile is in the cache
+	cached, _, err := h.tileCache.GetTileEncoded(ctx, canvasID, area, format)
+	if err != nil {
+		fmt.Println("GetTileImage.tileCache.GetTileEncoded", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	// render the tile as a png image
-	cached = newTile.AsImage()
+	// if a tile was cached, return it
+	if cached != nil {
+		h.respondWithTile(w, format, cached)
+		return
+	}
 
-	// store the tile in the cache
-	if err := h.tileCache.PutTile(ctx, canvasID, newTile, cached); err != nil {
-		fmt.Println("GetTileImage.tileCache.PutTile", err)
+	// if not, render it from the storage and cache it
+	rendered, err := h.tileRenderer.RenderTileAs(ctx, canvasID, area, format)
+	if err != nil {
+		fmt.Println("GetTileImage.tileRenderer.RenderTileAs", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// render the tile as a png image
-	h.respondWithImage(w, r, cached)
+	h.respondWithTile(w, format, rendered)
 }
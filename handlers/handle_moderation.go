@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+// checkWrite responds with an error and returns false if the requester may not
+// write that many pixels, because they are suspended, banned or on probation.
+func (h *handlers) checkWrite(w http.ResponseWriter, r *http.Request, pixels int) bool {
+	err := h.moderation.CheckWrite(currentUser(r), clientIP(r), pixels)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, core.ErrUserRestricted), errors.Is(err, services.ErrExceedsProbationLimit):
+		respondError(w, http.StatusForbidden, err)
+	case errors.Is(err, services.ErrProbationRateLimited):
+		w.Header().Set("Retry-After", "60")
+		respondError(w, http.StatusTooManyRequests, err)
+	default:
+		fmt.Println("checkWrite", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	return false
+}
+
+// recordDrawing counts pixels towards the drawer's promotion out of probation.
+// The pixels are already drawn, so a failure is only logged.
+func (h *handlers) recordDrawing(r *http.Request, pixels int) {
+	if err := h.moderation.RecordDrawing(r.Context(), currentUser(r), pixels); err != nil {
+		fmt.Println("recordDrawing", err)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type statusChangeBody struct {
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until"`
+}
+
+func (h *handlers) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	var body statusChangeBody
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.moderation.Suspend(r.Context(), core.UserID(chi.URLParam(r, "userID")), body.Reason, body.Until)
+	h.respondStatusChange(w, user, err)
+}
+
+func (h *handlers) BanUser(w http.ResponseWriter, r *http.Request) {
+	var body statusChangeBody
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.moderation.Ban(r.Context(), core.UserID(chi.URLParam(r, "userID")), body.Reason, body.Until)
+	h.respondStatusChange(w, user, err)
+}
+
+func (h *handlers) ReinstateUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.moderation.Reinstate(r.Context(), core.UserID(chi.URLParam(r, "userID")))
+	h.respondStatusChange(w, user, err)
+}
+
+func (h *handlers) respondStatusChange(w http.ResponseWriter, user *core.User, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidStatusChange):
+		respondError(w, http.StatusBadRequest, err)
+	case errors.Is(err, services.ErrUserNotFound):
+		respondError(w, http.StatusNotFound, err)
+	case err != nil:
+		fmt.Println("respondStatusChange", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		respondJSON(w, http.StatusOK, newUserResponse(*user))
+	}
+}
@@ -33,7 +33,7 @@ func (h *handlers) PollAreaPixels(w http.ResponseWriter, r *http.Request) {
 	topLeft := core.Point{X: tlX, Y: tlY}
 	bottomRight := core.Point{X: brX, Y: brY}
 
-	pixels, err := h.storage.GetLatestPixelsForArea(canvasID, topLeft, bottomRight, from)
+	pixels, err := h.storage.GetLatestPixelsForArea(r.Context(), canvasID, topLeft, bottomRight, from)
 	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+const dateOfBirthLayout = "2006-01-02"
+
+// profileBody is the profile as its owner reads and writes it.
+type profileBody struct {
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Gender       string `json:"gender"`
+	DateOfBirth  string `json:"date_of_birth"`
+	Bio          string `json:"bio"`
+	WebsiteURL   string `json:"website_url"`
+	FacebookURL  string `json:"facebook_url"`
+	TwitterURL   string `json:"twitter_url"`
+	InstagramURL string `json:"instagram_url"`
+	LinkedInURL  string `json:"linkedin_url"`
+	TikTokURL    string `json:"tiktok_url"`
+	YouTubeURL   string `json:"youtube_url"`
+}
+
+func newProfileBody(profile core.UserProfile) profileBody {
+	body := profileBody{
+		FirstName:    profile.FirstName,
+		LastName:     profile.LastName,
+		Gender:       profile.Gender,
+		Bio:          profile.Bio,
+		WebsiteURL:   profile.WebsiteURL,
+		FacebookURL:  profile.FacebookURL,
+		TwitterURL:   profile.TwitterURL,
+		InstagramURL: profile.InstagramURL,
+		LinkedInURL:  profile.LinkedInURL,
+		TikTokURL:    profile.TikTokURL,
+		YouTubeURL:   profile.YouTubeURL,
+	}
+	if !profile.DateOfBirth.IsZero() {
+		body.DateOfBirth = profile.DateOfBirth.Format(dateOfBirthLayout)
+	}
+	return body
+}
+
+func (body profileBody) profile() (core.UserProfile, error) {
+	profile := core.UserProfile{
+		FirstName:    body.FirstName,
+		LastName:     body.LastName,
+		Gender:       body.Gender,
+		Bio:          body.Bio,
+		WebsiteURL:   body.WebsiteURL,
+		FacebookURL:  body.FacebookURL,
+		TwitterURL:   body.TwitterURL,
+		InstagramURL: body.InstagramURL,
+		LinkedInURL:  body.LinkedInURL,
+		TikTokURL:    body.TikTokURL,
+		YouTubeURL:   body.YouTubeURL,
+	}
+	if body.DateOfBirth != "" {
+		dob, err := time.Parse(dateOfBirthLayout, body.DateOfBirth)
+		if err != nil {
+			return profile, fmt.Errorf("%w: date_of_birth must be formatted as YYYY-MM-DD", core.ErrInvalidProfile)
+		}
+		profile.DateOfBirth = dob
+	}
+	return profile, nil
+}
+
+// publicProfileResponse is what anyone can see of a user, without gender or date of birth.
+type publicProfileResponse struct {
+	UserID       core.UserID `json:"user_id"`
+	Username     string      `json:"username"`
+	DisplayName  string      `json:"display_name"`
+	Bio          string      `json:"bio,omitempty"`
+	WebsiteURL   string      `json:"website_url,omitempty"`
+	FacebookURL  string      `json:"facebook_url,omitempty"`
+	TwitterURL   string      `json:"twitter_url,omitempty"`
+	InstagramURL string      `json:"instagram_url,omitempty"`
+	LinkedInURL  string      `json:"linkedin_url,omitempty"`
+	TikTokURL    string      `json:"tiktok_url,omitempty"`
+	YouTubeURL   string      `json:"youtube_url,omitempty"`
+}
+
+func newPublicProfileResponse(user core.User, profile core.UserProfile) publicProfileResponse {
+	return publicProfileResponse{
+		UserID:       user.ID,
+		Username:     user.Username,
+		DisplayName:  profile.DisplayName(user.Username),
+		Bio:          profile.Bio,
+		WebsiteURL:   profile.WebsiteURL,
+		FacebookURL:  profile.FacebookURL,
+		TwitterURL:   profile.TwitterURL,
+		InstagramURL: profile.InstagramURL,
+		LinkedInURL:  profile.LinkedInURL,
+		TikTokURL:    profile.TikTokURL,
+		YouTubeURL:   profile.YouTubeURL,
+	}
+}
+
+func (h *handlers) GetMyProfile(w http.ResponseWriter, r *http.Request) {
+	profile, err := h.profiles.Get(r.Context(), currentUserID(r))
+	if err != nil {
+		fmt.Println("GetMyProfile", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newProfileBody(profile))
+}
+
+func (h *handlers) UpdateMyProfile(w http.ResponseWriter, r *http.Request) {
+	var body profileBody
+	if err := decodeJSON(r, &body); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	profile, err := body.profile()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	profile, err = h.profiles.Update(r.Context(), currentUserID(r), profile)
+	if errors.Is(err, core.ErrInvalidProfile) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		fmt.Println("UpdateMyProfile", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newProfileBody(profile))
+}
+
+func (h *handlers) GetUserProfile(w http.ResponseWriter, r *http.Request) {
+	user, profile, err := h.profiles.GetByUsername(r.Context(), chi.URLParam(r, "username"))
+	if errors.Is(err, services.ErrUserNotFound) {
+		respondError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		fmt.Println("GetUserProfile", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, newPublicProfileResponse(*user, profile))
+}
+
+// LookupProfiles resolves ?ids=usr_a,usr_b into public profiles keyed by user ID.
+func (h *handlers) LookupProfiles(w http.ResponseWriter, r *http.Request) {
+	var ids []core.UserID
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, core.UserID(id))
+		}
+	}
+
+	users, profiles, err := h.profiles.Lookup(r.Context(), ids...)
+	if errors.Is(err, services.ErrTooManyUsersLookup) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		fmt.Println("LookupProfiles", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := make(map[core.UserID]publicProfileResponse, len(users))
+	for id, user := range users {
+		profile := core.UserProfile{}
+		if profiles[id] != nil {
+			profile = *profiles[id]
+		}
+		response[id] = newPublicProfileResponse(*user, profile)
+	}
+
+	// profiles change rarely and hovering the canvas asks for the same users over and over
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	respondJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+)
+
+type userResponse struct {
+	ID             core.UserID `json:"id"`
+	Status         string      `json:"status"`
+	StatusReason   string      `json:"status_reason,omitempty"`
+	StatusUntil    *time.Time  `json:"status_until,omitempty"`
+	Username       string      `json:"username"`
+	Email          string      `json:"email"`
+	CreatedAt      time.Time   `json:"created_at"`
+	LastSignedInAt time.Time   `json:"last_signed_in_at"`
+	PixelsDrawn    int64       `json:"pixels_drawn"`
+}
+
+func newUserResponse(user core.User) userResponse {
+	return userResponse{
+		ID:             user.ID,
+		Status:         user.Status,
+		StatusReason:   user.StatusReason,
+		StatusUntil:    user.StatusUntil,
+		Username:       user.Username,
+		Email:          user.Email,
+		CreatedAt:      user.CreatedAt,
+		LastSignedInAt: user.LastSignedInAt,
+		PixelsDrawn:    user.PixelsDrawn,
+	}
+}
+
+func (h *handlers) GetMe(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, newUserResponse(*currentUser(r)))
+}
+
+func (h *handlers) SignOut(w http.ResponseWriter, r *http.Request) {
+	id := identityFromContext(r.Context())
+	if err := h.sessions.SignOut(r.Context(), *id.Session); err != nil {
+		fmt.Println("SignOut", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlers) SignOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	if err := h.sessions.SignOutEverywhere(r.Context(), currentUserID(r)); err != nil {
+		fmt.Println("SignOutEverywhere", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/lazharichir/draw/services"
+)
+
+// maxSnapshotSize caps the size of the snapshots uploaded to be restored.
+const maxSnapshotSize = 1 << 30
+
+// CreateCanvasSnapshot streams a snapshot of the canvas (e.g., GET /admin/canvas/0/snapshot).
+func (h *handlers) CreateCanvasSnapshot(w http.ResponseWriter, r *http.Request) {
+	canvasID := canvasFromRequest(r)
+	if canvasID == nil {
+		respondError(w, http.StatusBadRequest, errors.New("invalid canvas id"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="canvas-%d.zip"`, *canvasID))
+
+	// the archive is streamed, an error past this point can only cut it short
+	if _, err := h.snapshots.Create(r.Context(), *canvasID, w); err != nil {
+		fmt.Println("CreateCanvasSnapshot", err)
+	}
+}
+
+// RestoreCanvasSnapshot restores the snapshot in the request body into the canvas
+// (e.g., POST /admin/canvas/0/restore?replace=true), which must be empty unless
+// replace is set.
+func (h *handlers) RestoreCanvasSnapshot(w http.ResponseWriter, r *http.Request) {
+	canvasID := canvasFromRequest(r)
+	if canvasID == nil {
+		respondError(w, http.StatusBadRequest, errors.New("invalid canvas id"))
+		return
+	}
+	replace := r.URL.Query().Get("replace") == "true"
+
+	// a zip is read from its end, so the body is spooled to a file first
+	f, err := os.CreateTemp("", "snapshot-*.zip")
+	if err != nil {
+		fmt.Println("RestoreCanvasSnapshot", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	} else if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	manifest, err := h.snapshots.Restore(r.Context(), *canvasID, f, size, replace)
+	if errors.Is(err, services.ErrInvalidSnapshot) {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	} else if errors.Is(err, services.ErrCanvasNotEmpty) {
+		respondError(w, http.StatusConflict, err)
+		return
+	} else if err != nil && manifest == nil {
+		fmt.Println("RestoreCanvasSnapshot", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if err != nil {
+		// restored, but some cached tiles could not be evicted
+		fmt.Println("RestoreCanvasSnapshot", err)
+	}
+
+	respondJSON(w, http.StatusOK, manifest)
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
@@ -16,20 +19,67 @@ import (
 
 func New(
 	storage storage.PixelStore,
-	landRegistry *services.LandRegistry,
+	landRegistry services.LandRegistry,
 	tileCache *services.TileCache,
+	tileRenderer *services.TileRenderer,
+	canvases *services.CanvasRegistry,
+	precacheWorker *services.PrecacheWorker,
+	exporter *services.Exporter,
+	emailAuth *services.EmailAuth,
+	sessions *services.Sessions,
+	passwordAuth *services.PasswordAuth,
+	profiles *services.Profiles,
+	moderation *services.Moderation,
+	authorizer *services.Authorizer,
+	apiKeys *services.APIKeys,
+	oidcAuth *services.OIDCAuth,
+	accounts *services.Accounts,
+	unitOfWork *services.UnitOfWork,
+	snapshots *services.Snapshots,
 ) *handlers {
 	return &handlers{
 		storage:      storage,
 		landRegistry: landRegistry,
 		tileCache:    tileCache,
+		tileRenderer: tileRenderer,
+		canvases:     canvases,
+
+		precacheWorker: precacheWorker,
+		exporter:       exporter,
+		emailAuth:      emailAuth,
+		sessions:       sessions,
+		passwordAuth:   passwordAuth,
+		profiles:       profiles,
+		moderation:     moderation,
+		authorizer:     authorizer,
+		apiKeys:        apiKeys,
+		oidcAuth:       oidcAuth,
+		accounts:       accounts,
+		unitOfWork:     unitOfWork,
+		snapshots:      snapshots,
 	}
 }
 
 type handlers struct {
 	storage      storage.PixelStore
-	landRegistry *services.LandRegistry
+	landRegistry services.LandRegistry
 	tileCache    *services.TileCache
+	tileRenderer *services.TileRenderer
+	canvases     *services.CanvasRegistry
+
+	precacheWorker *services.PrecacheWorker
+	exporter       *services.Exporter
+	emailAuth      *services.EmailAuth
+	sessions       *services.Sessions
+	passwordAuth   *services.PasswordAuth
+	profiles       *services.Profiles
+	moderation     *services.Moderation
+	authorizer     *services.Authorizer
+	apiKeys        *services.APIKeys
+	oidcAuth       *services.OIDCAuth
+	accounts       *services.Accounts
+	unitOfWork     *services.UnitOfWork
+	snapshots      *services.Snapshots
 }
 
 func strToInt64(str string) int64 {
@@ -40,6 +90,34 @@ func strToInt64(str string) int64 {
 	return val
 }
 
+// maxJSONBodySize caps the size of JSON request bodies.
+const maxJSONBodySize = 1 << 20
+
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxJSONBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func respondJSON(w http.ResponseWriter, status int, v any) {
+	by, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println("respondJSON", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(by)
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	respondJSON(w, status, map[string]string{"error": err.Error()})
+}
+
 func chiURLQueryInt64(r *http.Request, key string) int64 {
 	str := r.URL.Query().Get(key)
 	if len(str) == 0 {
@@ -56,6 +134,27 @@ func chiURLParamInt64(r *http.Request, key string) int64 {
 	return strToInt64(str)
 }
 
+// markTilesChanged flags every canonical tile containing one of the pixels as changed,
+// so that the precache worker re-renders it
+func (h *handlers) markTilesChanged(ctx context.Context, store storage.PixelStore, canvasID int64, pixels ...core.Pixel) error {
+	points := make([]core.Point, len(pixels))
+	for i, pixel := range pixels {
+		points[i] = pixel.Point
+	}
+
+	canvas := h.canvases.Get(canvasID)
+	for _, side := range canvas.TileSides {
+		if !canvas.IsTileSide(side) {
+			continue
+		}
+		if err := store.SetLastChangedForPoints(ctx, canvasID, side, points...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func buildTileFromImage(x, y int64, img image.Image) core.Tile {
 	width := img.Bounds().Max.X
 	height := img.Bounds().Max.Y
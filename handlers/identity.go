@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+)
+
+type identityContextKey struct{}
+
+// identity is who a request is made by, resolved by the Identify middleware.
+// Users act either through a session or through one of their API keys.
+type identity struct {
+	User    *core.User
+	Session *core.Session
+	APIKey  *core.APIKey
+}
+
+func withIdentity(ctx context.Context, id identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+func identityFromContext(ctx context.Context) identity {
+	id, _ := ctx.Value(identityContextKey{}).(identity)
+	return id
+}
+
+// currentUser returns the signed-in user of the request, or nil if it is anonymous.
+func currentUser(r *http.Request) *core.User {
+	return identityFromContext(r.Context()).User
+}
+
+// currentUserID returns the ID of the signed-in user of the request, or the anonymous ID.
+func currentUserID(r *http.Request) core.UserID {
+	if user := currentUser(r); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// sessionToken returns the session token from the Authorization header or the session cookie.
+func sessionToken(r *http.Request) (token string, fromHeader bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token), true
+		}
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		return cookie.Value, false
+	}
+	return "", false
+}
+
+// Identify resolves the user behind the request's session or API key, if any, into
+// its context. Requests without credentials go through anonymously; an invalid
+// bearer token is rejected while an invalid session cookie is cleared.
+func (h *handlers) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, fromHeader := sessionToken(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if fromHeader && core.IsAPIKeyToken(token) {
+			h.identifyAPIKey(w, r, next, token)
+			return
+		}
+
+		user, session, err := h.sessions.Authenticate(r.Context(), token)
+		if errors.Is(err, services.ErrInvalidSession) {
+			if fromHeader {
+				respondError(w, http.StatusUnauthorized, err)
+				return
+			}
+			clearSessionCookie(w)
+			next.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			fmt.Println("Identify", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		ctx := withIdentity(r.Context(), identity{User: user, Session: session})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *handlers) identifyAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	user, key, err := h.apiKeys.Authenticate(r.Context(), token)
+	switch {
+	case errors.Is(err, core.ErrInvalidAPIKey):
+		respondError(w, http.StatusUnauthorized, err)
+	case errors.Is(err, services.ErrAPIKeyUserDisabled):
+		respondError(w, http.StatusForbidden, err)
+	case errors.Is(err, services.ErrAPIKeyRateLimited):
+		w.Header().Set("Retry-After", "1")
+		respondError(w, http.StatusTooManyRequests, err)
+	case err != nil:
+		fmt.Println("Identify", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		ctx := withIdentity(r.Context(), identity{User: user, APIKey: key})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// RequireUser rejects anonymous requests, and those made with an API key since
+// account routes are for the user themselves.
+func (h *handlers) RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := identityFromContext(r.Context())
+		if id.User == nil {
+			respondError(w, http.StatusUnauthorized, errors.New("sign in required"))
+			return
+		}
+		if id.APIKey != nil {
+			respondError(w, http.StatusForbidden, errors.New("not available to api keys"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkScope responds with an error and returns false if the request is made
+// with an API key that lacks the scope. Other requests are not limited by scopes.
+func checkScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	key := identityFromContext(r.Context()).APIKey
+	if key == nil || key.HasScope(scope) {
+		return true
+	}
+	respondError(w, http.StatusForbidden, fmt.Errorf("the api key lacks the %s scope", scope))
+	return false
+}
+
+// RequireScope rejects requests made with an API key that lacks the scope.
+func (h *handlers) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if checkScope(w, r, scope) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
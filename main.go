@@ -2,20 +2,24 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
+	"database/sql"
+	"expvar"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
-
-	// godotenv
-	_ "github.com/joho/godotenv/autoload"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/lazharichir/draw/config"
+	"github.com/lazharichir/draw/core"
 	"github.com/lazharichir/draw/handlers"
 	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/services/oidc"
 	"github.com/lazharichir/draw/storage"
 	"github.com/lazharichir/draw/utils"
 )
@@ -45,16 +49,122 @@ func Gzip(handler http.HandlerFunc) http.HandlerFunc {
 }
 
 func main() {
+	cfg, args, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		config.Usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(cfg, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "convert-pixels" {
+		os.Exit(runConvertPixels(cfg, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "snapshot" {
+		os.Exit(runSnapshot(cfg, args[1:]))
+	}
+
+	fmt.Println("Server started:", fmt.Sprintf("http://localhost:%d", cfg.Port))
+
+	db, err := storage.OpenPG(context.Background(), cfg.Database)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	storage.PublishPoolStats("db", db)
+	iam := storage.NewIAMStorePG()
+	canvasStore := storage.NewPGCanvasStore(db)
+	storage := newPixelStore(db, cfg)
+	landRegistry := services.NewPGLandRegistry(db)
+	unitOfWork := services.NewUnitOfWork(db, storage, landRegistry, canvasStore, services.NewPGUsers(db, iam))
+
+	tileCacheBackend, err := newTileCacheBackend(cfg.TileCache)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tileCache := services.NewTileCache(tileCacheBackend)
+
+	// load the canvases configured away from the defaults, e.g. restored from a snapshot
+	canvases := services.NewCanvasRegistry()
+	canvases.SetDefaultTileSides(cfg.TileSides, cfg.MaxTileSide)
+	if err := canvases.Load(context.Background(), canvasStore); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := canvases.Load(context.Background(), canvasStore); err != nil {
+				fmt.Println("CanvasRegistry.Load", err)
+			}
+		}
+	}()
+	tileRenderer := services.NewTileRenderer(storage, tileCache, canvases)
+	precacheWorker := services.NewPrecacheWorker(db, storage, tileRenderer, canvases, cfg.PrecacheInterval, cfg.PrecacheConcurrency)
 
-	fmt.Println("Server started:", "http://localhost:1001")
+	// precache changed tiles in the background
+	go precacheWorker.Run(context.Background())
 
-	db := storage.NewPG()
-	storage := storage.NewPGPixelStore(db, nil)
-	landRegistry := services.NewLandRegistry(db)
-	s3 := utils.MustNewS3Client(os.Getenv("R2_AWS_ACCOUNT_ID"), os.Getenv("R2_AWS_ACCESS_KEY_ID"), os.Getenv("R2_AWS_ACCESS_KEY_SECRET"))
-	tileCache := services.NewTileCache(s3, os.Getenv("R2_TILECACHE_BUCKET_NAME"))
+	exporter := services.NewExporter(storage, tileCache, canvases)
 
-	handlers := handlers.New(storage, landRegistry, tileCache)
+	// send emails through SMTP if configured, or write them to a local outbox
+	var mailer services.Mailer = services.NewOutboxMailer("outbox")
+	if cfg.SMTP.Host != "" {
+		mailer = services.NewSMTPMailer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From)
+	}
+	emailAuth := services.NewEmailAuth(db, iam, mailer, cfg.AppURL)
+	sessions := services.NewSessions(db, iam)
+	passwordAuth := services.NewPasswordAuth(db, iam, mailer, cfg.AppURL)
+	profiles := services.NewProfiles(db, iam)
+
+	probation := services.DefaultProbationPolicy()
+	probation.MaxPixelsPerMinute = cfg.Limits.ProbationMaxPixelsPerMinute
+	probation.PromoteAfterPixels = cfg.Limits.ProbationPromoteAfterPixels
+	probation.PromoteAfterAge = cfg.Limits.ProbationPromoteAfter
+	moderation := services.NewModeration(db, iam, probation)
+
+	// users listed in ADMIN_USER_IDS are admins without a stored role, to grant the first ones
+	var admins []core.UserID
+	for _, id := range cfg.AdminUserIDs {
+		admins = append(admins, core.UserID(id))
+	}
+	authorizer := services.NewAuthorizer(db, iam, admins...)
+	apiKeys := services.NewAPIKeys(db, iam)
+
+	// sign in with an OpenID Connect provider if configured
+	var oidcAuth *services.OIDCAuth
+	if issuer := cfg.OIDC.Issuer; issuer != "" {
+		client, err := oidc.NewClient(context.Background(), oidc.Config{
+			Issuer:       issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       []string{"email", "profile"},
+		}, nil)
+		if err != nil {
+			fmt.Println("OIDC sign-in disabled:", err)
+		} else {
+			oidcAuth = services.NewOIDCAuth(db, iam, client, issuer)
+		}
+	}
+
+	// delete the accounts whose cooling-off period is over
+	accounts := services.NewAccounts(db, iam, storage, landRegistry, unitOfWork)
+	go accounts.Run(context.Background(), time.Hour)
+
+	// forget idle rate limits
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			moderation.PruneLimits()
+			apiKeys.PruneLimits()
+		}
+	}()
+
+	snapshots := services.NewSnapshots(unitOfWork, tileCache, canvases)
+
+	handlers := handlers.New(storage, landRegistry, tileCache, tileRenderer, canvases, precacheWorker, exporter, emailAuth, sessions, passwordAuth, profiles, moderation, authorizer, apiKeys, oidcAuth, accounts, unitOfWork, snapshots)
 
 	r := chi.NewRouter()
 
@@ -65,18 +175,98 @@ func main() {
 	)
 	r.Use(cors.Handler(
 		cors.Options{
-			AllowedOrigins: []string{"*"},
+			AllowedOrigins: cfg.CORSOrigins,
 			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Authorization", "Content-Type"},
 		},
 	))
+	r.Use(handlers.Identify)
 
-	r.Get("/tile/{x}x{y}_{d}.png", Gzip(handlers.GetTileImage))
+	r.With(handlers.RequireScope(core.ScopeTilesRead)).Get("/tile/{x}x{y}_{d}.png", Gzip(handlers.GetTileImage))
 	r.Put("/pixel/{canvasID}/{x}/{y}/{r}/{g}/{b}/{a}", handlers.DrawPixel)
 	r.Delete("/pixel/{canvasID}/{x}/{y}", handlers.ErasePixel)
-	r.Get("/image", handlers.DrawImage)
-	r.Get("/poll", handlers.PollAreaPixels)
-	r.Get("/precache", handlers.PrecacheChangedTiles)
+	r.With(handlers.RequireScope(core.ScopeTilesRead)).Get("/poll", handlers.PollAreaPixels)
+	r.With(handlers.RequireScope(core.ScopeTilesRead)).Get("/canvas/{canvasID}/export", handlers.ExportArea)
+	r.Post("/auth/email/request", handlers.RequestEmailSignIn)
+	r.Post("/auth/email/redeem", handlers.RedeemEmailToken)
+	r.Post("/auth/email/change/confirm", handlers.ConfirmEmailChange)
+	r.Post("/auth/password/signin", handlers.PasswordSignIn)
+	r.Post("/auth/password/reset/request", handlers.RequestPasswordReset)
+	r.Post("/auth/password/reset", handlers.ResetPassword)
+	if oidcAuth != nil {
+		r.Get("/auth/oidc/login", handlers.OIDCLogin)
+		r.Post("/auth/oidc/callback", handlers.OIDCCallback)
+	}
+	r.Get("/users/profiles", handlers.LookupProfiles)
+	r.Get("/users/{username}/profile", handlers.GetUserProfile)
+
+	r.Group(func(r chi.Router) {
+		r.Use(handlers.RequireUser)
+		r.Get("/auth/me", handlers.GetMe)
+		r.Post("/auth/signout", handlers.SignOut)
+		r.Post("/auth/signout/all", handlers.SignOutEverywhere)
+		r.Put("/auth/password", handlers.ChangePassword)
+		r.Post("/auth/email/change", handlers.RequestEmailChange)
+		r.Get("/me/profile", handlers.GetMyProfile)
+		r.Put("/me/profile", handlers.UpdateMyProfile)
+		r.Get("/me/api-keys", handlers.ListAPIKeys)
+		r.Post("/me/api-keys", handlers.CreateAPIKey)
+		r.Delete("/me/api-keys/{keyID}", handlers.RevokeAPIKey)
+		r.Get("/me/export", handlers.ExportMyData)
+		r.Get("/me/deletion", handlers.GetMyDeletion)
+		r.Post("/me/deletion", handlers.RequestMyDeletion)
+		r.Delete("/me/deletion", handlers.CancelMyDeletion)
+	})
+
+	r.Route("/admin", func(r chi.Router) {
+		r.With(handlers.Require(core.PermissionPrecache)).Get("/precache", handlers.PrecacheChangedTiles)
+		r.With(handlers.RequireOnCanvas(core.PermissionImportImage)).Get("/image", handlers.DrawImage)
+		r.With(handlers.Require(core.PermissionMonitor)).Handle("/debug/vars", expvar.Handler())
+
+		r.Group(func(r chi.Router) {
+			r.Use(handlers.Require(core.PermissionModerateUsers))
+			r.Post("/users/{userID}/suspend", handlers.SuspendUser)
+			r.Post("/users/{userID}/ban", handlers.BanUser)
+			r.Post("/users/{userID}/reinstate", handlers.ReinstateUser)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(handlers.RequireOnCanvas(core.PermissionBackupCanvas))
+			r.Get("/canvas/{canvasID}/snapshot", handlers.CreateCanvasSnapshot)
+			r.Post("/canvas/{canvasID}/restore", handlers.RestoreCanvasSnapshot)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(handlers.Require(core.PermissionManageRoles))
+			r.Get("/users/{userID}/roles", handlers.GetUserRoles)
+			r.Post("/users/{userID}/roles", handlers.GrantUserRole)
+			r.Delete("/users/{userID}/roles/{role}", handlers.RevokeUserRole)
+		})
+	})
 
 	// start the server
-	http.ListenAndServe(":1001", r)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), r); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// newPixelStore returns the pixel store the configuration picks.
+func newPixelStore(db *sql.DB, cfg *config.Config) storage.PixelStore {
+	if cfg.PixelStorage == config.PixelStorageChunks {
+		return storage.NewPGChunkPixelStore(db, nil, cfg.Database.QueryTimeout)
+	}
+	return storage.NewPGPixelStore(db, nil, cfg.Database.QueryTimeout)
+}
+
+// newTileCacheBackend returns the tile cache backend the configuration picks.
+func newTileCacheBackend(cfg config.TileCacheConfig) (services.TileCacheBackend, error) {
+	if cfg.Backend == config.TileCacheMemory {
+		return services.NewMemoryTileCacheBackend(), nil
+	}
+	s3, err := utils.NewS3Client(cfg.R2AccountID, cfg.R2AccessKeyID, cfg.R2AccessKeySecret)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewS3TileCacheBackend(s3, cfg.R2Bucket), nil
 }
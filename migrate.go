@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/lazharichir/draw/config"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/migrations"
+)
+
+const migrateUsage = `usage: main [flags] migrate [up | down [steps] | status]
+
+  up       apply every pending migration (default)
+  down     revert the last applied migration, or the last steps ones
+  status   list migrations and whether they are applied`
+
+// runMigrate runs the migrate subcommand and returns the process exit code.
+func runMigrate(cfg *config.Config, args []string) int {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	ctx := context.Background()
+	// migrations may rewrite large tables, they are not bound by the server's statement timeout
+	database := cfg.Database
+	database.StatementTimeout = 0
+	db, err := storage.OpenPG(ctx, database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("already up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
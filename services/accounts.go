@@ -0,0 +1,384 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+)
+
+const (
+	// accountDeletionLockKey identifies the Postgres advisory lock held while deleting accounts.
+	accountDeletionLockKey int64 = 0x64656c65 // "dele"
+	// accountDeletionBatch caps how many accounts a single run deletes.
+	accountDeletionBatch = 50
+)
+
+var (
+	ErrNoPendingDeletion      = errors.New("no account deletion is pending")
+	ErrInvalidLeaseTransfer   = errors.New("leases can only be transferred to another active user")
+	ErrAccountDeletionsLocked = errors.New("accounts are being deleted by another instance")
+)
+
+// exportReadme explains the archive to whoever opens it.
+const exportReadme = `This archive holds the data we keep about your account.
+
+user.json                 your account
+profile.json              your public profile
+identities.json           accounts at other providers you sign in with
+roles.json                roles you were granted
+api_keys.json             your API keys, without their secrets
+sessions.json             your sign-ins, without their tokens
+verification_tokens.json  emails we sent you links in, without the links
+leases.json               the leases you hold or held
+pixels.csv                the pixels on the canvases that you drew last
+
+There is no ledger of payments: leases are the only record of what you paid.
+`
+
+// Accounts exports users' data and deletes their accounts once they have had
+// AccountDeletionCoolingOff to change their minds.
+type Accounts struct {
+	db           *sql.DB
+	iam          *storage.IAMStore
+	pixels       storage.PixelStore
+	landRegistry LandRegistry
+	unitOfWork   *UnitOfWork
+}
+
+func NewAccounts(db *sql.DB, iam *storage.IAMStore, pixels storage.PixelStore, landRegistry LandRegistry, unitOfWork *UnitOfWork) *Accounts {
+	return &Accounts{db: db, iam: iam, pixels: pixels, landRegistry: landRegistry, unitOfWork: unitOfWork}
+}
+
+// Export writes a zip archive of everything stored about the user to w.
+func (a *Accounts) Export(ctx context.Context, userID core.UserID, w io.Writer) error {
+	user, err := a.iam.GetUserBy(ctx, a.db, "id", userID.String())
+	if errors.Is(err, storage.ErrNoRows) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+
+	profiles, err := a.iam.LoadUserProfiles(ctx, a.db, userID)
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	identities, err := a.iam.ListUserIdentities(ctx, a.db, userID)
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	grants, err := a.iam.GetRoleGrants(ctx, a.db, userID)
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	keys, err := a.iam.ListAPIKeys(ctx, a.db, userID)
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	sessions, err := a.iam.ListSessions(ctx, a.db, userID)
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	tokens, err := a.iam.ListVerificationTokens(ctx, a.db, userID)
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	leases, err := a.landRegistry.GetLeasesByLeaseholder(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+
+	// secrets are left out, they are of no use to the user and would let
+	// anyone who gets hold of the archive into the account
+	for i := range keys {
+		keys[i].SecretHash = nil
+	}
+	for i := range sessions {
+		sessions[i].Token = ""
+	}
+	for i := range tokens {
+		tokens[i].Token = ""
+	}
+
+	profile := core.UserProfile{}
+	if profiles[userID] != nil {
+		profile = *profiles[userID]
+	}
+
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"user.json", user},
+		{"profile.json", profile},
+		{"identities.json", identities},
+		{"roles.json", grants},
+		{"api_keys.json", keys},
+		{"sessions.json", sessions},
+		{"verification_tokens.json", tokens},
+		{"leases.json", leases},
+	}
+	for _, file := range files {
+		if err := writeJSONFile(archive, file.name, file.v); err != nil {
+			return fmt.Errorf("Export: %w", err)
+		}
+	}
+
+	if err := a.writePixels(ctx, archive, userID); err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+
+	readme, err := archive.Create("README.txt")
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	if _, err := io.WriteString(readme, exportReadme); err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+
+	return archive.Close()
+}
+
+func writeJSONFile(archive *zip.Writer, name string, v any) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return writeIndentedJSON(f, v)
+}
+
+func writeIndentedJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (a *Accounts) writePixels(ctx context.Context, archive *zip.Writer, userID core.UserID) error {
+	f, err := archive.Create("pixels.csv")
+	if err != nil {
+		return err
+	}
+
+	out := csv.NewWriter(f)
+	out.Write([]string{"canvas_id", "x", "y", "r", "g", "b", "a", "drawn_at"})
+	err = a.pixels.ForEachPixelDrawnBy(ctx, userID, func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error {
+		return out.Write([]string{
+			strconv.FormatInt(canvasID, 10),
+			strconv.FormatInt(pixel.X, 10),
+			strconv.FormatInt(pixel.Y, 10),
+			strconv.Itoa(int(pixel.RGBA.R)),
+			strconv.Itoa(int(pixel.RGBA.G)),
+			strconv.Itoa(int(pixel.RGBA.B)),
+			strconv.Itoa(int(pixel.RGBA.A)),
+			drawnAt.Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// RequestDeletion schedules the user's account for deletion after the cooling-off
+// period. Their leases go to transferLeasesTo if set, and are terminated otherwise.
+func (a *Accounts) RequestDeletion(ctx context.Context, userID core.UserID, transferLeasesTo *core.UserID) (*core.AccountDeletion, error) {
+	if transferLeasesTo != nil {
+		if *transferLeasesTo == userID {
+			return nil, ErrInvalidLeaseTransfer
+		}
+		if ok, err := a.canReceiveLeases(ctx, *transferLeasesTo); err != nil {
+			return nil, fmt.Errorf("RequestDeletion: %w", err)
+		} else if !ok {
+			return nil, ErrInvalidLeaseTransfer
+		}
+	}
+
+	deletion := core.NewAccountDeletion(userID, transferLeasesTo, time.Now().UTC())
+	if err := a.iam.SaveAccountDeletion(ctx, a.db, deletion); err != nil {
+		return nil, fmt.Errorf("RequestDeletion: %w", err)
+	}
+
+	return &deletion, nil
+}
+
+// GetDeletion returns the user's pending deletion, or nil if there is none.
+func (a *Accounts) GetDeletion(ctx context.Context, userID core.UserID) (*core.AccountDeletion, error) {
+	deletion, err := a.iam.GetAccountDeletion(ctx, a.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeletion: %w", err)
+	}
+	if deletion == nil || !deletion.IsPending() {
+		return nil, nil
+	}
+	return deletion, nil
+}
+
+// CancelDeletion cancels the user's pending deletion.
+func (a *Accounts) CancelDeletion(ctx context.Context, userID core.UserID) error {
+	deletion, err := a.GetDeletion(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if deletion == nil {
+		return ErrNoPendingDeletion
+	}
+
+	now := time.Now().UTC()
+	deletion.CanceledAt = &now
+	if err := a.iam.SaveAccountDeletion(ctx, a.db, *deletion); err != nil {
+		return fmt.Errorf("CancelDeletion: %w", err)
+	}
+
+	return nil
+}
+
+// Run deletes the accounts whose cooling-off period is over every interval until the context is done.
+func (a *Accounts) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := a.ProcessDueDeletions(ctx); err != nil && !errors.Is(err, ErrAccountDeletionsLocked) {
+			fmt.Println("Accounts.ProcessDueDeletions", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDueDeletions deletes the accounts whose cooling-off period is over and
+// returns how many were deleted. Only one instance deletes accounts at a time.
+// A failed deletion is recorded and the others go on, it is retried on the
+// next run after those that have not failed as often.
+func (a *Accounts) ProcessDueDeletions(ctx context.Context) (int, error) {
+	release, ok, err := storage.TryAdvisoryLock(ctx, a.db, accountDeletionLockKey)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrAccountDeletionsLocked
+	}
+	defer release()
+
+	due, err := a.iam.ListDueAccountDeletions(ctx, a.db, time.Now().UTC(), accountDeletionBatch)
+	if err != nil {
+		return 0, fmt.Errorf("ProcessDueDeletions: %w", err)
+	}
+
+	count := 0
+	var errs []error
+	for _, deletion := range due {
+		if err := a.deleteAccount(ctx, deletion); err != nil {
+			errs = append(errs, fmt.Errorf("ProcessDueDeletions %s: %w", deletion.UserID, err))
+			if err := a.iam.RecordAccountDeletionAttempt(ctx, a.db, deletion.UserID, time.Now().UTC(), err); err != nil {
+				errs = append(errs, fmt.Errorf("ProcessDueDeletions %s: %w", deletion.UserID, err))
+			}
+			continue
+		}
+		count++
+	}
+
+	return count, errors.Join(errs...)
+}
+
+// deleteAccount hands over or terminates the user's leases, detaches them from
+// their pixels, then removes their personal data and anonymizes their users row.
+// Every step can be rerun, so a deletion that fails halfway is finished by the next run.
+func (a *Accounts) deleteAccount(ctx context.Context, deletion core.AccountDeletion) error {
+	now := time.Now().UTC()
+
+	transferTo := deletion.TransferLeasesTo
+	if transferTo != nil {
+		// the recipient may have been banned or deleted during the cooling-off period
+		ok, err := a.canReceiveLeases(ctx, *transferTo)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			transferTo = nil
+		}
+	}
+
+	// in a unit of work, so that the draws checked against the leases wait for them
+	err := a.unitOfWork.Do(ctx, func(ctx context.Context, work Work) error {
+		leases, err := work.LandRegistry.GetLeasesByLeaseholder(ctx, deletion.UserID)
+		if err != nil {
+			return err
+		}
+		for _, lease := range leases {
+			if lease.Status == core.LeaseStatusExpired || lease.Status == core.LeaseStatusTerminated {
+				continue
+			}
+			if transferTo != nil {
+				lease.TransferTo(*transferTo, now, deletion.UserID)
+			} else {
+				lease.Terminate(now, deletion.UserID)
+			}
+			if err := work.LandRegistry.SaveLease(ctx, lease); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.pixels.AnonymizeDrawer(ctx, deletion.UserID); err != nil {
+		return err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user, err := a.iam.GetUserForUpdate(ctx, tx, deletion.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err := a.iam.DeleteUserData(ctx, tx, user.ID); err != nil {
+		return err
+	}
+
+	user.Anonymize()
+	if err := a.iam.SaveUser(ctx, tx, *user); err != nil {
+		return err
+	}
+
+	deletion.CompletedAt = &now
+	if err := a.iam.SaveAccountDeletion(ctx, tx, deletion); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// canReceiveLeases reports whether the user exists and may hold leases.
+func (a *Accounts) canReceiveLeases(ctx context.Context, userID core.UserID) (bool, error) {
+	user, err := a.iam.GetUserBy(ctx, a.db, "id", userID.String())
+	if errors.Is(err, storage.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return !user.IsRestrictedAt(time.Now()), nil
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+)
+
+// apiKeyTouchInterval is how stale an API key's last use may get before it is
+// written again, so that busy bots don't write on every request.
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrTooManyAPIKeys     = fmt.Errorf("a user can have at most %d active api keys", core.MaxAPIKeysPerUser)
+	ErrAPIKeyRateLimited  = errors.New("api key rate limit exceeded")
+	ErrAPIKeyUserDisabled = errors.New("the api key's user is banned")
+)
+
+// APIKeys issues, authenticates and revokes the API keys users give their bots.
+type APIKeys struct {
+	db  *sql.DB
+	iam *storage.IAMStore
+
+	mu       sync.Mutex
+	limiters map[string]*keyLimiter
+}
+
+// keyLimiter is a key's rate limiter, replaced when the key's limit changes.
+type keyLimiter struct {
+	perMinute int
+	limiter   *RateLimiter
+}
+
+func NewAPIKeys(db *sql.DB, iam *storage.IAMStore) *APIKeys {
+	return &APIKeys{db: db, iam: iam, limiters: map[string]*keyLimiter{}}
+}
+
+// Create issues a new key and returns it with its token, which cannot be retrieved later.
+func (ak *APIKeys) Create(ctx context.Context, userID core.UserID, name string, scopes []string, rateLimitPerMinute int) (*core.APIKey, string, error) {
+	key, token, err := core.NewAPIKey(userID, name, scopes, rateLimitPerMinute)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys, err := ak.iam.ListAPIKeys(ctx, ak.db, userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("Create: %w", err)
+	}
+	active := 0
+	for _, k := range keys {
+		if k.IsActive() {
+			active++
+		}
+	}
+	if active >= core.MaxAPIKeysPerUser {
+		return nil, "", ErrTooManyAPIKeys
+	}
+
+	if err := ak.iam.SaveAPIKey(ctx, ak.db, key); err != nil {
+		return nil, "", fmt.Errorf("Create: %w", err)
+	}
+
+	return &key, token, nil
+}
+
+// List returns the user's keys, revoked ones included.
+func (ak *APIKeys) List(ctx context.Context, userID core.UserID) ([]core.APIKey, error) {
+	keys, err := ak.iam.ListAPIKeys(ctx, ak.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("List: %w", err)
+	}
+	return keys, nil
+}
+
+// Revoke revokes one of the user's keys.
+func (ak *APIKeys) Revoke(ctx context.Context, userID core.UserID, keyID string) error {
+	key, err := ak.iam.GetAPIKey(ctx, ak.db, keyID)
+	if err != nil {
+		return fmt.Errorf("Revoke: %w", err)
+	}
+	if key == nil || key.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	if !key.IsActive() {
+		return nil
+	}
+
+	key.Revoke()
+	if err := ak.iam.SaveAPIKey(ctx, ak.db, *key); err != nil {
+		return fmt.Errorf("Revoke: %w", err)
+	}
+
+	return nil
+}
+
+// Authenticate returns the user and key of an active API key token, and counts
+// the request against the key's rate limit.
+func (ak *APIKeys) Authenticate(ctx context.Context, token string) (*core.User, *core.APIKey, error) {
+	id, secret, err := core.ParseAPIKeyToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ak.iam.GetAPIKey(ctx, ak.db, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Authenticate: %w", err)
+	}
+	if key == nil || !key.IsActive() || !key.Matches(secret) {
+		return nil, nil, core.ErrInvalidAPIKey
+	}
+
+	user, err := ak.iam.GetUserBy(ctx, ak.db, "id", key.UserID.String())
+	if errors.Is(err, storage.ErrNoRows) {
+		return nil, nil, core.ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Authenticate: %w", err)
+	}
+	if user.Status == core.UserStatusBanned && user.IsRestrictedAt(time.Now()) {
+		return nil, nil, ErrAPIKeyUserDisabled
+	}
+
+	if ok, _ := ak.limiter(key).Allow(key.ID, 1); !ok {
+		return nil, nil, ErrAPIKeyRateLimited
+	}
+
+	now := time.Now().UTC()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := ak.iam.TouchAPIKey(ctx, ak.db, key.ID, now); err != nil {
+			return nil, nil, fmt.Errorf("Authenticate: %w", err)
+		}
+		key.LastUsedAt = &now
+	}
+
+	return user, key, nil
+}
+
+func (ak *APIKeys) limiter(key *core.APIKey) *RateLimiter {
+	ak.mu.Lock()
+	defer ak.mu.Unlock()
+
+	kl, ok := ak.limiters[key.ID]
+	if !ok || kl.perMinute != key.RateLimitPerMinute {
+		kl = &keyLimiter{
+			perMinute: key.RateLimitPerMinute,
+			limiter:   NewRateLimiter(float64(key.RateLimitPerMinute)/60, key.RateLimitPerMinute),
+		}
+		ak.limiters[key.ID] = kl
+	}
+	return kl.limiter
+}
+
+// PruneLimits forgets idle rate limiting state, it is meant to run periodically.
+func (ak *APIKeys) PruneLimits() {
+	ak.mu.Lock()
+	defer ak.mu.Unlock()
+
+	for id, kl := range ak.limiters {
+		if kl.limiter.Prune() == 0 {
+			delete(ak.limiters, id)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+)
+
+var (
+	ErrForbidden  = errors.New("you are not allowed to do this")
+	ErrGlobalRole = errors.New("the role can only be granted globally")
+)
+
+// Authorizer decides what users may do from the roles granted to them. Every
+// signed-in user has the user role, and bootstrap admins have the admin role
+// without it being stored, so that a fresh deployment can grant the first ones.
+type Authorizer struct {
+	db              *sql.DB
+	iam             *storage.IAMStore
+	bootstrapAdmins map[core.UserID]bool
+}
+
+func NewAuthorizer(db *sql.DB, iam *storage.IAMStore, bootstrapAdmins ...core.UserID) *Authorizer {
+	a := &Authorizer{db: db, iam: iam, bootstrapAdmins: map[core.UserID]bool{}}
+	for _, id := range bootstrapAdmins {
+		a.bootstrapAdmins[id] = true
+	}
+	return a
+}
+
+// Grants returns the roles of the user, including the implicit ones.
+func (a *Authorizer) Grants(ctx context.Context, userID core.UserID) (core.RoleGrants, error) {
+	if userID.IsAnonymous() {
+		return core.RoleGrants{}, nil
+	}
+
+	grants, err := a.iam.GetRoleGrants(ctx, a.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Grants: %w", err)
+	}
+
+	grants = append(grants, core.RoleGrant{UserID: userID, Role: core.RoleUser})
+	if a.bootstrapAdmins[userID] {
+		grants = append(grants, core.RoleGrant{UserID: userID, Role: core.RoleAdmin})
+	}
+
+	return grants, nil
+}
+
+// Can reports whether the user has the permission on the canvas, or globally if canvasID is nil.
+func (a *Authorizer) Can(ctx context.Context, userID core.UserID, perm core.Permission, canvasID *int64) (bool, error) {
+	grants, err := a.Grants(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return grants.Allow(perm, canvasID), nil
+}
+
+// Grant gives the user a role, on a single canvas if canvasID is set. Global
+// roles cannot be granted on a canvas, see core.Role.IsGlobal.
+func (a *Authorizer) Grant(ctx context.Context, grantedBy core.UserID, userID core.UserID, role core.Role, canvasID *int64) error {
+	if canvasID != nil && role.IsGlobal() {
+		return ErrGlobalRole
+	}
+	if role == core.RoleUser {
+		return nil
+	}
+
+	if _, err := a.iam.GetUserBy(ctx, a.db, "id", userID.String()); errors.Is(err, storage.ErrNoRows) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return fmt.Errorf("Grant: %w", err)
+	}
+
+	grant := core.RoleGrant{
+		UserID:    userID,
+		Role:      role,
+		CanvasID:  canvasID,
+		GrantedAt: time.Now().UTC(),
+		GrantedBy: grantedBy,
+	}
+	if err := a.iam.SaveRoleGrant(ctx, a.db, grant); err != nil {
+		return fmt.Errorf("Grant: %w", err)
+	}
+
+	return nil
+}
+
+// Revoke takes a role away from the user, on a single canvas if canvasID is set.
+func (a *Authorizer) Revoke(ctx context.Context, userID core.UserID, role core.Role, canvasID *int64) error {
+	if err := a.iam.DeleteRoleGrant(ctx, a.db, userID, role, canvasID); err != nil {
+		return fmt.Errorf("Revoke: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"golang.org/x/exp/slices"
+)
+
+// CanvasRegistry holds the settings of every known canvas.
+// Unknown canvases fall back to the registry's defaults, core.NewCanvas's unless set.
+type CanvasRegistry struct {
+	mu       sync.RWMutex
+	canvases map[int64]core.Canvas
+	defaults core.Canvas
+}
+
+func NewCanvasRegistry(canvases ...core.Canvas) *CanvasRegistry {
+	cr := &CanvasRegistry{canvases: map[int64]core.Canvas{}, defaults: core.NewCanvas(0)}
+	for _, canvas := range canvases {
+		cr.Put(canvas)
+	}
+	return cr
+}
+
+func (cr *CanvasRegistry) Get(canvasID int64) core.Canvas {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	if canvas, ok := cr.canvases[canvasID]; ok {
+		return canvas
+	}
+
+	canvas := cr.defaults
+	canvas.ID = canvasID
+	canvas.TileSides = slices.Clone(canvas.TileSides)
+	canvas.TileFormats = slices.Clone(canvas.TileFormats)
+	return canvas
+}
+
+func (cr *CanvasRegistry) Put(canvas core.Canvas) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.canvases[canvas.ID] = canvas
+}
+
+// Load puts every canvas saved in the store, e.g. at startup and then
+// periodically to pick up the settings other servers saved.
+func (cr *CanvasRegistry) Load(ctx context.Context, store storage.CanvasStore) error {
+	canvases, err := store.LoadCanvases(ctx)
+	if err != nil {
+		return fmt.Errorf("Load: %w", err)
+	}
+	for _, canvas := range canvases {
+		cr.Put(canvas)
+	}
+	return nil
+}
+
+// SetDefaultTileSides changes the tile sides of the canvases that were not Put.
+func (cr *CanvasRegistry) SetDefaultTileSides(sides []int64, maxSide int64) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.defaults.TileSides = slices.Clone(sides)
+	cr.defaults.MaxTileSide = maxSide
+}
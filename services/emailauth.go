@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/dbtx"
+	"github.com/lazharichir/draw/utils"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid, expired or already used verification token")
+	ErrEmailTaken               = errors.New("email address already in use")
+)
+
+// EmailAuth signs users in (and up) without a password, by sending them a
+// single-use verification token they redeem for a session.
+type EmailAuth struct {
+	db     *sql.DB
+	iam    *storage.IAMStore
+	mailer Mailer
+	appURL string
+}
+
+// NewEmailAuth returns an EmailAuth whose emails link to appURL's /signin page.
+func NewEmailAuth(db *sql.DB, iam *storage.IAMStore, mailer Mailer, appURL string) *EmailAuth {
+	return &EmailAuth{db: db, iam: iam, mailer: mailer, appURL: appURL}
+}
+
+// RequestSignIn emails a sign-in token to the address, or a sign-up token if no user has it yet.
+func (ea *EmailAuth) RequestSignIn(ctx context.Context, email string) error {
+	email, err := core.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	kind := core.VerificationTokenKindSignin
+	var userID core.UserID
+
+	user, err := ea.iam.GetUserBy(ctx, ea.db, "email", email)
+	switch {
+	case err == nil:
+		userID = user.ID
+	case errors.Is(err, storage.ErrNoRows):
+		// the user is created with this ID when the token is redeemed
+		kind = core.VerificationTokenKindSignup
+		userID = core.NewUserID()
+	default:
+		return fmt.Errorf("RequestSignIn: %w", err)
+	}
+
+	vt, err := core.NewVerificationToken(userID, kind)
+	if err != nil {
+		return fmt.Errorf("RequestSignIn: %w", err)
+	}
+	vt.SetEmail(email)
+
+	if err := ea.iam.SaveVerificationToken(ctx, ea.db, vt); err != nil {
+		return fmt.Errorf("RequestSignIn: %w", err)
+	}
+
+	subject := "Sign in to draw"
+	if kind == core.VerificationTokenKindSignup {
+		subject = "Welcome to draw"
+	}
+
+	link := fmt.Sprintf("%s/signin?token=%s", ea.appURL, url.QueryEscape(vt.Token))
+	body := fmt.Sprintf(
+		"Click the link below to sign in:\n\n%s\n\nOr enter this code: %s\n\nIt expires in %d minutes and can only be used once. If you did not ask for it, you can ignore this email.",
+		link,
+		vt.Token,
+		int(time.Until(vt.ExpiresAt).Round(time.Minute).Minutes()),
+	)
+
+	if err := ea.mailer.Send(ctx, Email{To: email, Subject: subject, Body: body}); err != nil {
+		return fmt.Errorf("RequestSignIn: %w", err)
+	}
+
+	return nil
+}
+
+// Redeem consumes a sign-in or sign-up token, creating the user on sign-up, and issues a new session.
+func (ea *EmailAuth) Redeem(ctx context.Context, token string) (*core.User, *core.Session, error) {
+	tx, err := ea.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Redeem: %w", err)
+	}
+	defer tx.Rollback()
+
+	vt, err := ea.iam.GetVerificationToken(ctx, tx, token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Redeem: %w", err)
+	}
+	if vt == nil || !vt.IsActive() || vt.Email == nil {
+		return nil, nil, ErrInvalidVerificationToken
+	}
+	if vt.Kind != core.VerificationTokenKindSignin && vt.Kind != core.VerificationTokenKindSignup {
+		return nil, nil, ErrInvalidVerificationToken
+	}
+
+	now := time.Now().UTC()
+	if ok, err := ea.iam.UseVerificationToken(ctx, tx, vt.Token, now); err != nil {
+		return nil, nil, fmt.Errorf("Redeem: %w", err)
+	} else if !ok {
+		return nil, nil, ErrInvalidVerificationToken
+	}
+
+	user, err := ea.iam.GetUserBy(ctx, tx, "email", *vt.Email)
+	switch {
+	case err == nil:
+		// signing in, or signing up with an address that got registered in the meantime
+	case errors.Is(err, storage.ErrNoRows) && vt.Kind == core.VerificationTokenKindSignup:
+		user, err = newUser(ctx, ea.iam, tx, vt.UserID, *vt.Email, now)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Redeem: %w", err)
+		}
+	case errors.Is(err, storage.ErrNoRows):
+		return nil, nil, ErrInvalidVerificationToken
+	default:
+		return nil, nil, fmt.Errorf("Redeem: %w", err)
+	}
+
+	user, session, err := startSession(ctx, ea.iam, tx, user.ID, now)
+	if errors.Is(err, ErrSignInDisabled) {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Redeem: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("Redeem: %w", err)
+	}
+
+	return user, session, nil
+}
+
+// RequestEmailChange emails a change_email token to the user's new address, which
+// only replaces the current one once the token is redeemed.
+func (ea *EmailAuth) RequestEmailChange(ctx context.Context, userID core.UserID, newEmail string) error {
+	newEmail, err := core.NormalizeEmail(newEmail)
+	if err != nil {
+		return err
+	}
+
+	user, err := ea.iam.GetUserBy(ctx, ea.db, "id", userID.String())
+	if err != nil {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+	if user.Email == newEmail {
+		return nil
+	}
+
+	// checked again when the token is redeemed, this only saves a pointless email
+	if _, err := ea.iam.GetUserBy(ctx, ea.db, "email", newEmail); err == nil {
+		return ErrEmailTaken
+	} else if !errors.Is(err, storage.ErrNoRows) {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+
+	vt, err := core.NewVerificationToken(user.ID, core.VerificationTokenKindChangeEmail)
+	if err != nil {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+	vt.SetEmail(newEmail)
+
+	if err := ea.iam.SaveVerificationToken(ctx, ea.db, vt); err != nil {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/confirm-email?token=%s", ea.appURL, url.QueryEscape(vt.Token))
+	body := fmt.Sprintf(
+		"Click the link below to use this address for your draw account %s:\n\n%s\n\nIt expires in %d minutes and can only be used once. If you did not ask for it, you can ignore this email.",
+		user.Username,
+		link,
+		int(time.Until(vt.ExpiresAt).Round(time.Minute).Minutes()),
+	)
+
+	if err := ea.mailer.Send(ctx, Email{To: newEmail, Subject: "Confirm your new draw email address", Body: body}); err != nil {
+		return fmt.Errorf("RequestEmailChange: %w", err)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange consumes a change_email token and moves the user to the new
+// address, then lets the previous address know about it.
+func (ea *EmailAuth) ConfirmEmailChange(ctx context.Context, token string) (*core.User, error) {
+	tx, err := ea.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmEmailChange: %w", err)
+	}
+	defer tx.Rollback()
+
+	vt, err := ea.iam.GetVerificationToken(ctx, tx, token)
+	if err != nil {
+		return nil, fmt.Errorf("ConfirmEmailChange: %w", err)
+	}
+	if vt == nil || !vt.IsActive() || vt.Email == nil || vt.Kind != core.VerificationTokenKindChangeEmail {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if ok, err := ea.iam.UseVerificationToken(ctx, tx, vt.Token, time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("ConfirmEmailChange: %w", err)
+	} else if !ok {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := ea.iam.GetUserForUpdate(ctx, tx, vt.UserID)
+	if errors.Is(err, storage.ErrNoRows) {
+		return nil, ErrInvalidVerificationToken
+	} else if err != nil {
+		return nil, fmt.Errorf("ConfirmEmailChange: %w", err)
+	}
+
+	oldEmail := user.Email
+	user.Email = *vt.Email
+
+	// the unique index on users.email settles races between concurrent changes and sign-ups
+	if err := ea.iam.SaveUser(ctx, tx, *user); storage.IsUniqueViolation(err) {
+		return nil, ErrEmailTaken
+	} else if err != nil {
+		return nil, fmt.Errorf("ConfirmEmailChange: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ConfirmEmailChange: %w", err)
+	}
+
+	if oldEmail != "" && oldEmail != user.Email {
+		body := fmt.Sprintf(
+			"The email address of your draw account %s was changed to %s.\n\nIf you did not do this, contact us right away.",
+			user.Username,
+			user.Email,
+		)
+		if err := ea.mailer.Send(ctx, Email{To: oldEmail, Subject: "Your draw email address was changed", Body: body}); err != nil {
+			// the change is done, a missing notice should not undo it
+			fmt.Println("ConfirmEmailChange: notifying previous address", err)
+		}
+	}
+
+	return user, nil
+}
+
+// newUser builds a user on probation with a username derived from their email address.
+func newUser(ctx context.Context, iam *storage.IAMStore, db dbtx.DBTx, userID core.UserID, email string, now time.Time) (*core.User, error) {
+	username := core.UsernameFromEmail(email)
+	for attempt := 0; ; attempt++ {
+		_, err := iam.GetUserBy(ctx, db, "username", username)
+		if errors.Is(err, storage.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attempt == 5 {
+			return nil, fmt.Errorf("could not find a free username for %s", email)
+		}
+		username = core.UsernameFromEmail(email) + "_" + utils.NewUsernameSuffix()
+	}
+
+	return &core.User{
+		ID:        userID,
+		Status:    core.UserStatusProbation,
+		Username:  username,
+		Email:     email,
+		CreatedAt: now,
+	}, nil
+}
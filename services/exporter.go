@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/utils"
+)
+
+var (
+	ErrExportTooLarge = errors.New("export is too large")
+	ErrInvalidExport  = errors.New("invalid export")
+)
+
+const (
+	// defaultMaxExportSide is the largest width or height of an exported image.
+	defaultMaxExportSide int64 = 8192
+	// defaultMaxExportAreaSide is the largest width or height of an exported area, before scaling.
+	defaultMaxExportAreaSide int64 = 16384
+	// defaultMaxExportAreaPixels is the most pixels of an exported area, before scaling.
+	// The area is rendered at its native size first, so downscaling does not make it cheaper.
+	defaultMaxExportAreaPixels = defaultMaxExportSide * defaultMaxExportSide
+)
+
+// Exporter renders arbitrary areas of a canvas into images, stitching cached
+// tiles where available and querying the pixel storage otherwise.
+type Exporter struct {
+	storage             storage.PixelStore
+	tileCache           *TileCache
+	canvases            *CanvasRegistry
+	maxExportSide       int64
+	maxExportAreaSide   int64
+	maxExportAreaPixels int64
+}
+
+func NewExporter(storage storage.PixelStore, tileCache *TileCache, canvases *CanvasRegistry) *Exporter {
+	return &Exporter{
+		storage:             storage,
+		tileCache:           tileCache,
+		canvases:            canvases,
+		maxExportSide:       defaultMaxExportSide,
+		maxExportAreaSide:   defaultMaxExportAreaSide,
+		maxExportAreaPixels: defaultMaxExportAreaPixels,
+	}
+}
+
+// Export renders the area (max exclusive) scaled by the given factor. Scales of 1 or
+// more must be whole numbers and enlarge every pixel into a block, smaller scales
+// shrink the image with utils.ResizeImage.
+func (ex *Exporter) Export(ctx context.Context, canvasID int64, area core.Area, scale float64) (image.Image, error) {
+	width, height := area.Width(), area.Height()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("%w: cannot export an empty area", ErrInvalidExport)
+	}
+	if width > ex.maxExportAreaSide || height > ex.maxExportAreaSide {
+		return nil, fmt.Errorf("%w: area %dx%d exceeds %dx%d", ErrExportTooLarge, width, height, ex.maxExportAreaSide, ex.maxExportAreaSide)
+	}
+	if width*height > ex.maxExportAreaPixels {
+		return nil, fmt.Errorf("%w: area %dx%d exceeds %d pixels", ErrExportTooLarge, width, height, ex.maxExportAreaPixels)
+	}
+
+	if scale <= 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return nil, fmt.Errorf("%w: scale %v", ErrInvalidExport, scale)
+	}
+	if scale > 1 && scale != math.Trunc(scale) {
+		return nil, fmt.Errorf("%w: scale %v, upscaling must be by a whole number", ErrInvalidExport, scale)
+	}
+
+	outWidth := int64(math.Max(1, math.Round(float64(width)*scale)))
+	outHeight := int64(math.Max(1, math.Round(float64(height)*scale)))
+	if outWidth > ex.maxExportSide || outHeight > ex.maxExportSide {
+		return nil, fmt.Errorf("%w: output %dx%d exceeds %dx%d", ErrExportTooLarge, outWidth, outHeight, ex.maxExportSide, ex.maxExportSide)
+	}
+
+	img, err := ex.RenderArea(ctx, canvasID, area)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case scale == 1:
+		return img, nil
+	case scale > 1:
+		return utils.UpscaleImage(img, int64(scale)), nil
+	default:
+		return utils.ResizeImage(img, outWidth, outHeight)
+	}
+}
+
+// RenderArea renders the area (max exclusive) at its native size.
+func (ex *Exporter) RenderArea(ctx context.Context, canvasID int64, area core.Area) (*image.RGBA, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, int(area.Width()), int(area.Height())))
+	side := ex.tileSide(canvasID)
+
+	origin := core.GetTileAreaFromPoint(area.Min, side).Min
+	for tileY := origin.Y; tileY < area.Max.Y; tileY += side {
+		for tileX := origin.X; tileX < area.Max.X; tileX += side {
+			tile := core.NewAreaSquare(core.Pt(tileX, tileY), side)
+			if err := ex.renderTileInto(ctx, canvasID, dst, area, tile); err != nil {
+				return nil, fmt.Errorf("RenderArea: %w", err)
+			}
+		}
+	}
+
+	return dst, nil
+}
+
+// renderTileInto draws the part of the tile overlapping the area into dst,
+// from the tile cache if the tile is cached or from the pixel storage otherwise.
+func (ex *Exporter) renderTileInto(ctx context.Context, canvasID int64, dst *image.RGBA, area core.Area, tile core.Area) error {
+	overlap, ok := tile.Intersect(area)
+	if !ok || overlap.Width() == 0 || overlap.Height() == 0 {
+		return nil
+	}
+
+	// where the overlap lands in the exported image
+	dstRect := image.Rect(
+		int(overlap.Min.X-area.Min.X),
+		int(overlap.Min.Y-area.Min.Y),
+		int(overlap.Max.X-area.Min.X),
+		int(overlap.Max.Y-area.Min.Y),
+	)
+
+	cached, err := ex.tileCache.GetTile(ctx, canvasID, tile)
+	if err != nil {
+		return err
+	}
+
+	if cached != nil {
+		srcPt := cached.Bounds().Min.Add(image.Pt(int(overlap.Min.X-tile.Min.X), int(overlap.Min.Y-tile.Min.Y)))
+		draw.Draw(dst, dstRect, cached, srcPt, draw.Src)
+		return nil
+	}
+
+	// only query the overlap (max is inclusive)
+	pixels, err := ex.storage.GetLatestPixelsForArea(ctx, canvasID, overlap.Min, overlap.Max.Translate(-1, -1), time.Time{})
+	if err != nil {
+		return err
+	}
+
+	for _, pixel := range pixels {
+		dst.SetRGBA(int(pixel.X-area.Min.X), int(pixel.Y-area.Min.Y), pixel.RGBA)
+	}
+
+	return nil
+}
+
+// tileSide returns the side of the largest tiles served by the canvas.
+func (ex *Exporter) tileSide(canvasID int64) int64 {
+	canvas := ex.canvases.Get(canvasID)
+	side := int64(0)
+	for _, s := range canvas.TileSides {
+		if canvas.IsTileSide(s) && s > side {
+			side = s
+		}
+	}
+	if side == 0 {
+		return core.DefaultMaxTileSide
+	}
+	return side
+}
@@ -0,0 +1,101 @@
+package services_test
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestExporter() (*services.Exporter, storage.PixelStore, *services.TileCache) {
+	pixels := storage.NewMemoryPixelStore()
+	cache := services.NewTileCache(services.NewMemoryTileCacheBackend())
+	canvases := services.NewCanvasRegistry(core.Canvas{ID: 1, TileSides: []int64{4}, MaxTileSide: 4, TileFormats: []core.TileFormat{core.TileFormatPNG}})
+	return services.NewExporter(pixels, cache, canvases), pixels, cache
+}
+
+func TestExporter_Export(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	area := core.NewArea(core.Pt(0, 0), core.Pt(2, 2))
+
+	tests := []struct {
+		name   string
+		area   core.Area
+		scale  float64
+		err    error
+		bounds image.Rectangle
+	}{
+		{"native", area, 1, nil, image.Rect(0, 0, 2, 2)},
+		{"upscaled", area, 3, nil, image.Rect(0, 0, 6, 6)},
+		{"downscaled", area, 0.5, nil, image.Rect(0, 0, 1, 1)},
+		{"downscaled to at least a pixel", area, 0.01, nil, image.Rect(0, 0, 1, 1)},
+		{"empty area", core.NewArea(core.Pt(0, 0), core.Pt(0, 2)), 1, services.ErrInvalidExport, image.Rectangle{}},
+		{"zero scale", area, 0, services.ErrInvalidExport, image.Rectangle{}},
+		{"negative scale", area, -2, services.ErrInvalidExport, image.Rectangle{}},
+		{"fractional upscale", area, 1.5, services.ErrInvalidExport, image.Rectangle{}},
+		{"area too wide", core.NewAreaWH(core.Pt(0, 0), 20000, 1), 0.1, services.ErrExportTooLarge, image.Rectangle{}},
+		{"area with too many pixels", core.NewAreaSquare(core.Pt(0, 0), 16384), 0.1, services.ErrExportTooLarge, image.Rectangle{}},
+		{"output too large", core.NewAreaSquare(core.Pt(0, 0), 8192), 2, services.ErrExportTooLarge, image.Rectangle{}},
+	}
+
+	exporter, pixels, _ := newTestExporter()
+	require.NoError(t, pixels.DrawPixels(context.Background(), 1, "alice", []core.Pixel{
+		core.NewPixel(0, 0, red), core.NewPixel(1, 0, red), core.NewPixel(0, 1, red), core.NewPixel(1, 1, red),
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := exporter.Export(context.Background(), 1, tt.area, tt.scale)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.bounds, img.Bounds())
+			assert.Equal(t, red, color.RGBAModel.Convert(img.At(0, 0)))
+		})
+	}
+}
+
+func TestExporter_RenderArea(t *testing.T) {
+	ctx := context.Background()
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{G: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	exporter, pixels, cache := newTestExporter()
+
+	// pixels in three 4x4 tiles, one of them cached with a different color
+	require.NoError(t, pixels.DrawPixels(ctx, 1, "alice", []core.Pixel{
+		core.NewPixel(-1, -1, red),
+		core.NewPixel(3, 3, red),
+		core.NewPixel(4, 0, green),
+	}))
+	cached := core.NewTile(core.NewAreaSquare(core.Pt(0, 0), 4))
+	cached.AddPixels(core.NewPixel(3, 3, blue))
+	require.NoError(t, cache.PutTile(ctx, 1, cached, cached.AsImage(), time.Now()))
+
+	// the area straddles four tiles and none of their edges
+	area := core.NewArea(core.Pt(-2, -2), core.Pt(6, 2))
+	img, err := exporter.RenderArea(ctx, 1, area)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 4), img.Bounds())
+
+	at := func(x, y int64) color.RGBA { return img.RGBAAt(int(x-area.Min.X), int(y-area.Min.Y)) }
+	assert.Equal(t, red, at(-1, -1), "from the pixel store")
+	assert.Equal(t, green, at(4, 0), "from the pixel store")
+	assert.Equal(t, color.RGBA{}, at(3, 1), "from the cached tile")
+	assert.Equal(t, color.RGBA{}, at(5, 1), "never drawn")
+
+	// the cached tile wins over the pixel store
+	area = core.NewArea(core.Pt(2, 2), core.Pt(5, 5))
+	img, err = exporter.RenderArea(ctx, 1, area)
+	require.NoError(t, err)
+	assert.Equal(t, blue, at(3, 3))
+}
@@ -8,20 +8,45 @@ import (
 	"time"
 
 	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/dbtx"
 )
 
-var ErrCannotDrawInArea = func(drawerID int, topLeft, bottomRight core.Point) error {
-	return fmt.Errorf("drawer %d cannot draw in area tl%v br%v", drawerID, topLeft, bottomRight)
+var ErrCannotDrawInArea = func(drawerID core.UserID, topLeft, bottomRight core.Point) error {
+	return fmt.Errorf("drawer '%s' cannot draw in area tl%v br%v", drawerID, topLeft, bottomRight)
 }
 
-type LandRegistry struct {
-	db *sql.DB
+// LandRegistry keeps the leases of the canvases and decides who may draw where.
+type LandRegistry interface {
+	SaveLease(ctx context.Context, lease core.Lease) error
+	DeleteLease(ctx context.Context, id string) error
+	// GetLease returns the lease, or nil if there is none with that ID.
+	GetLease(ctx context.Context, leaseID string) (*core.Lease, error)
+	GetLeasesByID(ctx context.Context, ids ...string) ([]core.Lease, error)
+	GetLeasesByPoint(ctx context.Context, canvasID int64, point core.Point) ([]core.Lease, error)
+	GetLeasesByArea(ctx context.Context, canvasID int64, area core.Area) ([]core.Lease, error)
+	GetLeasesByLeaseholder(ctx context.Context, leaseholderID core.UserID) ([]core.Lease, error)
+	GetLeasesByCanvas(ctx context.Context, canvasID int64) ([]core.Lease, error)
+	CanDrawPixel(ctx context.Context, canvasID int64, drawerID core.UserID, pixel core.Pixel) (bool, error)
+	CanDrawInArea(ctx context.Context, canvasID int64, drawerID core.UserID, area core.Area) (bool, error)
+
+	// WithTx returns the registry running its queries in tx, see storage.InTx.
+	WithTx(tx dbtx.DBTx) LandRegistry
 }
 
-func NewLandRegistry(db *sql.DB) *LandRegistry {
-	return &LandRegistry{db}
+type pgLandRegistry struct {
+	db dbtx.DBTx
 }
-func (lr *LandRegistry) DeleteLease(ctx context.Context, id string) error {
+
+func NewPGLandRegistry(db *sql.DB) LandRegistry {
+	return &pgLandRegistry{db}
+}
+
+func (lr *pgLandRegistry) WithTx(tx dbtx.DBTx) LandRegistry {
+	return &pgLandRegistry{tx}
+}
+
+func (lr *pgLandRegistry) DeleteLease(ctx context.Context, id string) error {
 	query := `DELETE FROM leases WHERE id = $1`
 	_, err := lr.db.ExecContext(ctx, query, id)
 	if err != nil {
@@ -30,11 +55,26 @@ func (lr *LandRegistry) DeleteLease(ctx context.Context, id string) error {
 	return nil
 }
 
-func (lr *LandRegistry) SaveLease(ctx context.Context, lease core.Lease) error {
+// SaveLease takes the canvas's lock exclusively, so that the lease waits for the
+// draws checked against the previous leases to commit, and the draws checked
+// next wait for it. Outside a transaction it runs in one of its own, which holds
+// the lock until the lease is committed.
+func (lr *pgLandRegistry) SaveLease(ctx context.Context, lease core.Lease) error {
+	if db, ok := lr.db.(*sql.DB); ok {
+		return storage.InTx(ctx, db, func(tx *sql.Tx) error {
+			return lr.WithTx(tx).SaveLease(ctx, lease)
+		})
+	}
+
+	if err := storage.LockCanvas(ctx, lr.db, lease.CanvasID, true); err != nil {
+		return fmt.Errorf("failed to save lease: %w", err)
+	}
+
 	query := `
 		INSERT INTO "leases" ("id", "leaseholder_id", "canvas_id", "tl_x", "tl_y", "br_x", "br_y", "width", "height", "status", "start", "end", "price", "metadata", "updated_at", "updated_by", "created_at", "created_by")
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 		ON CONFLICT ("id") DO UPDATE SET
+			"leaseholder_id" = excluded."leaseholder_id",
 			"status" = excluded."status",
 			"start" = excluded."start",
 			"end" = excluded."end",
@@ -71,7 +111,7 @@ func (lr *LandRegistry) SaveLease(ctx context.Context, lease core.Lease) error {
 	return nil
 }
 
-func (lr *LandRegistry) GetLease(ctx context.Context, leaseID string) (*core.Lease, error) {
+func (lr *pgLandRegistry) GetLease(ctx context.Context, leaseID string) (*core.Lease, error) {
 	leases, err := lr.GetLeasesByID(ctx, leaseID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get lease: %w", err)
@@ -83,7 +123,7 @@ func (lr *LandRegistry) GetLease(ctx context.Context, leaseID string) (*core.Lea
 	return &leases[0], nil
 }
 
-func (lr *LandRegistry) GetLeasesByID(ctx context.Context, ids ...string) ([]core.Lease, error) {
+func (lr *pgLandRegistry) GetLeasesByID(ctx context.Context, ids ...string) ([]core.Lease, error) {
 	leases := []core.Lease{}
 	if len(ids) == 0 {
 		return leases, nil
@@ -163,7 +203,7 @@ func (lr *LandRegistry) GetLeasesByID(ctx context.Context, ids ...string) ([]cor
 	return leases, nil
 }
 
-func (lr *LandRegistry) GetLeasesByPoint(ctx context.Context, canvasID int64, point core.Point) ([]core.Lease, error) {
+func (lr *pgLandRegistry) GetLeasesByPoint(ctx context.Context, canvasID int64, point core.Point) ([]core.Lease, error) {
 	query := `
 		SELECT id
 		FROM leases
@@ -197,7 +237,7 @@ func (lr *LandRegistry) GetLeasesByPoint(ctx context.Context, canvasID int64, po
 	return lr.GetLeasesByID(ctx, ids...)
 }
 
-func (lr *LandRegistry) GetLeasesByArea(ctx context.Context, canvasID int64, area core.Area) ([]core.Lease, error) {
+func (lr *pgLandRegistry) GetLeasesByArea(ctx context.Context, canvasID int64, area core.Area) ([]core.Lease, error) {
 	query := `
 		SELECT id
 		FROM leases
@@ -238,7 +278,69 @@ func (lr *LandRegistry) GetLeasesByArea(ctx context.Context, canvasID int64, are
 	return lr.GetLeasesByID(ctx, ids...)
 }
 
-func (lr *LandRegistry) CanDrawPixel(ctx context.Context, canvasID int64, drawerID int64, pixel core.Pixel) (bool, error) {
+// GetLeasesByLeaseholder returns every lease the user holds or held, whatever its status.
+func (lr *pgLandRegistry) GetLeasesByLeaseholder(ctx context.Context, leaseholderID core.UserID) ([]core.Lease, error) {
+	query := `SELECT id FROM leases WHERE leaseholder_id = $1 ORDER BY created_at`
+
+	rows, err := lr.db.QueryContext(ctx, query, leaseholderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed GetLeasesByLeaseholder: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("failed GetLeasesByLeaseholder scan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return lr.GetLeasesByID(ctx, ids...)
+}
+
+// GetLeasesByCanvas returns every lease on the canvas, whatever its status.
+func (lr *pgLandRegistry) GetLeasesByCanvas(ctx context.Context, canvasID int64) ([]core.Lease, error) {
+	query := `SELECT id FROM leases WHERE canvas_id = $1 ORDER BY created_at, id`
+
+	rows, err := lr.db.QueryContext(ctx, query, canvasID)
+	if err != nil {
+		return nil, fmt.Errorf("failed GetLeasesByCanvas: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("failed GetLeasesByCanvas scan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return lr.GetLeasesByID(ctx, ids...)
+}
+
+// CanDrawPixel takes the canvas's lock shared, so that in a transaction a lease
+// saved meanwhile is either seen, or waits for the transaction to commit.
+func (lr *pgLandRegistry) CanDrawPixel(ctx context.Context, canvasID int64, drawerID core.UserID, pixel core.Pixel) (bool, error) {
+	if err := storage.LockCanvas(ctx, lr.db, canvasID, false); err != nil {
+		return false, fmt.Errorf("CanDrawPixel: %w", err)
+	}
+	return canDrawPixel(ctx, lr, canvasID, drawerID, pixel)
+}
+
+// CanDrawInArea takes the canvas's lock shared, see CanDrawPixel.
+func (lr *pgLandRegistry) CanDrawInArea(ctx context.Context, canvasID int64, drawerID core.UserID, area core.Area) (bool, error) {
+	if err := storage.LockCanvas(ctx, lr.db, canvasID, false); err != nil {
+		return false, fmt.Errorf("CanDrawInArea: %w", err)
+	}
+	return canDrawInArea(ctx, lr, canvasID, drawerID, area)
+}
+
+// canDrawPixel implements LandRegistry.CanDrawPixel on top of GetLeasesByPoint.
+func canDrawPixel(ctx context.Context, lr LandRegistry, canvasID int64, drawerID core.UserID, pixel core.Pixel) (bool, error) {
 	leases, err := lr.GetLeasesByPoint(ctx, canvasID, pixel.Point)
 	if err != nil {
 		return false, fmt.Errorf("CanDrawPixel: %w", err)
@@ -261,14 +363,15 @@ func (lr *LandRegistry) CanDrawPixel(ctx context.Context, canvasID int64, drawer
 		}
 
 		if lease.LeaseholderID == drawerID {
-			return true, nil //fmt.Errorf("CanDrawPixel: %d cannot draw in %s", drawerID, pixel.Point.String())
+			return true, nil //fmt.Errorf("CanDrawPixel: %s cannot draw in %s", drawerID, pixel.Point.String())
 		}
 	}
 
 	return false, nil
 }
 
-func (lr *LandRegistry) CanDrawInArea(ctx context.Context, canvasID int64, drawerID int64, area core.Area) (bool, error) {
+// canDrawInArea implements LandRegistry.CanDrawInArea on top of GetLeasesByArea.
+func canDrawInArea(ctx context.Context, lr LandRegistry, canvasID int64, drawerID core.UserID, area core.Area) (bool, error) {
 	leases, err := lr.GetLeasesByArea(ctx, canvasID, area)
 	if err != nil {
 		return false, fmt.Errorf("CanDrawPixel: %w", err)
@@ -294,7 +397,7 @@ func (lr *LandRegistry) CanDrawInArea(ctx context.Context, canvasID int64, drawe
 
 		// Not allowed if one of the relevant leases is not owned by the drawer.
 		if lease.LeaseholderID == drawerID {
-			return true, nil //fmt.Errorf("CanDrawInArea: %d cannot draw in %s", drawerID, area.String())
+			return true, nil //fmt.Errorf("CanDrawInArea: %s cannot draw in %s", drawerID, area.String())
 		}
 	}
 
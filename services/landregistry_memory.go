@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage/dbtx"
+)
+
+// memoryLandRegistry keeps leases in memory, for development and tests. Its
+// queries match those of the Postgres registry, down to which edges of a lease
+// are inclusive.
+type memoryLandRegistry struct {
+	mu     sync.RWMutex
+	leases map[string]core.Lease
+}
+
+func NewMemoryLandRegistry() LandRegistry {
+	return &memoryLandRegistry{leases: map[string]core.Lease{}}
+}
+
+// WithTx returns the registry itself, writes to memory cannot be rolled back.
+func (lr *memoryLandRegistry) WithTx(tx dbtx.DBTx) LandRegistry {
+	return lr
+}
+
+func (lr *memoryLandRegistry) SaveLease(ctx context.Context, lease core.Lease) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	lease = copyLease(lease)
+	lease.Start = pgTime(lease.Start)
+	lease.End = pgTime(lease.End)
+	lease.UpdatedAt = pgTime(lease.UpdatedAt)
+	lease.CreatedAt = pgTime(lease.CreatedAt)
+
+	// like the upsert, an existing lease keeps its canvas, area and creation
+	if existing, ok := lr.leases[lease.ID]; ok {
+		lease.CanvasID = existing.CanvasID
+		lease.Area = existing.Area
+		lease.CreatedAt = existing.CreatedAt
+		lease.CreatedBy = existing.CreatedBy
+	}
+
+	lr.leases[lease.ID] = lease
+	return nil
+}
+
+func (lr *memoryLandRegistry) DeleteLease(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	delete(lr.leases, id)
+	return nil
+}
+
+func (lr *memoryLandRegistry) GetLease(ctx context.Context, leaseID string) (*core.Lease, error) {
+	leases, err := lr.GetLeasesByID(ctx, leaseID)
+	if err != nil || len(leases) == 0 {
+		return nil, err
+	}
+	return &leases[0], nil
+}
+
+func (lr *memoryLandRegistry) GetLeasesByID(ctx context.Context, ids ...string) ([]core.Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	leases := []core.Lease{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		lease, ok := lr.leases[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		leases = append(leases, copyLease(lease))
+	}
+	return leases, nil
+}
+
+func (lr *memoryLandRegistry) GetLeasesByPoint(ctx context.Context, canvasID int64, point core.Point) ([]core.Lease, error) {
+	return lr.filter(ctx, func(lease core.Lease) bool {
+		a := lease.Area
+		return lease.CanvasID == canvasID &&
+			(a.Min.X <= point.X && a.Max.X > point.X) &&
+			(a.Max.Y >= point.Y && a.Min.Y <= point.Y)
+	})
+}
+
+func (lr *memoryLandRegistry) GetLeasesByArea(ctx context.Context, canvasID int64, area core.Area) ([]core.Lease, error) {
+	return lr.filter(ctx, func(lease core.Lease) bool {
+		a := lease.Area
+		return lease.CanvasID == canvasID &&
+			((a.Min.X <= area.Max.X && a.Max.X > area.Max.X) ||
+				(a.Min.X < area.Min.X && a.Max.X >= area.Min.X)) &&
+			((a.Max.Y >= area.Max.Y && a.Min.Y <= area.Max.Y) ||
+				(a.Max.Y > area.Min.Y && a.Min.Y <= area.Min.Y))
+	})
+}
+
+func (lr *memoryLandRegistry) GetLeasesByLeaseholder(ctx context.Context, leaseholderID core.UserID) ([]core.Lease, error) {
+	leases, err := lr.filter(ctx, func(lease core.Lease) bool {
+		return lease.LeaseholderID == leaseholderID
+	})
+	sort.SliceStable(leases, func(i, j int) bool {
+		return leases[i].CreatedAt.Before(leases[j].CreatedAt)
+	})
+	return leases, err
+}
+
+func (lr *memoryLandRegistry) GetLeasesByCanvas(ctx context.Context, canvasID int64) ([]core.Lease, error) {
+	leases, err := lr.filter(ctx, func(lease core.Lease) bool {
+		return lease.CanvasID == canvasID
+	})
+	sort.SliceStable(leases, func(i, j int) bool {
+		return leases[i].CreatedAt.Before(leases[j].CreatedAt)
+	})
+	return leases, err
+}
+
+func (lr *memoryLandRegistry) CanDrawPixel(ctx context.Context, canvasID int64, drawerID core.UserID, pixel core.Pixel) (bool, error) {
+	return canDrawPixel(ctx, lr, canvasID, drawerID, pixel)
+}
+
+func (lr *memoryLandRegistry) CanDrawInArea(ctx context.Context, canvasID int64, drawerID core.UserID, area core.Area) (bool, error) {
+	return canDrawInArea(ctx, lr, canvasID, drawerID, area)
+}
+
+// filter returns the leases that match, ordered by ID.
+func (lr *memoryLandRegistry) filter(ctx context.Context, match func(core.Lease) bool) ([]core.Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	leases := []core.Lease{}
+	for _, lease := range lr.leases {
+		if match(lease) {
+			leases = append(leases, copyLease(lease))
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ID < leases[j].ID })
+	return leases, nil
+}
+
+// copyLease returns the lease with its own metadata, so that callers cannot
+// change stored leases through it.
+func copyLease(lease core.Lease) core.Lease {
+	lease.Metadata = maps.Clone(lease.Metadata)
+	return lease
+}
+
+// pgTime returns t as Postgres returns it, in UTC and to the microsecond.
+func pgTime(t time.Time) time.Time {
+	return t.UTC().Round(time.Microsecond)
+}
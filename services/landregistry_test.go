@@ -3,30 +3,186 @@ package services_test
 import (
 	"context"
 	"image/color"
+	"math/rand"
 	"testing"
 	"time"
 
 	"github.com/lazharichir/draw/core"
 	"github.com/lazharichir/draw/services"
-	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/storagetest"
 	"github.com/lazharichir/draw/utils"
 	"github.com/stretchr/testify/assert"
 )
 
-var lr *services.LandRegistry
+// TestLandRegistry runs the same suite against every implementation, which
+// must behave identically.
+func TestLandRegistry(t *testing.T) {
+	implementations := map[string]func(t *testing.T) services.LandRegistry{
+		"Postgres": func(t *testing.T) services.LandRegistry {
+			return services.NewPGLandRegistry(storagetest.DB(t))
+		},
+		"Memory": func(t *testing.T) services.LandRegistry {
+			return services.NewMemoryLandRegistry()
+		},
+	}
 
-func init() {
-	// Create a new LandRegistry instance.
-	db := storage.NewPG()
-	lr = services.NewLandRegistry(db)
+	tests := map[string]func(t *testing.T, lr services.LandRegistry){
+		"SaveLease":              testLandRegistrySaveLease,
+		"SaveLeaseKeepsArea":     testLandRegistrySaveLeaseKeepsArea,
+		"GetLeasesByID":          testLandRegistryGetLeasesByID,
+		"GetLeasesByPoint":       testLandRegistryGetLeasesByPoint,
+		"GetLeasesByPointEdges":  testLandRegistryGetLeasesByPointEdges,
+		"GetLeasesByArea":        testLandRegistryGetLeasesByArea,
+		"GetLeasesByLeaseholder": testLandRegistryGetLeasesByLeaseholder,
+		"GetLeasesByCanvas":      testLandRegistryGetLeasesByCanvas,
+		"CanDrawPixel":           testLandRegistryCanDrawPixel,
+		"CanDrawInArea":          testLandRegistryCanDrawInArea,
+	}
+
+	for name, newRegistry := range implementations {
+		t.Run(name, func(t *testing.T) {
+			for name, test := range tests {
+				t.Run(name, func(t *testing.T) {
+					test(t, newRegistry(t))
+				})
+			}
+		})
+	}
 }
 
-func TestLandRegistry_SaveLease(t *testing.T) {
-	// Create a test lease.
-	now := time.Now().UTC()
+// newTestLease returns an active lease of the area on its own canvas, so that
+// tests sharing a database don't see each other's leases.
+func newTestLease(leaseholderID core.UserID, area core.Area) core.Lease {
+	now := time.Now().UTC().Round(time.Microsecond)
+	return core.Lease{
+		ID:            utils.NewLeaseID(),
+		LeaseholderID: leaseholderID,
+		CanvasID:      rand.Int63(),
+		Area:          area,
+		Status:        core.LeaseStatusActive,
+		Start:         now,
+		End:           now.Add(time.Hour),
+		Price:         1000,
+		Metadata:      core.Metadata{"foo": "bar"},
+		UpdatedAt:     now,
+		UpdatedBy:     leaseholderID,
+		CreatedAt:     now,
+		CreatedBy:     leaseholderID,
+	}
+}
+
+func testLandRegistrySaveLeaseKeepsArea(t *testing.T, lr services.LandRegistry) {
+	ctx := context.Background()
+	lease := newTestLease(core.NewUserID(), core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	assert.NoError(t, lr.SaveLease(ctx, lease))
+
+	// a lease changes hands, but never moves
+	moved := lease
+	moved.LeaseholderID = core.NewUserID()
+	moved.Area = core.NewArea(core.Pt(20, 20), core.Pt(30, 30))
+	moved.CanvasID++
+	assert.NoError(t, lr.SaveLease(ctx, moved))
+
+	saved, err := lr.GetLease(ctx, lease.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, moved.LeaseholderID, saved.LeaseholderID)
+	assert.Equal(t, lease.Area, saved.Area)
+	assert.Equal(t, lease.CanvasID, saved.CanvasID)
+}
+
+func testLandRegistryGetLeasesByID(t *testing.T, lr services.LandRegistry) {
+	ctx := context.Background()
+	lease := newTestLease(core.NewUserID(), core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	assert.NoError(t, lr.SaveLease(ctx, lease))
+
+	leases, err := lr.GetLeasesByID(ctx, lease.ID, utils.NewLeaseID())
+	assert.NoError(t, err)
+	assert.Len(t, leases, 1)
+	assert.Equal(t, lease.ID, leases[0].ID)
+
+	leases, err = lr.GetLeasesByID(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, leases)
+
+	missing, err := lr.GetLease(ctx, utils.NewLeaseID())
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func testLandRegistryGetLeasesByPointEdges(t *testing.T, lr services.LandRegistry) {
+	ctx := context.Background()
+	lease := newTestLease(core.NewUserID(), core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	assert.NoError(t, lr.SaveLease(ctx, lease))
+
+	tests := map[core.Point]int{
+		core.Pt(0, 0):   1,
+		core.Pt(9, 9):   1,
+		core.Pt(5, 10):  1, // the bottom edge is inclusive
+		core.Pt(10, 5):  0, // the right edge is not
+		core.Pt(-1, 5):  0,
+		core.Pt(5, -1):  0,
+		core.Pt(5, 11):  0,
+		core.Pt(11, 11): 0,
+	}
+	for point, want := range tests {
+		leases, err := lr.GetLeasesByPoint(ctx, lease.CanvasID, point)
+		assert.NoError(t, err)
+		assert.Len(t, leases, want, "at %v", point)
+	}
+
+	leases, err := lr.GetLeasesByPoint(ctx, lease.CanvasID+1, core.Pt(5, 5))
+	assert.NoError(t, err)
+	assert.Empty(t, leases, "on another canvas")
+}
+
+func testLandRegistryGetLeasesByLeaseholder(t *testing.T, lr services.LandRegistry) {
+	ctx := context.Background()
+	leaseholderID := core.NewUserID()
+
+	newer := newTestLease(leaseholderID, core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	older := newTestLease(leaseholderID, core.NewArea(core.Pt(20, 20), core.Pt(30, 30)))
+	older.CreatedAt = older.CreatedAt.Add(-time.Hour)
+	older.Status = core.LeaseStatusExpired
+	other := newTestLease(core.NewUserID(), core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	for _, lease := range []core.Lease{newer, older, other} {
+		assert.NoError(t, lr.SaveLease(ctx, lease))
+	}
+
+	leases, err := lr.GetLeasesByLeaseholder(ctx, leaseholderID)
+	assert.NoError(t, err)
+	if assert.Len(t, leases, 2) {
+		assert.Equal(t, older.ID, leases[0].ID)
+		assert.Equal(t, newer.ID, leases[1].ID)
+	}
+}
+
+func testLandRegistryGetLeasesByCanvas(t *testing.T, lr services.LandRegistry) {
+	ctx := context.Background()
+
+	lease := newTestLease(core.NewUserID(), core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	expired := newTestLease(core.NewUserID(), core.NewArea(core.Pt(-1000, -1000), core.Pt(-990, -990)))
+	expired.CanvasID = lease.CanvasID
+	expired.Status = core.LeaseStatusExpired
+	other := newTestLease(lease.LeaseholderID, lease.Area)
+	for _, l := range []core.Lease{lease, expired, other} {
+		assert.NoError(t, lr.SaveLease(ctx, l))
+	}
+
+	leases, err := lr.GetLeasesByCanvas(ctx, lease.CanvasID)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, l := range leases {
+		ids = append(ids, l.ID)
+	}
+	assert.ElementsMatch(t, []string{lease.ID, expired.ID}, ids)
+}
+
+func testLandRegistrySaveLease(t *testing.T, lr services.LandRegistry) {
+	// Create a test lease, Postgres keeps times to the microsecond.
+	now := time.Now().UTC().Round(time.Microsecond)
 	lease := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 123,
+		LeaseholderID: "usr_123",
 		CanvasID:      456,
 		Area: core.Area{
 			Min: core.Point{X: 0, Y: 0},
@@ -38,9 +194,9 @@ func TestLandRegistry_SaveLease(t *testing.T) {
 		Price:     1000,
 		Metadata:  core.Metadata{"foo": "bar"},
 		UpdatedAt: now,
-		UpdatedBy: 789,
+		UpdatedBy: "usr_789",
 		CreatedAt: now,
-		CreatedBy: 456,
+		CreatedBy: "usr_456",
 	}
 
 	// Save the lease.
@@ -54,7 +210,7 @@ func TestLandRegistry_SaveLease(t *testing.T) {
 
 	// Update the lease.
 	lease.Status = "inactive"
-	lease.UpdatedAt = time.Now().UTC()
+	lease.UpdatedAt = time.Now().UTC().Round(time.Microsecond)
 	err = lr.SaveLease(context.Background(), lease)
 	assert.NoError(t, err)
 
@@ -73,16 +229,12 @@ func TestLandRegistry_SaveLease(t *testing.T) {
 	assert.Nil(t, retrievedDeletedLease)
 }
 
-func TestLandRegistry_GetLeasesByPoint(t *testing.T) {
-	// Create a new LandRegistry instance.
-	db := storage.NewPG()
-	lr := services.NewLandRegistry(db)
-
+func testLandRegistryGetLeasesByPoint(t *testing.T, lr services.LandRegistry) {
 	// Create test leases.
 	now := time.Now().UTC()
 	lease1 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 123,
+		LeaseholderID: "usr_123",
 		CanvasID:      0,
 		Area:          core.NewArea(core.NewPoint(0, 0), core.NewPoint(100, 100)),
 		Status:        "active",
@@ -91,13 +243,13 @@ func TestLandRegistry_GetLeasesByPoint(t *testing.T) {
 		Price:         1000,
 		Metadata:      core.Metadata{"foo": "bar"},
 		UpdatedAt:     now,
-		UpdatedBy:     789,
+		UpdatedBy:     "usr_789",
 		CreatedAt:     now,
-		CreatedBy:     456,
+		CreatedBy:     "usr_456",
 	}
 	lease2 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 456,
+		LeaseholderID: "usr_456",
 		CanvasID:      0,
 		Area:          core.NewArea(core.NewPoint(50, 50), core.NewPoint(150, 150)),
 		Status:        "active",
@@ -106,9 +258,9 @@ func TestLandRegistry_GetLeasesByPoint(t *testing.T) {
 		Price:         2000,
 		Metadata:      core.Metadata{"baz": "qux"},
 		UpdatedAt:     now,
-		UpdatedBy:     123,
+		UpdatedBy:     "usr_123",
 		CreatedAt:     now,
-		CreatedBy:     789,
+		CreatedBy:     "usr_789",
 	}
 
 	// Save the test leases.
@@ -138,16 +290,12 @@ func TestLandRegistry_GetLeasesByPoint(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestLandRegistry_GetLeasesByArea(t *testing.T) {
-	// Create a new LandRegistry instance.
-	db := storage.NewPG()
-	lr := services.NewLandRegistry(db)
-
+func testLandRegistryGetLeasesByArea(t *testing.T, lr services.LandRegistry) {
 	// Create test leases.
 	now := time.Now().UTC()
 	lease1 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 123,
+		LeaseholderID: "usr_123",
 		CanvasID:      0,
 		Area:          core.NewArea(core.NewPoint(0, 0), core.NewPoint(100, 100)),
 		Status:        "active",
@@ -156,13 +304,13 @@ func TestLandRegistry_GetLeasesByArea(t *testing.T) {
 		Price:         1000,
 		Metadata:      core.Metadata{"foo": "bar"},
 		UpdatedAt:     now,
-		UpdatedBy:     789,
+		UpdatedBy:     "usr_789",
 		CreatedAt:     now,
-		CreatedBy:     456,
+		CreatedBy:     "usr_456",
 	}
 	lease2 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 456,
+		LeaseholderID: "usr_456",
 		CanvasID:      0,
 		Area:          core.NewArea(core.NewPoint(50, 50), core.NewPoint(150, 150)),
 		Status:        "active",
@@ -171,9 +319,9 @@ func TestLandRegistry_GetLeasesByArea(t *testing.T) {
 		Price:         2000,
 		Metadata:      core.Metadata{"baz": "qux"},
 		UpdatedAt:     now,
-		UpdatedBy:     123,
+		UpdatedBy:     "usr_123",
 		CreatedAt:     now,
-		CreatedBy:     789,
+		CreatedBy:     "usr_789",
 	}
 
 	// Save the test leases.
@@ -198,16 +346,12 @@ func TestLandRegistry_GetLeasesByArea(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestLandRegistry_CanDrawPixel(t *testing.T) {
-	// Create a new LandRegistry instance.
-	db := storage.NewPG()
-	lr := services.NewLandRegistry(db)
-
+func testLandRegistryCanDrawPixel(t *testing.T, lr services.LandRegistry) {
 	// Create test leases.
 	now := time.Now().UTC()
 	lease1 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 123,
+		LeaseholderID: "usr_123",
 		CanvasID:      0,
 		Area: core.Area{
 			Min: core.Point{X: 0, Y: 0},
@@ -219,13 +363,13 @@ func TestLandRegistry_CanDrawPixel(t *testing.T) {
 		Price:     1000,
 		Metadata:  core.Metadata{"foo": "bar"},
 		UpdatedAt: now,
-		UpdatedBy: 789,
+		UpdatedBy: "usr_789",
 		CreatedAt: now,
-		CreatedBy: 456,
+		CreatedBy: "usr_456",
 	}
 	lease2 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 456,
+		LeaseholderID: "usr_456",
 		CanvasID:      0,
 		Area: core.Area{
 			Min: core.Point{X: 101, Y: 101},
@@ -237,9 +381,9 @@ func TestLandRegistry_CanDrawPixel(t *testing.T) {
 		Price:     2000,
 		Metadata:  core.Metadata{"baz": "qux"},
 		UpdatedAt: now,
-		UpdatedBy: 123,
+		UpdatedBy: "usr_123",
 		CreatedAt: now,
-		CreatedBy: 789,
+		CreatedBy: "usr_789",
 	}
 
 	// Save the test leases.
@@ -279,16 +423,12 @@ func TestLandRegistry_CanDrawPixel(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestLandRegistry_CanDrawInArea(t *testing.T) {
-	// Create a new LandRegistry instance.
-	db := storage.NewPG()
-	lr := services.NewLandRegistry(db)
-
+func testLandRegistryCanDrawInArea(t *testing.T, lr services.LandRegistry) {
 	// Create test leases.
 	now := time.Now().UTC()
 	lease1 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 123,
+		LeaseholderID: "usr_123",
 		CanvasID:      0,
 		Area:          core.NewArea(core.NewPoint(0, 0), core.NewPoint(100, 100)),
 		Status:        "active",
@@ -297,13 +437,13 @@ func TestLandRegistry_CanDrawInArea(t *testing.T) {
 		Price:         1000,
 		Metadata:      core.Metadata{"foo": "bar"},
 		UpdatedAt:     now,
-		UpdatedBy:     789,
+		UpdatedBy:     "usr_789",
 		CreatedAt:     now,
-		CreatedBy:     456,
+		CreatedBy:     "usr_456",
 	}
 	lease2 := core.Lease{
 		ID:            utils.NewLeaseID(),
-		LeaseholderID: 456,
+		LeaseholderID: "usr_456",
 		CanvasID:      0,
 		Area:          core.NewArea(core.NewPoint(200, 200), core.NewPoint(300, 300)),
 		Status:        "active",
@@ -312,9 +452,9 @@ func TestLandRegistry_CanDrawInArea(t *testing.T) {
 		Price:         2000,
 		Metadata:      core.Metadata{"baz": "qux"},
 		UpdatedAt:     now,
-		UpdatedBy:     123,
+		UpdatedBy:     "usr_123",
 		CreatedAt:     now,
-		CreatedBy:     789,
+		CreatedBy:     "usr_789",
 	}
 
 	// Save the test leases.
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends outgoing emails.
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// SMTPMailer sends emails through an SMTP server, using PLAIN auth when a username is set.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		from: from,
+		auth: auth,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, email Email) error {
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + email.To,
+		"Subject: " + email.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		email.Body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{email.To}, []byte(msg)); err != nil {
+		return fmt.Errorf("SMTPMailer.Send: %w", err)
+	}
+	return nil
+}
+
+// OutboxMailer keeps every email it is asked to send instead of sending it, and
+// also writes them as text files to a directory if one is given. It is meant for
+// local development and tests.
+type OutboxMailer struct {
+	dir  string
+	mu   sync.Mutex
+	sent []Email
+}
+
+func NewOutboxMailer(dir string) *OutboxMailer {
+	return &OutboxMailer{dir: dir}
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, email Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, email)
+
+	if m.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("OutboxMailer.Send: %w", err)
+	}
+
+	name := fmt.Sprintf("%s_%03d.txt", time.Now().UTC().Format("20060102T150405.000000000"), len(m.sent))
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", email.To, email.Subject, email.Body)
+	if err := os.WriteFile(filepath.Join(m.dir, name), []byte(content), 0o644); err != nil {
+		return fmt.Errorf("OutboxMailer.Send: %w", err)
+	}
+
+	return nil
+}
+
+// Sent returns the emails sent so far, oldest first.
+func (m *OutboxMailer) Sent() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Email{}, m.sent...)
+}
+
+// Last returns the last email sent to the address, if any.
+func (m *OutboxMailer) Last(to string) (Email, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if strings.EqualFold(m.sent[i].To, to) {
+			return m.sent[i], true
+		}
+	}
+	return Email{}, false
+}
@@ -0,0 +1,42 @@
+package services_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lazharichir/draw/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := services.NewOutboxMailer(dir)
+
+	err := mailer.Send(context.Background(), services.Email{To: "a@example.com", Subject: "first", Body: "hello"})
+	assert.NoError(t, err)
+	err = mailer.Send(context.Background(), services.Email{To: "b@example.com", Subject: "second", Body: "hello"})
+	assert.NoError(t, err)
+	err = mailer.Send(context.Background(), services.Email{To: "a@example.com", Subject: "third", Body: "hello"})
+	assert.NoError(t, err)
+
+	// every email is kept in memory
+	assert.Len(t, mailer.Sent(), 3)
+
+	last, ok := mailer.Last("A@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "third", last.Subject)
+
+	_, ok = mailer.Last("c@example.com")
+	assert.False(t, ok)
+
+	// and written to the outbox directory
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	content, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "Subject: first")
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+)
+
+var (
+	ErrExceedsProbationLimit = errors.New("too many pixels at once for a new account")
+	ErrProbationRateLimited  = errors.New("new accounts can only draw so many pixels per minute")
+	ErrInvalidStatusChange   = errors.New("invalid status change")
+)
+
+// ProbationPolicy sets the limits of users on probation, which also apply to
+// anonymous drawers, and when users get promoted out of it.
+type ProbationPolicy struct {
+	MaxPixelsPerMinute int
+	PromoteAfterPixels int64
+	PromoteAfterAge    time.Duration
+}
+
+func DefaultProbationPolicy() ProbationPolicy {
+	return ProbationPolicy{
+		MaxPixelsPerMinute: 600,
+		PromoteAfterPixels: 1000,
+		PromoteAfterAge:    72 * time.Hour,
+	}
+}
+
+// Moderation enforces user statuses: it keeps suspended and banned users from
+// writing, limits users on probation and promotes them once they have been
+// around long enough.
+type Moderation struct {
+	db      *sql.DB
+	iam     *storage.IAMStore
+	policy  ProbationPolicy
+	limiter *RateLimiter
+}
+
+func NewModeration(db *sql.DB, iam *storage.IAMStore, policy ProbationPolicy) *Moderation {
+	return &Moderation{
+		db:      db,
+		iam:     iam,
+		policy:  policy,
+		limiter: NewRateLimiter(float64(policy.MaxPixelsPerMinute)/60, policy.MaxPixelsPerMinute),
+	}
+}
+
+// CheckWrite returns an error if the user may not write that many pixels now.
+// Anonymous drawers (nil user) get the probation limits, keyed by clientKey.
+func (m *Moderation) CheckWrite(user *core.User, clientKey string, pixels int) error {
+	now := time.Now()
+
+	key := "anon:" + clientKey
+	if user != nil {
+		if user.IsRestrictedAt(now) {
+			return user.RestrictionError()
+		}
+		if !user.IsOnProbationAt(now) {
+			return nil
+		}
+		key = "user:" + user.ID.String()
+	}
+
+	if ok, wait := m.limiter.Allow(key, pixels); !ok {
+		if wait < 0 {
+			return ErrExceedsProbationLimit
+		}
+		return ErrProbationRateLimited
+	}
+
+	return nil
+}
+
+// RecordDrawing counts the pixels the user drew and promotes them out of
+// probation once they reach the policy's thresholds.
+func (m *Moderation) RecordDrawing(ctx context.Context, user *core.User, pixels int) error {
+	if user == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	total, err := m.iam.AddPixelsDrawn(ctx, m.db, user.ID, int64(pixels), now)
+	if err != nil {
+		return fmt.Errorf("RecordDrawing: %w", err)
+	}
+
+	if !user.IsOnProbationAt(now) || total < m.policy.PromoteAfterPixels || now.Sub(user.CreatedAt) < m.policy.PromoteAfterAge {
+		return nil
+	}
+
+	// user was read when the request began, the promotion must not undo a status change since
+	if _, err := m.iam.PromoteUser(ctx, m.db, user.ID, now); err != nil {
+		return fmt.Errorf("RecordDrawing: %w", err)
+	}
+
+	return nil
+}
+
+// Suspend keeps the user from writing until the given time, or indefinitely if it is nil.
+func (m *Moderation) Suspend(ctx context.Context, userID core.UserID, reason string, until *time.Time) (*core.User, error) {
+	return m.restrict(ctx, userID, core.UserStatusSuspended, reason, until)
+}
+
+// Ban keeps the user from writing until the given time, or indefinitely if it is
+// nil, and signs them out everywhere.
+func (m *Moderation) Ban(ctx context.Context, userID core.UserID, reason string, until *time.Time) (*core.User, error) {
+	return m.restrict(ctx, userID, core.UserStatusBanned, reason, until)
+}
+
+func (m *Moderation) restrict(ctx context.Context, userID core.UserID, status string, reason string, until *time.Time) (*core.User, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("%w: a reason is required", ErrInvalidStatusChange)
+	}
+	if until != nil && !until.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the end date must be in the future", ErrInvalidStatusChange)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("restrict: %w", err)
+	}
+	defer tx.Rollback()
+
+	user, err := m.iam.GetUserForUpdate(ctx, tx, userID)
+	if errors.Is(err, storage.ErrNoRows) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("restrict: %w", err)
+	}
+	if user.Status == core.UserStatusDeleted {
+		return nil, fmt.Errorf("%w: the user is deleted", ErrInvalidStatusChange)
+	}
+
+	if status == core.UserStatusBanned {
+		user.Ban(reason, until)
+		if err := m.iam.RevokeUserSessions(ctx, tx, user.ID, time.Now().UTC()); err != nil {
+			return nil, fmt.Errorf("restrict: %w", err)
+		}
+	} else {
+		user.Suspend(reason, until)
+	}
+
+	if err := m.iam.SaveUser(ctx, tx, *user); err != nil {
+		return nil, fmt.Errorf("restrict: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("restrict: %w", err)
+	}
+
+	return user, nil
+}
+
+// Reinstate lifts the user's suspension or ban. Other users, e.g. deleted ones
+// or those on probation, are left as they are.
+func (m *Moderation) Reinstate(ctx context.Context, userID core.UserID) (*core.User, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Reinstate: %w", err)
+	}
+	defer tx.Rollback()
+
+	user, err := m.iam.GetUserForUpdate(ctx, tx, userID)
+	if errors.Is(err, storage.ErrNoRows) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Reinstate: %w", err)
+	}
+	if !user.CanBeReinstated() {
+		return nil, fmt.Errorf("%w: the user is %s, not suspended or banned", ErrInvalidStatusChange, user.Status)
+	}
+
+	user.Reinstate()
+	if err := m.iam.SaveUser(ctx, tx, *user); err != nil {
+		return nil, fmt.Errorf("Reinstate: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Reinstate: %w", err)
+	}
+
+	return user, nil
+}
+
+// PruneLimits forgets idle rate limiting state, it is meant to run periodically.
+func (m *Moderation) PruneLimits() {
+	m.limiter.Prune()
+}
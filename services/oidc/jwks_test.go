@@ -0,0 +1,37 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/services/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySet_Rotation(t *testing.T) {
+	provider := oidctest.NewServer("draw", "secret")
+	defer provider.Close()
+
+	ctx := context.Background()
+	keys := newKeySet(http.DefaultClient, provider.URL+"/jwks")
+	token := func() string {
+		return provider.SignIDToken(provider.IDTokenClaims(oidctest.Identity{Subject: "a"}, "n"))
+	}
+
+	var claims Claims
+	require.NoError(t, verifyJWT(ctx, keys, token(), &claims))
+	assert.Equal(t, "a", claims.Subject)
+
+	// right after a fetch, unknown keys do not trigger another one
+	provider.RotateKey()
+	err := verifyJWT(ctx, keys, token(), &claims)
+	assert.True(t, errors.Is(err, ErrInvalidIDToken))
+
+	// later on they do, and the new key is picked up
+	keys.fetchedAt = time.Now().Add(-jwksRefreshInterval)
+	assert.NoError(t, verifyJWT(ctx, keys, token(), &claims))
+}
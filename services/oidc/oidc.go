@@ -0,0 +1,258 @@
+// Package oidc implements the parts of OpenID Connect needed to sign users in
+// with an external provider: discovery, the authorization code flow with PKCE,
+// and verification of RS256 ID tokens against the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrExchangeFailed = errors.New("authorization code exchange failed")
+)
+
+// Config identifies this app to the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes requested on top of openid, e.g. email and profile.
+	Scopes []string
+}
+
+// Metadata is the subset of the provider's discovery document we use.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client runs the authorization code flow against one provider.
+type Client struct {
+	config   Config
+	metadata Metadata
+	http     *http.Client
+	keys     *keySet
+	now      func() time.Time
+}
+
+// NewClient discovers the provider's endpoints from its issuer URL.
+func NewClient(ctx context.Context, config Config, httpClient *http.Client) (*Client, error) {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	metadata, err := Discover(ctx, httpClient, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		config:   config,
+		metadata: *metadata,
+		http:     httpClient,
+		keys:     newKeySet(httpClient, metadata.JWKSURI),
+		now:      time.Now,
+	}, nil
+}
+
+// Discover fetches the issuer's /.well-known/openid-configuration.
+func Discover(ctx context.Context, httpClient *http.Client, issuer string) (*Metadata, error) {
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Discover: %w", err)
+	}
+
+	var metadata Metadata
+	if err := doJSON(httpClient, req, &metadata); err != nil {
+		return nil, fmt.Errorf("Discover: %w", err)
+	}
+
+	// the document must be about the issuer we asked for, see OpenID Connect Discovery 4.3
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("Discover: issuer mismatch, expected %s and got %s", issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("Discover: incomplete provider metadata")
+	}
+
+	return &metadata, nil
+}
+
+// AuthCodeURL is where to send the user to sign in. The state and nonce must be
+// kept to validate the callback and the ID token, and the PKCE verifier to
+// exchange the code.
+func (c *Client) AuthCodeURL(state, nonce, verifier string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, c.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(c.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.metadata.AuthorizationEndpoint + sep + query.Encode()
+}
+
+// Tokens is the token endpoint's response.
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Exchange trades the authorization code for tokens.
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (*Tokens, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Exchange: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+
+	var tokens Tokens
+	if err := doJSON(c.http, req, &tokens); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrExchangeFailed, err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id token in the response", ErrExchangeFailed)
+	}
+
+	return &tokens, nil
+}
+
+// Claims are the ID token claims we use.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// clockSkew is how far apart our clock and the provider's may be.
+const clockSkew = time.Minute
+
+// VerifyIDToken checks the ID token's signature, issuer, audience, expiry and nonce.
+func (c *Client) VerifyIDToken(ctx context.Context, raw string, nonce string) (*Claims, error) {
+	var claims Claims
+	if err := verifyJWT(ctx, c.keys, raw, &claims); err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	switch {
+	case claims.Issuer != c.metadata.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(c.config.ClientID):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.config.ClientID:
+		return nil, fmt.Errorf("%w: not authorized for this client", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRandomString returns a url-safe random string, for states, nonces and PKCE verifiers.
+func NewRandomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PKCEChallenge is the S256 code challenge of a PKCE verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func doJSON(httpClient *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
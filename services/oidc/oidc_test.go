@@ -0,0 +1,122 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/services/oidc"
+	"github.com/lazharichir/draw/services/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:1001/auth/oidc/callback"
+
+func newClient(t *testing.T) (*oidc.Client, *oidctest.Server) {
+	provider := oidctest.NewServer("draw", "secret")
+	t.Cleanup(provider.Close)
+
+	client, err := oidc.NewClient(context.Background(), oidc.Config{
+		Issuer:       provider.Issuer(),
+		ClientID:     "draw",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "profile"},
+	}, nil)
+	require.NoError(t, err)
+
+	return client, provider
+}
+
+// signIn follows the authorization URL like a browser would and returns the callback's query.
+func signIn(t *testing.T, authURL string) url.Values {
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := noRedirect.Get(authURL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusFound, res.StatusCode)
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	return callback.Query()
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	state, nonce, verifier := oidc.NewRandomString(), oidc.NewRandomString(), oidc.NewRandomString()
+	callback := signIn(t, client.AuthCodeURL(state, nonce, verifier))
+	assert.Equal(t, state, callback.Get("state"))
+
+	tokens, err := client.Exchange(ctx, callback.Get("code"), verifier)
+	require.NoError(t, err)
+
+	claims, err := client.VerifyIDToken(ctx, tokens.IDToken, nonce)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, "user1@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	// a code can only be exchanged once
+	_, err = client.Exchange(ctx, callback.Get("code"), verifier)
+	assert.True(t, errors.Is(err, oidc.ErrExchangeFailed))
+
+	// the ID token is bound to the nonce of its sign-in
+	_, err = client.VerifyIDToken(ctx, tokens.IDToken, oidc.NewRandomString())
+	assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken))
+}
+
+func TestExchange_WrongVerifier(t *testing.T) {
+	client, _ := newClient(t)
+
+	callback := signIn(t, client.AuthCodeURL("state", "nonce", oidc.NewRandomString()))
+	_, err := client.Exchange(context.Background(), callback.Get("code"), oidc.NewRandomString())
+	assert.True(t, errors.Is(err, oidc.ErrExchangeFailed))
+}
+
+func TestVerifyIDToken_Invalid(t *testing.T) {
+	client, provider := newClient(t)
+	ctx := context.Background()
+	identity := oidctest.Identity{Subject: "user-2"}
+
+	valid := provider.IDTokenClaims(identity, "nonce")
+	_, err := client.VerifyIDToken(ctx, provider.SignIDToken(valid), "nonce")
+	assert.NoError(t, err)
+
+	cases := map[string]func(claims map[string]any){
+		"expired":        func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"other audience": func(c map[string]any) { c["aud"] = "someone-else" },
+		"other issuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"no subject":     func(c map[string]any) { c["sub"] = "" },
+		"future iat":     func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"shared aud":     func(c map[string]any) { c["aud"] = []string{"draw", "someone-else"} },
+	}
+	for name, tamper := range cases {
+		claims := provider.IDTokenClaims(identity, "nonce")
+		tamper(claims)
+		_, err := client.VerifyIDToken(ctx, provider.SignIDToken(claims), "nonce")
+		assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken), name)
+	}
+
+	// tampering with a signed token breaks its signature
+	token := provider.SignIDToken(valid)
+	_, err = client.VerifyIDToken(ctx, token[:len(token)-4]+"AAAA", "nonce")
+	assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken))
+
+	// unsigned tokens are never accepted
+	_, err = client.VerifyIDToken(ctx, "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4In0.", "nonce")
+	assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken))
+}
+
+func TestDiscover_IssuerMismatch(t *testing.T) {
+	provider := oidctest.NewServer("draw", "secret")
+	defer provider.Close()
+
+	_, err := oidc.Discover(context.Background(), http.DefaultClient, provider.Issuer()+"/other")
+	assert.Error(t, err)
+}
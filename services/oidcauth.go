@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services/oidc"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/dbtx"
+)
+
+var (
+	ErrIdentityLinkedElsewhere = errors.New("this account is already linked to another user")
+	ErrIdentityEmailRequired   = errors.New("the provider did not share a verified email address")
+)
+
+// OIDCLogin is what must be kept between sending the user to the provider and
+// their coming back, to validate the callback.
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// OIDCAuth signs users in with an external OpenID Connect provider, linking the
+// provider's identities to users.
+type OIDCAuth struct {
+	db       *sql.DB
+	iam      *storage.IAMStore
+	client   *oidc.Client
+	provider string
+}
+
+// NewOIDCAuth returns an OIDCAuth for the provider, whose name identifies it in user_identities.
+func NewOIDCAuth(db *sql.DB, iam *storage.IAMStore, client *oidc.Client, provider string) *OIDCAuth {
+	return &OIDCAuth{db: db, iam: iam, client: client, provider: provider}
+}
+
+// Begin returns where to send the user to sign in, and the login to keep until they come back.
+func (oa *OIDCAuth) Begin() (string, OIDCLogin) {
+	login := OIDCLogin{
+		State:    oidc.NewRandomString(),
+		Nonce:    oidc.NewRandomString(),
+		Verifier: oidc.NewRandomString(),
+	}
+	return oa.client.AuthCodeURL(login.State, login.Nonce, login.Verifier), login
+}
+
+// Complete exchanges the code the provider sent the user back with, and issues a
+// new session for the user linked to the provider's identity. Identities are
+// linked to the signed-in user if there is one, then to the user with the same
+// verified email, and otherwise to a new user. Deleted and banned users get
+// ErrSignInDisabled.
+func (oa *OIDCAuth) Complete(ctx context.Context, login OIDCLogin, code string, signedIn *core.User) (*core.User, *core.Session, error) {
+	tokens, err := oa.client.Exchange(ctx, code, login.Verifier)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims, err := oa.client.VerifyIDToken(ctx, tokens.IDToken, login.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := oa.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Complete: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	user, err := oa.linkedUser(ctx, tx, claims, signedIn, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identity := core.UserIdentity{
+		Provider:       oa.provider,
+		Subject:        claims.Subject,
+		UserID:         user.ID,
+		Email:          claims.Email,
+		CreatedAt:      now,
+		LastSignedInAt: now,
+	}
+	if err := oa.iam.SaveUserIdentity(ctx, tx, identity); err != nil {
+		return nil, nil, fmt.Errorf("Complete: %w", err)
+	}
+
+	user, session, err := startSession(ctx, oa.iam, tx, user.ID, now)
+	if errors.Is(err, ErrSignInDisabled) {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Complete: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("Complete: %w", err)
+	}
+
+	return user, session, nil
+}
+
+func (oa *OIDCAuth) linkedUser(ctx context.Context, tx dbtx.DBTx, claims *oidc.Claims, signedIn *core.User, now time.Time) (*core.User, error) {
+	identity, err := oa.iam.GetUserIdentity(ctx, tx, oa.provider, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("linkedUser: %w", err)
+	}
+
+	if identity != nil {
+		if signedIn != nil && signedIn.ID != identity.UserID {
+			return nil, ErrIdentityLinkedElsewhere
+		}
+		user, err := oa.iam.GetUserBy(ctx, tx, "id", identity.UserID.String())
+		if err != nil {
+			return nil, fmt.Errorf("linkedUser: %w", err)
+		}
+		return user, nil
+	}
+
+	if signedIn != nil {
+		user := *signedIn
+		return &user, nil
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrIdentityEmailRequired
+	}
+	email, err := core.NormalizeEmail(claims.Email)
+	if err != nil {
+		return nil, ErrIdentityEmailRequired
+	}
+
+	user, err := oa.iam.GetUserBy(ctx, tx, "email", email)
+	if errors.Is(err, storage.ErrNoRows) {
+		return newUser(ctx, oa.iam, tx, core.NewUserID(), email, now)
+	} else if err != nil {
+		return nil, fmt.Errorf("linkedUser: %w", err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/utils"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrCredentialsLocked  = errors.New("too many failed attempts, try again later")
+)
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     []byte
+)
+
+// spendPasswordCheck compares the password against a dummy hash so that unknown
+// users take as long to reject as known ones with a wrong password.
+func spendPasswordCheck(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = utils.HashPassword(utils.NewSessionToken())
+	})
+	utils.CheckPassword(dummyPasswordHash, password)
+}
+
+// PasswordAuth signs users in with an optional password, alongside EmailAuth's
+// magic links, and lets them reset it by email or change it.
+type PasswordAuth struct {
+	db     *sql.DB
+	iam    *storage.IAMStore
+	mailer Mailer
+	appURL string
+}
+
+// NewPasswordAuth returns a PasswordAuth whose reset emails link to appURL's /reset-password page.
+func NewPasswordAuth(db *sql.DB, iam *storage.IAMStore, mailer Mailer, appURL string) *PasswordAuth {
+	return &PasswordAuth{db: db, iam: iam, mailer: mailer, appURL: appURL}
+}
+
+// SignIn checks the user's password and issues a new session. Too many wrong
+// passwords in a row lock the credential for core.PasswordLockoutDuration.
+func (pa *PasswordAuth) SignIn(ctx context.Context, email string, password string) (*core.User, *core.Session, error) {
+	email, err := core.NormalizeEmail(email)
+	if err != nil {
+		spendPasswordCheck(password)
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	tx, err := pa.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SignIn: %w", err)
+	}
+	defer tx.Rollback()
+
+	user, err := pa.iam.GetUserBy(ctx, tx, "email", email)
+	if errors.Is(err, storage.ErrNoRows) {
+		spendPasswordCheck(password)
+		return nil, nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("SignIn: %w", err)
+	}
+
+	cred, err := pa.iam.GetPasswordCredentialForUpdate(ctx, tx, user.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SignIn: %w", err)
+	}
+	if cred == nil {
+		spendPasswordCheck(password)
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	now := time.Now().UTC()
+	if cred.IsLockedAt(now) {
+		return nil, nil, ErrCredentialsLocked
+	}
+
+	if !cred.Matches(password) {
+		cred.RecordFailure(now)
+		if err := pa.iam.SavePasswordCredential(ctx, tx, *cred); err != nil {
+			return nil, nil, fmt.Errorf("SignIn: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, nil, fmt.Errorf("SignIn: %w", err)
+		}
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	cred.RecordSuccess(now)
+	if err := pa.iam.SavePasswordCredential(ctx, tx, *cred); err != nil {
+		return nil, nil, fmt.Errorf("SignIn: %w", err)
+	}
+
+	user, session, err := startSession(ctx, pa.iam, tx, user.ID, now)
+	if errors.Is(err, ErrSignInDisabled) {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("SignIn: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("SignIn: %w", err)
+	}
+
+	return user, session, nil
+}
+
+// ChangePassword sets the user's password. If they already have one, the current
+// password is required and wrong guesses count towards the lockout.
+func (pa *PasswordAuth) ChangePassword(ctx context.Context, userID core.UserID, currentPassword string, newPassword string) error {
+	if err := core.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	tx, err := pa.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ChangePassword: %w", err)
+	}
+	defer tx.Rollback()
+
+	cred, err := pa.iam.GetPasswordCredentialForUpdate(ctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("ChangePassword: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if cred == nil {
+		cred = &core.PasswordCredential{UserID: userID}
+	} else {
+		if cred.IsLockedAt(now) {
+			return ErrCredentialsLocked
+		}
+		if !cred.Matches(currentPassword) {
+			cred.RecordFailure(now)
+			if err := pa.iam.SavePasswordCredential(ctx, tx, *cred); err != nil {
+				return fmt.Errorf("ChangePassword: %w", err)
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("ChangePassword: %w", err)
+			}
+			return ErrInvalidCredentials
+		}
+	}
+
+	if err := cred.SetPassword(newPassword); err != nil {
+		return err
+	}
+
+	if err := pa.iam.SavePasswordCredential(ctx, tx, *cred); err != nil {
+		return fmt.Errorf("ChangePassword: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ChangePassword: %w", err)
+	}
+
+	return nil
+}
+
+// RequestPasswordReset emails a reset_password token to the address if it belongs to a user.
+func (pa *PasswordAuth) RequestPasswordReset(ctx context.Context, email string) error {
+	email, err := core.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	user, err := pa.iam.GetUserBy(ctx, pa.db, "email", email)
+	if errors.Is(err, storage.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("RequestPasswordReset: %w", err)
+	}
+
+	vt, err := core.NewVerificationToken(user.ID, core.VerificationTokenKindResetPassword)
+	if err != nil {
+		return fmt.Errorf("RequestPasswordReset: %w", err)
+	}
+	vt.SetEmail(email)
+
+	if err := pa.iam.SaveVerificationToken(ctx, pa.db, vt); err != nil {
+		return fmt.Errorf("RequestPasswordReset: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", pa.appURL, url.QueryEscape(vt.Token))
+	body := fmt.Sprintf(
+		"Click the link below to choose a new password:\n\n%s\n\nIt expires in %d minutes and can only be used once. If you did not ask for it, you can ignore this email.",
+		link,
+		int(time.Until(vt.ExpiresAt).Round(time.Minute).Minutes()),
+	)
+
+	if err := pa.mailer.Send(ctx, Email{To: email, Subject: "Reset your draw password", Body: body}); err != nil {
+		return fmt.Errorf("RequestPasswordReset: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword consumes a reset_password token, sets the new password and signs
+// the user out everywhere.
+func (pa *PasswordAuth) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	if err := core.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	tx, err := pa.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ResetPassword: %w", err)
+	}
+	defer tx.Rollback()
+
+	vt, err := pa.iam.GetVerificationToken(ctx, tx, token)
+	if err != nil {
+		return fmt.Errorf("ResetPassword: %w", err)
+	}
+	if vt == nil || !vt.IsActive() || vt.Kind != core.VerificationTokenKindResetPassword {
+		return ErrInvalidVerificationToken
+	}
+
+	now := time.Now().UTC()
+	if ok, err := pa.iam.UseVerificationToken(ctx, tx, vt.Token, now); err != nil {
+		return fmt.Errorf("ResetPassword: %w", err)
+	} else if !ok {
+		return ErrInvalidVerificationToken
+	}
+
+	cred, err := pa.iam.GetPasswordCredentialForUpdate(ctx, tx, vt.UserID)
+	if err != nil {
+		return fmt.Errorf("ResetPassword: %w", err)
+	}
+	if cred == nil {
+		cred = &core.PasswordCredential{UserID: vt.UserID}
+	}
+
+	if err := cred.SetPassword(newPassword); err != nil {
+		return err
+	}
+
+	if err := pa.iam.SavePasswordCredential(ctx, tx, *cred); err != nil {
+		return fmt.Errorf("ResetPassword: %w", err)
+	}
+
+	if err := pa.iam.RevokeUserSessions(ctx, tx, vt.UserID, now); err != nil {
+		return fmt.Errorf("ResetPassword: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ResetPassword: %w", err)
+	}
+
+	return nil
+}
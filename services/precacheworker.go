@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+)
+
+var ErrPrecacheLocked = errors.New("tiles are being precached by another instance")
+
+const (
+	// precacheLockKey identifies the Postgres advisory lock held while precaching.
+	precacheLockKey int64 = 0x64726177 // "draw"
+	// precacheHighWaterMark names the high-water mark of the last precached tile changes.
+	precacheHighWaterMark = "precache"
+	// precacheLag keeps the worker that far behind now so that tile changes still
+	// being committed are picked up by the next run rather than skipped. Units of
+	// work commit within half of it, see workTimeout.
+	precacheLag = 30 * time.Second
+)
+
+// PrecacheWorker periodically re-renders every tile changed since its durable
+// high-water mark. Only one instance precaches at a time across all servers.
+type PrecacheWorker struct {
+	db          *sql.DB
+	storage     storage.PixelStore
+	renderer    *TileRenderer
+	canvases    *CanvasRegistry
+	interval    time.Duration
+	concurrency int
+	lag         time.Duration
+}
+
+func NewPrecacheWorker(
+	db *sql.DB,
+	storage storage.PixelStore,
+	renderer *TileRenderer,
+	canvases *CanvasRegistry,
+	interval time.Duration,
+	concurrency int,
+) *PrecacheWorker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &PrecacheWorker{
+		db:          db,
+		storage:     storage,
+		renderer:    renderer,
+		canvases:    canvases,
+		interval:    interval,
+		concurrency: concurrency,
+		lag:         precacheLag,
+	}
+}
+
+// Run precaches every interval until the context is done.
+func (pw *PrecacheWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := pw.RunOnce(ctx); err != nil && !errors.Is(err, ErrPrecacheLocked) {
+			fmt.Println("PrecacheWorker.RunOnce", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce precaches the tiles changed since the high-water mark and returns how many
+// were rendered. The high-water mark moves forward even if some tiles failed: they
+// are marked as changed again, to be retried by a later run without holding back
+// every other tile. Without a database, e.g. with memory stores, it takes no lock.
+func (pw *PrecacheWorker) RunOnce(ctx context.Context) (int, error) {
+	if pw.db != nil {
+		release, ok, err := storage.TryAdvisoryLock(ctx, pw.db, precacheLockKey)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, ErrPrecacheLocked
+		}
+		defer release()
+	}
+
+	from, err := pw.storage.GetHighWaterMark(ctx, precacheHighWaterMark)
+	if err != nil {
+		return 0, fmt.Errorf("PrecacheWorker: %w", err)
+	}
+	to := time.Now().UTC().Add(-pw.lag)
+	if !to.After(from) {
+		return 0, nil
+	}
+
+	// load changed areas
+	lookup, err := pw.storage.FindRecentlyChangedAreasBetweenDates(ctx, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("PrecacheWorker: %w", err)
+	}
+
+	count, failed, precacheErr := pw.precache(ctx, lookup)
+
+	// failed tiles are marked after to, so that the next runs retry them
+	for canvasID, areas := range failed {
+		for _, area := range areas {
+			if err := pw.storage.SetLastChangedForAreas(ctx, canvasID, area.Width(), area); err != nil {
+				return count, fmt.Errorf("PrecacheWorker: %w", errors.Join(precacheErr, err))
+			}
+		}
+	}
+
+	if err := pw.storage.SetHighWaterMark(ctx, precacheHighWaterMark, to); err != nil {
+		return count, fmt.Errorf("PrecacheWorker: %w", errors.Join(precacheErr, err))
+	}
+
+	if precacheErr != nil {
+		return count, fmt.Errorf("PrecacheWorker: %w", precacheErr)
+	}
+	return count, nil
+}
+
+// precache refreshes the canonical tiles of the lookup with a bounded pool of
+// goroutines, and returns how many succeeded and the ones that failed.
+func (pw *PrecacheWorker) precache(ctx context.Context, lookup map[int64][]core.Area) (int, map[int64][]core.Area, error) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []error
+		failed = map[int64][]core.Area{}
+		count  int
+	)
+
+	sem := make(chan struct{}, pw.concurrency)
+
+	for canvasID, areas := range lookup {
+		canvas := pw.canvases.Get(canvasID)
+		fmt.Println(`- Prechaching canvasID`, canvasID, `with`, len(areas), `areas`)
+
+		for _, area := range areas {
+			// only the canonical grid is ever served from the cache
+			if !canvas.IsCanonicalTile(area) {
+				continue
+			}
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(canvasID int64, area core.Area) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				_, err := pw.renderer.RefreshTile(ctx, canvasID, area)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("canvas %d %s: %w", canvasID, area.ObjectName(), err))
+					failed[canvasID] = append(failed[canvasID], area)
+					return
+				}
+				count++
+			}(canvasID, area)
+		}
+	}
+
+	wg.Wait()
+
+	return count, failed, errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingTileCacheBackend fails to store the objects whose key contains failKey.
+type failingTileCacheBackend struct {
+	*MemoryTileCacheBackend
+	failKey string
+}
+
+func (b *failingTileCacheBackend) Put(ctx context.Context, key string, data []byte, contentType string, version time.Time) error {
+	if b.failKey != "" && strings.Contains(key, b.failKey) {
+		return errors.New("put failed")
+	}
+	return b.MemoryTileCacheBackend.Put(ctx, key, data, contentType, version)
+}
+
+func TestPrecacheWorker_RunOnce(t *testing.T) {
+	ctx := context.Background()
+	pixels := storage.NewMemoryPixelStore()
+	backend := &failingTileCacheBackend{MemoryTileCacheBackend: NewMemoryTileCacheBackend()}
+	cache := NewTileCache(backend)
+	canvases := NewCanvasRegistry(core.Canvas{ID: 1, TileSides: []int64{256}, MaxTileSide: 256, TileFormats: []core.TileFormat{core.TileFormatPNG}})
+	pw := NewPrecacheWorker(nil, pixels, NewTileRenderer(pixels, cache, canvases), canvases, time.Minute, 2)
+	pw.lag = 0
+
+	good, bad := core.Pt(10, 10), core.Pt(300, 10)
+	goodArea, badArea := core.GetTileAreaFromPoint(good, 256), core.GetTileAreaFromPoint(bad, 256)
+	red := color.RGBA{R: 255, A: 255}
+	require.NoError(t, pixels.DrawPixels(ctx, 1, "alice", []core.Pixel{core.NewPixel(good.X, good.Y, red), core.NewPixel(bad.X, bad.Y, red)}))
+	require.NoError(t, pixels.SetLastChangedForPoints(ctx, 1, 256, good, bad))
+	backend.failKey = badArea.ObjectName()
+	time.Sleep(time.Millisecond)
+
+	count, err := pw.RunOnce(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, count)
+
+	img, err := cache.GetTile(ctx, 1, goodArea)
+	require.NoError(t, err)
+	assert.NotNil(t, img)
+
+	// the high-water mark moved past both tiles, the failed one is retried anyway
+	mark, err := pixels.GetHighWaterMark(ctx, precacheHighWaterMark)
+	require.NoError(t, err)
+	assert.False(t, mark.IsZero())
+
+	backend.failKey = ""
+	time.Sleep(time.Millisecond)
+
+	count, err = pw.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "only the failed tile is rendered again")
+
+	img, err = cache.GetTile(ctx, 1, badArea)
+	require.NoError(t, err)
+	assert.NotNil(t, img)
+
+	time.Sleep(time.Millisecond)
+	count, err = pw.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
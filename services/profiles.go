@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+)
+
+// MaxProfileLookup caps how many users a single Lookup resolves.
+const MaxProfileLookup = 100
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrTooManyUsersLookup = fmt.Errorf("cannot look up more than %d users at once", MaxProfileLookup)
+)
+
+// Profiles reads and updates user profiles.
+type Profiles struct {
+	db  *sql.DB
+	iam *storage.IAMStore
+}
+
+func NewProfiles(db *sql.DB, iam *storage.IAMStore) *Profiles {
+	return &Profiles{db: db, iam: iam}
+}
+
+// Get returns the user's profile, empty if they never saved one.
+func (p *Profiles) Get(ctx context.Context, userID core.UserID) (core.UserProfile, error) {
+	profiles, err := p.iam.LoadUserProfiles(ctx, p.db, userID)
+	if err != nil {
+		return core.UserProfile{}, fmt.Errorf("Profiles.Get: %w", err)
+	}
+	if profiles[userID] == nil {
+		return core.UserProfile{}, nil
+	}
+	return *profiles[userID], nil
+}
+
+// Update validates and saves the user's whole profile.
+func (p *Profiles) Update(ctx context.Context, userID core.UserID, profile core.UserProfile) (core.UserProfile, error) {
+	profile.Normalize()
+	if err := profile.Validate(); err != nil {
+		return profile, err
+	}
+
+	if err := p.iam.SaveUserProfile(ctx, p.db, userID, profile); err != nil {
+		return profile, fmt.Errorf("Profiles.Update: %w", err)
+	}
+
+	return profile, nil
+}
+
+// GetByUsername returns a user and their profile.
+func (p *Profiles) GetByUsername(ctx context.Context, username string) (*core.User, core.UserProfile, error) {
+	user, err := p.iam.GetUserBy(ctx, p.db, "username", username)
+	if errors.Is(err, storage.ErrNoRows) {
+		return nil, core.UserProfile{}, ErrUserNotFound
+	} else if err != nil {
+		return nil, core.UserProfile{}, fmt.Errorf("Profiles.GetByUsername: %w", err)
+	}
+
+	profile, err := p.Get(ctx, user.ID)
+	if err != nil {
+		return nil, core.UserProfile{}, err
+	}
+
+	return user, profile, nil
+}
+
+// Lookup resolves many users and their profiles at once, e.g. to show who drew
+// or leases what. Unknown IDs and anonymous ones are left out.
+func (p *Profiles) Lookup(ctx context.Context, ids ...core.UserID) (map[core.UserID]*core.User, map[core.UserID]*core.UserProfile, error) {
+	unique := make([]core.UserID, 0, len(ids))
+	seen := map[core.UserID]bool{}
+	for _, id := range ids {
+		if id.IsAnonymous() || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+
+	if len(unique) > MaxProfileLookup {
+		return nil, nil, ErrTooManyUsersLookup
+	}
+
+	users, err := p.iam.LoadUsers(ctx, p.db, unique...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Profiles.Lookup: %w", err)
+	}
+
+	profiles, err := p.iam.LoadUserProfiles(ctx, p.db, unique...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Profiles.Lookup: %w", err)
+	}
+
+	return users, profiles, nil
+}
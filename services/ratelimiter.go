@@ -0,0 +1,76 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is an in-memory token bucket per key: each key may spend up to
+// burst tokens at once, refilled at rate tokens per second.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow spends n tokens of the key's bucket if it has them. Otherwise it spends
+// nothing and returns how long to wait until it would, or a negative duration if
+// n is larger than the burst and will never be allowed.
+func (rl *RateLimiter) Allow(key string, n int) (bool, time.Duration) {
+	if float64(n) > rl.burst {
+		return false, -1
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, updated: now}
+		rl.buckets[key] = bucket
+	}
+
+	bucket.tokens = min(rl.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*rl.rate)
+	bucket.updated = now
+
+	if bucket.tokens >= float64(n) {
+		bucket.tokens -= float64(n)
+		return true, 0
+	}
+
+	missing := float64(n) - bucket.tokens
+	return false, time.Duration(missing / rl.rate * float64(time.Second))
+}
+
+// Prune forgets the buckets that have refilled, to keep memory bounded, and
+// returns how many are left.
+func (rl *RateLimiter) Prune() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	for key, bucket := range rl.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+	return len(rl.buckets)
+}
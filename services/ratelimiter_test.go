@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter(1, 10)
+	rl.now = func() time.Time { return now }
+
+	ok, _ := rl.Allow("a", 8)
+	assert.True(t, ok)
+
+	ok, wait := rl.Allow("a", 5)
+	assert.False(t, ok)
+	assert.Equal(t, 3*time.Second, wait)
+
+	// other keys have their own bucket
+	ok, _ = rl.Allow("b", 10)
+	assert.True(t, ok)
+
+	now = now.Add(3 * time.Second)
+	ok, _ = rl.Allow("a", 5)
+	assert.True(t, ok)
+
+	ok, wait = rl.Allow("a", 11)
+	assert.False(t, ok)
+	assert.Negative(t, wait)
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 0, rl.Prune())
+}
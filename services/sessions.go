@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/dbtx"
+)
+
+var (
+	ErrInvalidSession = errors.New("invalid, expired or revoked session")
+	ErrSignInDisabled = errors.New("this account cannot sign in")
+)
+
+// Sessions resolves and revokes the server-side sessions issued at sign-in.
+type Sessions struct {
+	db  *sql.DB
+	iam *storage.IAMStore
+}
+
+func NewSessions(db *sql.DB, iam *storage.IAMStore) *Sessions {
+	return &Sessions{db: db, iam: iam}
+}
+
+// Authenticate returns the user and session of an active session token.
+func (s *Sessions) Authenticate(ctx context.Context, token string) (*core.User, *core.Session, error) {
+	session, err := s.iam.GetSession(ctx, s.db, token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Authenticate: %w", err)
+	}
+	if session == nil || !session.IsActive() {
+		return nil, nil, ErrInvalidSession
+	}
+
+	user, err := s.iam.GetUserBy(ctx, s.db, "id", session.UserID.String())
+	if errors.Is(err, storage.ErrNoRows) {
+		return nil, nil, ErrInvalidSession
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Authenticate: %w", err)
+	}
+
+	return user, session, nil
+}
+
+// SignOut revokes a single session.
+func (s *Sessions) SignOut(ctx context.Context, session core.Session) error {
+	session.Revoke()
+	if err := s.iam.SaveSession(ctx, s.db, session); err != nil {
+		return fmt.Errorf("SignOut: %w", err)
+	}
+	return nil
+}
+
+// SignOutEverywhere revokes every session of the user.
+func (s *Sessions) SignOutEverywhere(ctx context.Context, userID core.UserID) error {
+	if err := s.iam.RevokeUserSessions(ctx, s.db, userID, time.Now().UTC()); err != nil {
+		return fmt.Errorf("SignOutEverywhere: %w", err)
+	}
+	return nil
+}
+
+// startSession signs the user in within tx: it locks their row, checks that they
+// may sign in and saves a new session for them. The lock orders the sign-in
+// with a ban, which either commits first and refuses it, or waits for it and
+// revokes the new session.
+func startSession(ctx context.Context, iam *storage.IAMStore, tx dbtx.DBTx, userID core.UserID, now time.Time) (*core.User, *core.Session, error) {
+	user, err := iam.GetUserForUpdate(ctx, tx, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("startSession: %w", err)
+	}
+	if !user.CanSignInAt(now) {
+		return nil, nil, ErrSignInDisabled
+	}
+
+	if err := iam.SetLastSignedIn(ctx, tx, user.ID, now); err != nil {
+		return nil, nil, fmt.Errorf("startSession: %w", err)
+	}
+	user.LastSignedInAt = now
+
+	session := core.NewSession(user.ID)
+	if err := iam.SaveSession(ctx, tx, session); err != nil {
+		return nil, nil, fmt.Errorf("startSession: %w", err)
+	}
+
+	return user, &session, nil
+}
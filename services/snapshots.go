@@ -0,0 +1,536 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/utils"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// snapshotFormat is the version of the archive layout, bumped on changes older binaries could not restore.
+	snapshotFormat = 1
+	// snapshotBatch is how many pixels Restore draws at once.
+	snapshotBatch = 5000
+)
+
+var (
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+	ErrCanvasNotEmpty  = errors.New("the canvas already has pixels or leases")
+)
+
+// snapshotFiles are the files of an archive besides its manifest.
+var snapshotFiles = []string{"canvas.json", "leases.json", "pixels.csv"}
+
+var snapshotPixelsHeader = []string{"x", "y", "r", "g", "b", "a", "drawn_at", "drawn_by"}
+
+// SnapshotManifest describes a snapshot, it is the manifest.json of its archive.
+type SnapshotManifest struct {
+	Format    int                     `json:"format"`
+	CanvasID  int64                   `json:"canvas_id"`
+	CreatedAt time.Time               `json:"created_at"`
+	Pixels    int64                   `json:"pixels"`
+	Leases    int                     `json:"leases"`
+	Files     map[string]SnapshotFile `json:"files"`
+}
+
+// SnapshotFile is a file of the archive, checked before anything is restored from it.
+type SnapshotFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// snapshotCanvas is the canvas.json of an archive.
+type snapshotCanvas struct {
+	TileSides   []int64           `json:"tile_sides"`
+	MaxTileSide int64             `json:"max_tile_side"`
+	TileFormats []core.TileFormat `json:"tile_formats"`
+}
+
+// Snapshots backs a canvas up into a single archive, and restores it into a new
+// or emptied canvas, e.g. to move it between environments. An archive is a zip of:
+//
+//	manifest.json  the format, canvas, counts and checksums of the other files
+//	canvas.json    the canvas's settings
+//	leases.json    the leases on the canvas, whatever their status
+//	pixels.csv     every pixel of the canvas, with when and by whom it was drawn
+type Snapshots struct {
+	unitOfWork *UnitOfWork
+	tileCache  *TileCache
+	canvases   *CanvasRegistry
+}
+
+func NewSnapshots(unitOfWork *UnitOfWork, tileCache *TileCache, canvases *CanvasRegistry) *Snapshots {
+	return &Snapshots{unitOfWork: unitOfWork, tileCache: tileCache, canvases: canvases}
+}
+
+// Create writes a snapshot of the canvas to w. Its settings, pixels and leases
+// are read in a single transaction, so that they are consistent with each other.
+// Streaming the pixels lifts the statement timeout for it, see ForEachPixel.
+func (s *Snapshots) Create(ctx context.Context, canvasID int64, w io.Writer) (*SnapshotManifest, error) {
+	manifest := &SnapshotManifest{
+		Format:    snapshotFormat,
+		CanvasID:  canvasID,
+		CreatedAt: time.Now().UTC(),
+		Files:     map[string]SnapshotFile{},
+	}
+
+	archive := zip.NewWriter(w)
+	err := s.unitOfWork.View(ctx, func(ctx context.Context, work Work) error {
+		// canvases without saved settings use the defaults
+		canvas := s.canvases.Get(canvasID)
+		saved, err := work.Canvases.GetCanvas(ctx, canvasID)
+		if err != nil {
+			return err
+		} else if saved != nil {
+			canvas = *saved
+		}
+
+		leases, err := work.LandRegistry.GetLeasesByCanvas(ctx, canvasID)
+		if err != nil {
+			return err
+		}
+		manifest.Leases = len(leases)
+
+		settings := snapshotCanvas{TileSides: canvas.TileSides, MaxTileSide: canvas.MaxTileSide, TileFormats: canvas.TileFormats}
+		if err := writeSnapshotFile(archive, manifest, "canvas.json", func(w io.Writer) error {
+			return writeIndentedJSON(w, settings)
+		}); err != nil {
+			return err
+		}
+		if err := writeSnapshotFile(archive, manifest, "leases.json", func(w io.Writer) error {
+			return writeIndentedJSON(w, leases)
+		}); err != nil {
+			return err
+		}
+		return writeSnapshotFile(archive, manifest, "pixels.csv", func(w io.Writer) error {
+			out := csv.NewWriter(w)
+			out.Write(snapshotPixelsHeader)
+			err := work.Pixels.ForEachPixel(ctx, canvasID, func(pixel storage.DrawnPixel) error {
+				manifest.Pixels++
+				return out.Write([]string{
+					strconv.FormatInt(pixel.X, 10),
+					strconv.FormatInt(pixel.Y, 10),
+					strconv.Itoa(int(pixel.RGBA.R)),
+					strconv.Itoa(int(pixel.RGBA.G)),
+					strconv.Itoa(int(pixel.RGBA.B)),
+					strconv.Itoa(int(pixel.RGBA.A)),
+					pixel.DrawnAt.Format(time.RFC3339Nano),
+					pixel.DrawnBy.String(),
+				})
+			})
+			if err != nil {
+				return err
+			}
+			out.Flush()
+			return out.Error()
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+
+	if err := writeJSONFile(archive, "manifest.json", manifest); err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("Create: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// writeSnapshotFile adds a file to the archive and records its size and checksum in the manifest.
+func writeSnapshotFile(archive *zip.Writer, manifest *SnapshotManifest, name string, write func(w io.Writer) error) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	size := new(byteCounter)
+	if err := write(io.MultiWriter(f, hash, size)); err != nil {
+		return err
+	}
+
+	manifest.Files[name] = SnapshotFile{Size: int64(*size), SHA256: hex.EncodeToString(hash.Sum(nil))}
+	return nil
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// Restore restores the snapshot of size bytes read from r into the canvas, in a
+// single transaction. The canvas must have neither pixels nor leases, unless
+// replace is set, in which case they are deleted first. Leases keep their IDs
+// when restored into the canvas they were snapshotted from, and get new ones
+// otherwise, since the original ones may still exist.
+//
+// Snapshots may come from another environment, or predate account deletions:
+// pixels whose drawer is unknown or deleted are restored as drawn anonymously,
+// and the leases of such leaseholders are restored terminated.
+//
+// The canvas's settings are saved along with its pixels and leases. The tiles
+// the restore changes are deleted from the tile cache once it is committed,
+// restored pixels keep the time they were drawn at, which refreshing a cached
+// tile would take as already applied.
+func (s *Snapshots) Restore(ctx context.Context, canvasID int64, r io.ReaderAt, size int64, replace bool) (*SnapshotManifest, error) {
+	archive, manifest, settings, leases, err := readSnapshot(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("Restore: %w", err)
+	}
+
+	canvas := core.Canvas{ID: canvasID, TileSides: settings.TileSides, MaxTileSide: settings.MaxTileSide, TileFormats: settings.TileFormats}
+	var changed tileAreas
+	// restores take as long as their archive does, they evict the tiles they
+	// change rather than rely on the precache worker and tile refreshes
+	err = s.unitOfWork.DoWithin(ctx, 0, func(ctx context.Context, work Work) error {
+		changed = newTileAreas(s.canvases.Get(canvasID), canvas)
+
+		if err := emptyCanvas(ctx, work, canvasID, replace, changed); err != nil {
+			return err
+		}
+
+		pixels, err := restorePixels(ctx, work, archive, canvasID, changed)
+		if err != nil {
+			return err
+		}
+		if pixels != manifest.Pixels {
+			return fmt.Errorf("%w: pixels.csv has %d pixels, the manifest says %d", ErrInvalidSnapshot, pixels, manifest.Pixels)
+		}
+
+		leaseholders := make([]core.UserID, len(leases))
+		for i, lease := range leases {
+			leaseholders[i] = lease.LeaseholderID
+		}
+		active, err := activeUsers(ctx, work.Users, leaseholders)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, lease := range leases {
+			lease.CanvasID = canvasID
+			if canvasID != manifest.CanvasID {
+				lease.ID = utils.NewLeaseID()
+			}
+			if !active[lease.LeaseholderID] && lease.Status != core.LeaseStatusExpired && lease.Status != core.LeaseStatusTerminated {
+				lease.Terminate(now, "")
+			}
+			if err := work.LandRegistry.SaveLease(ctx, lease); err != nil {
+				return err
+			}
+		}
+
+		if err := work.Canvases.SaveCanvas(ctx, canvas); err != nil {
+			return err
+		}
+		return changed.mark(ctx, work.Pixels, canvasID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Restore: %w", err)
+	}
+
+	// other servers pick the settings up from the store when they next load it
+	s.canvases.Put(canvas)
+
+	if err := changed.evict(ctx, s.tileCache, canvasID); err != nil {
+		return manifest, fmt.Errorf("Restore: the canvas is restored but some of its cached tiles are stale: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// readSnapshot opens the archive and checks its files against the manifest,
+// before reading the canvas's settings and leases.
+func readSnapshot(r io.ReaderAt, size int64) (*zip.Reader, *SnapshotManifest, snapshotCanvas, []core.Lease, error) {
+	var settings snapshotCanvas
+	var leases []core.Lease
+
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, settings, nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+
+	manifest := &SnapshotManifest{}
+	if err := readSnapshotJSON(archive, "manifest.json", manifest); err != nil {
+		return nil, nil, settings, nil, err
+	}
+	if manifest.Format != snapshotFormat {
+		return nil, nil, settings, nil, fmt.Errorf("%w: format %d, this server reads %d", ErrInvalidSnapshot, manifest.Format, snapshotFormat)
+	}
+
+	for _, name := range snapshotFiles {
+		want, ok := manifest.Files[name]
+		if !ok {
+			return nil, nil, settings, nil, fmt.Errorf("%w: %s is not in the manifest", ErrInvalidSnapshot, name)
+		}
+		if err := checkSnapshotFile(archive, name, want); err != nil {
+			return nil, nil, settings, nil, err
+		}
+	}
+
+	if err := readSnapshotJSON(archive, "canvas.json", &settings); err != nil {
+		return nil, nil, settings, nil, err
+	}
+	if len(settings.TileSides) == 0 || settings.MaxTileSide <= 0 || len(settings.TileFormats) == 0 {
+		return nil, nil, settings, nil, fmt.Errorf("%w: canvas.json has no tile sides or formats", ErrInvalidSnapshot)
+	}
+
+	if err := readSnapshotJSON(archive, "leases.json", &leases); err != nil {
+		return nil, nil, settings, nil, err
+	}
+	if len(leases) != manifest.Leases {
+		return nil, nil, settings, nil, fmt.Errorf("%w: leases.json has %d leases, the manifest says %d", ErrInvalidSnapshot, len(leases), manifest.Leases)
+	}
+
+	return archive, manifest, settings, leases, nil
+}
+
+func checkSnapshotFile(archive *zip.Reader, name string, want SnapshotFile) error {
+	f, err := archive.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidSnapshot, name, err)
+	}
+	if size != want.Size || hex.EncodeToString(hash.Sum(nil)) != want.SHA256 {
+		return fmt.Errorf("%w: %s does not match its checksum", ErrInvalidSnapshot, name)
+	}
+	return nil
+}
+
+func readSnapshotJSON(archive *zip.Reader, name string, v any) error {
+	f, err := archive.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidSnapshot, name, err)
+	}
+	return nil
+}
+
+// emptyCanvas checks the canvas has neither pixels nor leases, or deletes them
+// if replace is set, adding the tiles of the deleted pixels to changed.
+func emptyCanvas(ctx context.Context, work Work, canvasID int64, replace bool, changed tileAreas) error {
+	leases, err := work.LandRegistry.GetLeasesByCanvas(ctx, canvasID)
+	if err != nil {
+		return err
+	}
+
+	hasPixels := false
+	err = work.Pixels.ForEachPixel(ctx, canvasID, func(pixel storage.DrawnPixel) error {
+		hasPixels = true
+		if !replace {
+			return ErrCanvasNotEmpty
+		}
+		changed.add(pixel.Point)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(leases) > 0 && !replace {
+		return ErrCanvasNotEmpty
+	}
+	for _, lease := range leases {
+		if err := work.LandRegistry.DeleteLease(ctx, lease.ID); err != nil {
+			return err
+		}
+	}
+	if hasPixels {
+		return work.Pixels.DeletePixels(ctx, canvasID)
+	}
+	return nil
+}
+
+// restorePixels restores the pixels of the archive's pixels.csv and returns how
+// many there were, anonymizing those of drawers that are not active users.
+func restorePixels(ctx context.Context, work Work, archive *zip.Reader, canvasID int64, changed tileAreas) (int64, error) {
+	f, err := archive.Open("pixels.csv")
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	defer f.Close()
+
+	in := csv.NewReader(f)
+	in.FieldsPerRecord = len(snapshotPixelsHeader)
+	in.ReuseRecord = true
+
+	header, err := in.Read()
+	if err != nil || !slices.Equal(header, snapshotPixelsHeader) {
+		return 0, fmt.Errorf("%w: pixels.csv does not start with its header", ErrInvalidSnapshot)
+	}
+
+	count := int64(0)
+	batch := make([]storage.DrawnPixel, 0, snapshotBatch)
+	drawers := map[core.UserID]bool{"": true}
+	restore := func() error {
+		var unknown []core.UserID
+		for _, pixel := range batch {
+			if _, ok := drawers[pixel.DrawnBy]; !ok && !slices.Contains(unknown, pixel.DrawnBy) {
+				unknown = append(unknown, pixel.DrawnBy)
+			}
+		}
+		active, err := activeUsers(ctx, work.Users, unknown)
+		if err != nil {
+			return err
+		}
+		for _, id := range unknown {
+			drawers[id] = active[id]
+		}
+
+		for i := range batch {
+			if !drawers[batch[i].DrawnBy] {
+				batch[i].DrawnBy = ""
+			}
+		}
+		return work.Pixels.RestorePixels(ctx, canvasID, batch)
+	}
+	for {
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("%w: pixels.csv: %w", ErrInvalidSnapshot, err)
+		}
+
+		pixel, err := parseSnapshotPixel(record)
+		if err != nil {
+			line, _ := in.FieldPos(0)
+			return count, fmt.Errorf("%w: pixels.csv line %d: %w", ErrInvalidSnapshot, line, err)
+		}
+		changed.add(pixel.Point)
+		batch = append(batch, pixel)
+		count++
+
+		if len(batch) == snapshotBatch {
+			if err := restore(); err != nil {
+				return count, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	return count, restore()
+}
+
+// activeUsers reports which of the users exist and are not deleted.
+func activeUsers(ctx context.Context, users Users, ids []core.UserID) (map[core.UserID]bool, error) {
+	loaded, err := users.LoadUsers(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	active := map[core.UserID]bool{}
+	for id, user := range loaded {
+		active[id] = user.Status != core.UserStatusDeleted
+	}
+	return active, nil
+}
+
+func parseSnapshotPixel(record []string) (storage.DrawnPixel, error) {
+	var pixel storage.DrawnPixel
+	var err error
+
+	if pixel.X, err = strconv.ParseInt(record[0], 10, 64); err != nil {
+		return pixel, err
+	}
+	if pixel.Y, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return pixel, err
+	}
+	channels := []*uint8{&pixel.RGBA.R, &pixel.RGBA.G, &pixel.RGBA.B, &pixel.RGBA.A}
+	for i, channel := range channels {
+		value, err := strconv.ParseUint(record[2+i], 10, 8)
+		if err != nil {
+			return pixel, err
+		}
+		*channel = uint8(value)
+	}
+	if pixel.DrawnAt, err = time.Parse(time.RFC3339Nano, record[6]); err != nil {
+		return pixel, err
+	}
+	pixel.DrawnBy = core.UserID(record[7])
+
+	return pixel, nil
+}
+
+// tileAreas collects, for every tile side, the canonical tiles a restore changes.
+type tileAreas map[int64]map[core.Area]bool
+
+func newTileAreas(canvases ...core.Canvas) tileAreas {
+	areas := tileAreas{}
+	for _, canvas := range canvases {
+		for _, side := range canvas.TileSides {
+			if canvas.IsTileSide(side) {
+				areas[side] = map[core.Area]bool{}
+			}
+		}
+	}
+	return areas
+}
+
+func (ta tileAreas) add(point core.Point) {
+	for side, areas := range ta {
+		areas[core.GetTileAreaFromPoint(point, side)] = true
+	}
+}
+
+// mark flags the tiles as changed, so that the precache worker renders them again.
+func (ta tileAreas) mark(ctx context.Context, store storage.PixelStore, canvasID int64) error {
+	for side, areas := range ta {
+		batch := make([]core.Area, 0, 1000)
+		for area := range areas {
+			batch = append(batch, area)
+			if len(batch) == cap(batch) {
+				if err := store.SetLastChangedForAreas(ctx, canvasID, side, batch...); err != nil {
+					return err
+				}
+				batch = batch[:0]
+			}
+		}
+		if len(batch) > 0 {
+			if err := store.SetLastChangedForAreas(ctx, canvasID, side, batch...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evict deletes the tiles from the cache.
+func (ta tileAreas) evict(ctx context.Context, cache *TileCache, canvasID int64) error {
+	for _, areas := range ta {
+		for area := range areas {
+			if err := cache.DeleteTile(ctx, canvasID, core.NewTile(area)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image/color"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type snapshotsFixture struct {
+	pixels       storage.PixelStore
+	landRegistry services.LandRegistry
+	canvasStore  storage.CanvasStore
+	tileCache    *services.TileCache
+	canvases     *services.CanvasRegistry
+	snapshots    *services.Snapshots
+}
+
+func newSnapshotsFixture() snapshotsFixture {
+	f := snapshotsFixture{
+		pixels:       storage.NewMemoryPixelStore(),
+		landRegistry: services.NewMemoryLandRegistry(),
+		canvasStore:  storage.NewMemoryCanvasStore(),
+		tileCache:    services.NewTileCache(services.NewMemoryTileCacheBackend()),
+		canvases:     services.NewCanvasRegistry(),
+	}
+	users := services.NewMemoryUsers(
+		core.User{ID: "alice", Status: core.UserStatusActive},
+		core.User{ID: "carol", Status: core.UserStatusDeleted},
+	)
+	f.snapshots = services.NewSnapshots(services.NewUnitOfWork(nil, f.pixels, f.landRegistry, f.canvasStore, users), f.tileCache, f.canvases)
+	return f
+}
+
+func (f snapshotsFixture) create(t *testing.T, canvasID int64) []byte {
+	buf := &bytes.Buffer{}
+	_, err := f.snapshots.Create(context.Background(), canvasID, buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func (f snapshotsFixture) restore(canvasID int64, archive []byte, replace bool) (*services.SnapshotManifest, error) {
+	return f.snapshots.Restore(context.Background(), canvasID, bytes.NewReader(archive), int64(len(archive)), replace)
+}
+
+func (f snapshotsFixture) draw(t *testing.T, canvasID int64) []storage.DrawnPixel {
+	ctx := context.Background()
+	drawnAt := time.Date(2023, 10, 5, 16, 14, 0, 123456000, time.UTC)
+	pixels := []storage.DrawnPixel{
+		{Pixel: core.NewPixel(1, 2, color.RGBA{R: 255, A: 255}), DrawnAt: drawnAt, DrawnBy: "alice"},
+		{Pixel: core.NewPixel(-300, 700, color.RGBA{G: 128, A: 255}), DrawnAt: drawnAt.Add(time.Hour), DrawnBy: ""},
+	}
+	require.NoError(t, f.pixels.RestorePixels(ctx, canvasID, pixels))
+	require.NoError(t, f.landRegistry.SaveLease(ctx, core.Lease{
+		ID:            "lease-1",
+		LeaseholderID: "alice",
+		CanvasID:      canvasID,
+		Area:          core.NewArea(core.Pt(0, 0), core.Pt(9, 9)),
+		Status:        core.LeaseStatusActive,
+		Start:         drawnAt,
+		End:           drawnAt.Add(24 * time.Hour),
+		CreatedAt:     drawnAt,
+		UpdatedAt:     drawnAt,
+	}))
+	return pixels
+}
+
+func canvasPixels(t *testing.T, store storage.PixelStore, canvasID int64) []storage.DrawnPixel {
+	var pixels []storage.DrawnPixel
+	require.NoError(t, store.ForEachPixel(context.Background(), canvasID, func(pixel storage.DrawnPixel) error {
+		pixels = append(pixels, pixel)
+		return nil
+	}))
+	return pixels
+}
+
+func TestSnapshots_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	f := newSnapshotsFixture()
+	drawn := f.draw(t, 1)
+	f.canvases.Put(core.Canvas{ID: 1, TileSides: []int64{128}, MaxTileSide: 128, TileFormats: []core.TileFormat{core.TileFormatPNG}})
+
+	archive := f.create(t, 1)
+
+	manifest, err := f.restore(2, archive, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), manifest.CanvasID)
+	assert.Equal(t, int64(2), manifest.Pixels)
+	assert.Equal(t, 1, manifest.Leases)
+
+	assert.ElementsMatch(t, drawn, canvasPixels(t, f.pixels, 2))
+	assert.Len(t, canvasPixels(t, f.pixels, 1), 2, "the snapshotted canvas is untouched")
+
+	leases, err := f.landRegistry.GetLeasesByCanvas(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	assert.NotEqual(t, "lease-1", leases[0].ID, "leases restored into another canvas get new ids")
+	assert.Equal(t, core.UserID("alice"), leases[0].LeaseholderID)
+
+	assert.Equal(t, []int64{128}, f.canvases.Get(2).TileSides)
+
+	// the settings are saved, for other servers and restarts
+	restarted := services.NewCanvasRegistry()
+	require.NoError(t, restarted.Load(ctx, f.canvasStore))
+	assert.Equal(t, []int64{128}, restarted.Get(2).TileSides)
+	assert.Equal(t, int64(128), restarted.Get(2).MaxTileSide)
+
+	changed, err := f.pixels.FindRecentlyChangedAreasBetweenDates(ctx, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Contains(t, changed[2], core.GetTileAreaFromPoint(core.Pt(1, 2), 128))
+}
+
+func TestSnapshots_CreateReadsSavedSettings(t *testing.T) {
+	ctx := context.Background()
+	f := newSnapshotsFixture()
+	f.draw(t, 1)
+
+	// another server saved the settings this one has not loaded yet
+	f.canvases.Put(core.Canvas{ID: 1, TileSides: []int64{128}, MaxTileSide: 128, TileFormats: []core.TileFormat{core.TileFormatPNG}})
+	require.NoError(t, f.canvasStore.SaveCanvas(ctx, core.Canvas{ID: 1, TileSides: []int64{64}, MaxTileSide: 64, TileFormats: []core.TileFormat{core.TileFormatPNG}}))
+
+	_, err := f.restore(2, f.create(t, 1), false)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{64}, f.canvases.Get(2).TileSides)
+}
+
+func TestSnapshots_RestoreInactiveUsers(t *testing.T) {
+	ctx := context.Background()
+	f := newSnapshotsFixture()
+	drawnAt := time.Date(2023, 10, 5, 16, 14, 0, 0, time.UTC)
+	require.NoError(t, f.pixels.RestorePixels(ctx, 1, []storage.DrawnPixel{
+		{Pixel: core.NewPixel(1, 1, color.RGBA{R: 255, A: 255}), DrawnAt: drawnAt, DrawnBy: "alice"},
+		{Pixel: core.NewPixel(2, 2, color.RGBA{R: 255, A: 255}), DrawnAt: drawnAt, DrawnBy: "carol"},
+		{Pixel: core.NewPixel(3, 3, color.RGBA{R: 255, A: 255}), DrawnAt: drawnAt, DrawnBy: "dave"},
+	}))
+	for _, lease := range []core.Lease{
+		{ID: "lease-alice", LeaseholderID: "alice", Status: core.LeaseStatusActive},
+		{ID: "lease-carol", LeaseholderID: "carol", Status: core.LeaseStatusActive},
+		{ID: "lease-dave", LeaseholderID: "dave", Status: core.LeaseStatusExpired},
+	} {
+		lease.CanvasID = 1
+		lease.Area = core.NewArea(core.Pt(0, 0), core.Pt(9, 9))
+		lease.Start = drawnAt
+		lease.End = time.Now().Add(24 * time.Hour).UTC()
+		require.NoError(t, f.landRegistry.SaveLease(ctx, lease))
+	}
+
+	_, err := f.restore(2, f.create(t, 1), false)
+	require.NoError(t, err)
+
+	drawers := map[int64]core.UserID{}
+	for _, pixel := range canvasPixels(t, f.pixels, 2) {
+		drawers[pixel.X] = pixel.DrawnBy
+	}
+	assert.Equal(t, map[int64]core.UserID{1: "alice", 2: "", 3: ""}, drawers, "deleted and unknown drawers are anonymized")
+
+	leases, err := f.landRegistry.GetLeasesByCanvas(ctx, 2)
+	require.NoError(t, err)
+	statuses := map[core.UserID]core.LeaseStatus{}
+	for _, lease := range leases {
+		statuses[lease.LeaseholderID] = lease.Status
+	}
+	assert.Equal(t, map[core.UserID]core.LeaseStatus{
+		"alice": core.LeaseStatusActive,
+		"carol": core.LeaseStatusTerminated,
+		"dave":  core.LeaseStatusExpired,
+	}, statuses)
+}
+
+func TestSnapshots_RestoreIntoNonEmptyCanvas(t *testing.T) {
+	f := newSnapshotsFixture()
+	f.draw(t, 1)
+	archive := f.create(t, 1)
+
+	_, err := f.restore(1, archive, false)
+	assert.ErrorIs(t, err, services.ErrCanvasNotEmpty)
+
+	manifest, err := f.restore(1, archive, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), manifest.Pixels)
+	assert.Len(t, canvasPixels(t, f.pixels, 1), 2)
+
+	leases, err := f.landRegistry.GetLeasesByCanvas(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	assert.Equal(t, "lease-1", leases[0].ID, "leases restored into their canvas keep their ids")
+}
+
+func TestSnapshots_ReplaceEvictsCachedTiles(t *testing.T) {
+	ctx := context.Background()
+	f := newSnapshotsFixture()
+	archive := f.create(t, 1)
+
+	// The canvas gets a pixel and a cached tile after the snapshot.
+	require.NoError(t, f.pixels.DrawPixels(ctx, 1, "bob", []core.Pixel{core.NewPixel(5000, 5000, color.RGBA{B: 255, A: 255})}))
+	tile := core.NewTile(core.GetTileAreaFromPoint(core.Pt(5000, 5000), 256))
+	require.NoError(t, f.tileCache.PutTile(ctx, 1, tile, tile.AsImage(), time.Now()))
+
+	_, err := f.restore(1, archive, true)
+	require.NoError(t, err)
+	assert.Empty(t, canvasPixels(t, f.pixels, 1))
+
+	img, err := f.tileCache.GetTile(ctx, 1, tile.Area)
+	assert.NoError(t, err)
+	assert.Nil(t, img)
+}
+
+func TestSnapshots_RestoreTampered(t *testing.T) {
+	f := newSnapshotsFixture()
+	f.draw(t, 1)
+	archive := f.create(t, 1)
+
+	// Rewrite the archive with a pixel changed but the manifest as is.
+	in, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+	tampered := &bytes.Buffer{}
+	out := zip.NewWriter(tampered)
+	for _, file := range in.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		if file.Name == "pixels.csv" {
+			data = bytes.Replace(data, []byte("1,2,255"), []byte("1,2,254"), 1)
+		}
+		w, err := out.Create(file.Name)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, out.Close())
+
+	_, err = f.restore(2, tampered.Bytes(), false)
+	assert.ErrorIs(t, err, services.ErrInvalidSnapshot)
+	assert.Empty(t, canvasPixels(t, f.pixels, 2))
+
+	_, err = f.restore(2, archive[:len(archive)/2], false)
+	assert.ErrorIs(t, err, services.ErrInvalidSnapshot)
+}
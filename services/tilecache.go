@@ -6,93 +6,89 @@ import (
 	"errors"
 	"fmt"
 	"image"
+	"image/png"
+	"time"
 
-	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/lazharichir/draw/core"
-	"github.com/lazharichir/draw/utils"
 )
 
 // Create a function that generates updated cached tiles
 
+// TileCacheBackend stores the objects of the tile cache by key.
+type TileCacheBackend interface {
+	Put(ctx context.Context, key string, data []byte, contentType string, version time.Time) error
+	// Get returns the object and its version, or nil data if there is no object at key.
+	Get(ctx context.Context, key string) ([]byte, time.Time, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// TileCache stores encoded tiles, one object per canvas, tile and format.
+// The png object is the lossless reference used to refresh tiles incrementally.
 type TileCache struct {
-	bucketName string
-	s3         *awss3.Client
+	backend TileCacheBackend
 }
 
-func NewTileCache(s3 *awss3.Client, bucketName string) *TileCache {
-	return &TileCache{s3: s3, bucketName: bucketName}
+func NewTileCache(backend TileCacheBackend) *TileCache {
+	return &TileCache{backend: backend}
 }
 
-func (cache *TileCache) PutTile(ctx context.Context, canvasID int64, tile core.Tile, img image.Image) error {
-	x := tile.GetMinX()
-	y := tile.GetMinY()
-	side := tile.Width
-	_, _, _ = x, y, side
+func tileObjectKey(canvasID int64, area core.Area, format core.TileFormat) string {
+	return fmt.Sprintf("%d/%s", canvasID, area.ObjectNameWithExt(format.Ext()))
+}
 
+// PutTile stores the tile image as a png along with its version, i.e., the time it was rendered at.
+func (cache *TileCache) PutTile(ctx context.Context, canvasID int64, tile core.Tile, img image.Image, version time.Time) error {
 	if !tile.IsSquare() {
 		return errors.New("tile is not a square")
 	}
 
-	// Create a new buffer.
-	buf, err := utils.ConvertImageToBytes(img)
+	buf, err := core.TileFormatPNG.Encode(img)
 	if err != nil {
 		return err
 	}
 
-	// Put the object.
-	putObjectParams := &awss3.PutObjectInput{
-		Bucket: &cache.bucketName,
-		Key:    utils.Ptr(tile.ObjectNameWithExt("png")),
-		Body:   bytes.NewReader(buf),
-	}
-
-	fmt.Println("putObjectParams", len(buf), cache.bucketName, tile.ObjectNameWithExt("png"))
-
-	putObjectOutput, err := cache.s3.PutObject(ctx, putObjectParams)
-	if err != nil {
-		return err
-	}
+	return cache.PutTileEncoded(ctx, canvasID, tile.Area, core.TileFormatPNG, buf, version)
+}
 
-	_ = putObjectOutput
-	return nil
+// PutTileEncoded stores a tile already encoded in the given format along with its version.
+func (cache *TileCache) PutTileEncoded(ctx context.Context, canvasID int64, area core.Area, format core.TileFormat, data []byte, version time.Time) error {
+	return cache.backend.Put(ctx, tileObjectKey(canvasID, area, format), data, format.ContentType(), version)
 }
 
+// GetTile returns the cached tile image, or nil if the tile is not cached.
 func (cache *TileCache) GetTile(ctx context.Context, canvasID int64, area core.Area) (image.Image, error) {
-	bucket := cache.bucketName
-	key := area.ObjectNameWithExt("png")
+	img, _, err := cache.GetTileWithVersion(ctx, canvasID, area)
+	return img, err
+}
 
-	getObjectParams := &awss3.GetObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-	}
-	fmt.Println("getObjectParams", getObjectParams)
-	getObjectOutput, err := cache.s3.GetObject(ctx, getObjectParams)
-	if err != nil {
-		return nil, err
+// GetTileWithVersion returns the cached tile image and the time it was rendered at,
+// or a nil image if the tile is not cached. The version is zero if it is unknown.
+func (cache *TileCache) GetTileWithVersion(ctx context.Context, canvasID int64, area core.Area) (image.Image, time.Time, error) {
+	data, version, err := cache.GetTileEncoded(ctx, canvasID, area, core.TileFormatPNG)
+	if err != nil || data == nil {
+		return nil, time.Time{}, err
 	}
-	defer getObjectOutput.Body.Close()
 
-	img, _, err := image.Decode(getObjectOutput.Body)
+	img, err := png.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
-	return img, nil
+	return img, version, nil
 }
 
-func (cache *TileCache) DeleteTile(ctx context.Context, canvasID int64, tile core.Tile) error {
-	bucket := cache.bucketName
-	key := tile.ObjectNameWithExt("png")
-
-	deleteObjectParams := &awss3.DeleteObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-	}
-	fmt.Println("deleteObjectParams", deleteObjectParams)
+// GetTileEncoded returns the tile encoded in the given format and the time it was
+// rendered at, or nil data if the tile is not cached in that format.
+func (cache *TileCache) GetTileEncoded(ctx context.Context, canvasID int64, area core.Area, format core.TileFormat) ([]byte, time.Time, error) {
+	return cache.backend.Get(ctx, tileObjectKey(canvasID, area, format))
+}
 
-	_, err := cache.s3.DeleteObject(ctx, deleteObjectParams)
-	if err != nil {
-		return err
+// DeleteTile deletes the tile in every format.
+func (cache *TileCache) DeleteTile(ctx context.Context, canvasID int64, tile core.Tile) error {
+	for _, format := range []core.TileFormat{core.TileFormatPNG, core.TileFormatPalettedPNG, core.TileFormatRGBA} {
+		if err := cache.backend.Delete(ctx, tileObjectKey(canvasID, tile.Area, format)); err != nil {
+			return err
+		}
 	}
 
 	return nil
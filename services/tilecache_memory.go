@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryTileCacheBackend keeps the tile cache in memory, for development and
+// tests. Tiles are lost on restart and never evicted.
+type MemoryTileCacheBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memoryTileObject
+}
+
+type memoryTileObject struct {
+	data    []byte
+	version time.Time
+}
+
+func NewMemoryTileCacheBackend() *MemoryTileCacheBackend {
+	return &MemoryTileCacheBackend{objects: map[string]memoryTileObject{}}
+}
+
+func (b *MemoryTileCacheBackend) Put(ctx context.Context, key string, data []byte, contentType string, version time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.objects[key] = memoryTileObject{data: slices.Clone(data), version: version.UTC()}
+	return nil
+}
+
+func (b *MemoryTileCacheBackend) Get(ctx context.Context, key string) ([]byte, time.Time, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	object, ok := b.objects[key]
+	if !ok {
+		return nil, time.Time{}, nil
+	}
+	return slices.Clone(object.data), object.version, nil
+}
+
+func (b *MemoryTileCacheBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.objects, key)
+	return nil
+}
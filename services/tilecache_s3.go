@@ -0,0 +1,86 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/lazharichir/draw/utils"
+)
+
+// tileVersionMetadataKey is the object metadata key holding the time a cached
+// tile was rendered at. Every pixel drawn before that time is in the image.
+const tileVersionMetadataKey = "rendered-at"
+
+// S3TileCacheBackend keeps the tile cache in an S3 bucket, e.g. on R2.
+type S3TileCacheBackend struct {
+	bucketName string
+	s3         *awss3.Client
+}
+
+func NewS3TileCacheBackend(s3 *awss3.Client, bucketName string) *S3TileCacheBackend {
+	return &S3TileCacheBackend{s3: s3, bucketName: bucketName}
+}
+
+func (b *S3TileCacheBackend) Put(ctx context.Context, key string, data []byte, contentType string, version time.Time) error {
+	putObjectParams := &awss3.PutObjectInput{
+		Bucket:      &b.bucketName,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: utils.Ptr(contentType),
+		Metadata: map[string]string{
+			tileVersionMetadataKey: version.UTC().Format(time.RFC3339Nano),
+		},
+	}
+
+	fmt.Println("putObjectParams", len(data), b.bucketName, key)
+
+	_, err := b.s3.PutObject(ctx, putObjectParams)
+	return err
+}
+
+func (b *S3TileCacheBackend) Get(ctx context.Context, key string) ([]byte, time.Time, error) {
+	getObjectParams := &awss3.GetObjectInput{
+		Bucket: &b.bucketName,
+		Key:    &key,
+	}
+	fmt.Println("getObjectParams", b.bucketName, key)
+	getObjectOutput, err := b.s3.GetObject(ctx, getObjectParams)
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+	defer getObjectOutput.Body.Close()
+
+	data, err := io.ReadAll(getObjectOutput.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// tiles cached before versions were recorded have no version
+	version, err := time.Parse(time.RFC3339Nano, getObjectOutput.Metadata[tileVersionMetadataKey])
+	if err != nil {
+		version = time.Time{}
+	}
+
+	return data, version, nil
+}
+
+func (b *S3TileCacheBackend) Delete(ctx context.Context, key string) error {
+	deleteObjectParams := &awss3.DeleteObjectInput{
+		Bucket: &b.bucketName,
+		Key:    &key,
+	}
+	fmt.Println("deleteObjectParams", b.bucketName, key)
+
+	_, err := b.s3.DeleteObject(ctx, deleteObjectParams)
+	return err
+}
@@ -2,7 +2,9 @@ package services_test
 
 import (
 	"context"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/lazharichir/draw/config"
 	"github.com/lazharichir/draw/core"
@@ -11,25 +13,41 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestTileCache_Flow(t *testing.T) {
-	cfg := config.GetConfig()
+func TestTileCache_Memory(t *testing.T) {
+	testTileCacheFlow(t, services.NewTileCache(services.NewMemoryTileCacheBackend()))
+}
+
+func TestTileCache_R2(t *testing.T) {
+	cfg, _, err := config.Read(nil, os.LookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TileCache.R2AccountID == "" || cfg.TileCache.R2TestBucket == "" {
+		t.Skip("R2_ACCOUNT_ID and R2_TILECACHE_BUCKET_NAME_TEST are not set")
+	}
 
-	// Create a new mock storage.
-	s3 := utils.MustNewS3Client(cfg.R2_ACCOUNT_ID, cfg.R2_ACCESS_KEY_ID, cfg.R2_ACCESS_KEY_SECRET)
-	cache := services.NewTileCache(s3, cfg.R2_TILECACHE_BUCKET_NAME_TEST)
+	s3, err := utils.NewS3Client(cfg.TileCache.R2AccountID, cfg.TileCache.R2AccessKeyID, cfg.TileCache.R2AccessKeySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTileCacheFlow(t, services.NewTileCache(services.NewS3TileCacheBackend(s3, cfg.TileCache.R2TestBucket)))
+}
 
+func testTileCacheFlow(t *testing.T, cache *services.TileCache) {
 	// Create a new mock image.
 	mockTile := core.NewTile(core.NewArea(core.Pt(-5, -5), core.Pt(5, 5)))
 	mockImg := mockTile.AsImage()
 
 	// Call the PutTile method and check the error.
-	err := cache.PutTile(context.Background(), 1, mockTile, mockImg)
+	version := time.Now().UTC()
+	err := cache.PutTile(context.Background(), 1, mockTile, mockImg, version)
 	assert.NoError(t, err)
 
 	// Call the GetTile method and check the image and error.
-	img, err := cache.GetTile(context.Background(), 1, mockTile.Area)
+	img, cachedAt, err := cache.GetTileWithVersion(context.Background(), 1, mockTile.Area)
 	assert.NoError(t, err)
 	assert.Equal(t, mockImg.Bounds(), img.Bounds())
+	assert.True(t, version.Equal(cachedAt))
 
 	// test that all pixels are equal
 	for x := mockTile.GetMinX(); x < mockTile.GetMaxX(); x++ {
@@ -43,4 +61,9 @@ func TestTileCache_Flow(t *testing.T) {
 	// delete the tile
 	err = cache.DeleteTile(context.Background(), 1, mockTile)
 	assert.NoError(t, err)
+
+	// a missing tile is not an error
+	img, err = cache.GetTile(context.Background(), 1, mockTile.Area)
+	assert.NoError(t, err)
+	assert.Nil(t, img)
 }
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+)
+
+// deltaOverlap is how far before a cached tile's version pixel deltas are loaded from.
+// Pixels are stamped with their transaction's start time, so a write that started
+// just before a render but committed after it would otherwise never be applied.
+// Units of work commit within half of it, see workTimeout.
+const deltaOverlap = 30 * time.Second
+
+// defaultMaxDeltaRatio is the share of a tile's pixels above which a delta is
+// not worth applying and the tile is rendered from scratch instead.
+const defaultMaxDeltaRatio = 0.25
+
+// TileRenderer renders tiles from the pixel storage into the tile cache,
+// in every format served by the tile's canvas.
+type TileRenderer struct {
+	storage       storage.PixelStore
+	tileCache     *TileCache
+	canvases      *CanvasRegistry
+	maxDeltaRatio float64
+}
+
+func NewTileRenderer(storage storage.PixelStore, tileCache *TileCache, canvases *CanvasRegistry) *TileRenderer {
+	return &TileRenderer{
+		storage:       storage,
+		tileCache:     tileCache,
+		canvases:      canvases,
+		maxDeltaRatio: defaultMaxDeltaRatio,
+	}
+}
+
+// RenderTileAs renders the tile like RenderTile and returns it encoded in the given format.
+func (tr *TileRenderer) RenderTileAs(ctx context.Context, canvasID int64, area core.Area, format core.TileFormat) ([]byte, error) {
+	img, encoded, err := tr.renderTile(ctx, canvasID, area)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, ok := encoded[format]; ok {
+		return data, nil
+	}
+
+	return format.Encode(img)
+}
+
+// RenderTile renders the tile from all of its pixels and stores it in the cache.
+func (tr *TileRenderer) RenderTile(ctx context.Context, canvasID int64, area core.Area) (image.Image, error) {
+	img, _, err := tr.renderTile(ctx, canvasID, area)
+	return img, err
+}
+
+func (tr *TileRenderer) renderTile(ctx context.Context, canvasID int64, area core.Area) (image.Image, map[core.TileFormat][]byte, error) {
+	version := time.Now().UTC()
+
+	// load pixels
+	pixels, err := tr.storage.GetPixelsFromTopLeft(ctx, canvasID, area.Min.X, area.Min.Y, area.Width())
+	if err != nil {
+		return nil, nil, fmt.Errorf("RenderTile: %w", err)
+	}
+
+	// create a new tile and add the pixels to it
+	tile := core.NewTile(area)
+	tile.AddPixels(pixels...)
+
+	// build the image
+	img := tile.AsImage()
+
+	// store the tile in the cache
+	encoded, err := tr.cacheTile(ctx, canvasID, tile, img, version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("RenderTile: %w", err)
+	}
+
+	return img, encoded, nil
+}
+
+// cacheTile stores the rendered tile as the reference png and in every format served by its canvas.
+func (tr *TileRenderer) cacheTile(ctx context.Context, canvasID int64, tile core.Tile, img image.Image, version time.Time) (map[core.TileFormat][]byte, error) {
+	encoded := map[core.TileFormat][]byte{}
+
+	formats := append([]core.TileFormat{core.TileFormatPNG}, tr.canvases.Get(canvasID).TileFormats...)
+	for _, format := range formats {
+		if _, ok := encoded[format]; ok {
+			continue
+		}
+
+		data, err := format.Encode(img)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := tr.tileCache.PutTileEncoded(ctx, canvasID, tile.Area, format, data, version); err != nil {
+			return nil, err
+		}
+
+		encoded[format] = data
+	}
+
+	return encoded, nil
+}
+
+// RefreshTile brings a cached tile up to date by applying only the pixels changed
+// since it was rendered. It falls back to RenderTile when the tile is not cached,
+// has no version, or when the delta is too large to be worth applying.
+func (tr *TileRenderer) RefreshTile(ctx context.Context, canvasID int64, area core.Area) (image.Image, error) {
+	version := time.Now().UTC()
+
+	cached, cachedAt, err := tr.tileCache.GetTileWithVersion(ctx, canvasID, area)
+	if err != nil {
+		return nil, fmt.Errorf("RefreshTile: %w", err)
+	}
+
+	if cached == nil || cachedAt.IsZero() {
+		return tr.RenderTile(ctx, canvasID, area)
+	}
+
+	if int64(cached.Bounds().Dx()) != area.Width() || int64(cached.Bounds().Dy()) != area.Height() {
+		return tr.RenderTile(ctx, canvasID, area)
+	}
+
+	// load the pixels changed since the cached version (max is inclusive)
+	bottomRight := area.Max.Translate(-1, -1)
+	delta, err := tr.storage.GetLatestPixelsForArea(ctx, canvasID, area.Min, bottomRight, cachedAt.Add(-deltaOverlap))
+	if err != nil {
+		return nil, fmt.Errorf("RefreshTile: %w", err)
+	}
+
+	if float64(len(delta)) > tr.maxDeltaRatio*float64(area.Width()*area.Height()) {
+		return tr.RenderTile(ctx, canvasID, area)
+	}
+
+	fmt.Println(`------- applying`, len(delta), `changed pixels since`, cachedAt)
+
+	tile := core.NewTile(area)
+	tile.AddPixels(delta...)
+	img := tile.DrawOnto(cached)
+
+	if _, err := tr.cacheTile(ctx, canvasID, tile, img, version); err != nil {
+		return nil, fmt.Errorf("RefreshTile: %w", err)
+	}
+
+	return img, nil
+}
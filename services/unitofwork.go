@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lazharichir/draw/storage"
+)
+
+// workTimeout bounds each attempt of Do. Pixels and tile changes are stamped with
+// their transaction's start time, yet only seen once it commits: committing
+// within half of the precache lag and the delta overlap keeps them from being
+// skipped by the precache worker and tile refreshes, see storage.InTxWithin.
+const workTimeout = min(precacheLag, deltaOverlap) / 2
+
+// Work holds the stores of a unit of work, all running their queries in its transaction.
+type Work struct {
+	Pixels       storage.PixelStore
+	LandRegistry LandRegistry
+	Canvases     storage.CanvasStore
+	Users        Users
+}
+
+// UnitOfWork runs pixel writes, tile-change marks, lease checks and canvas
+// settings changes in a single transaction, so that they either all happen or none of them does.
+type UnitOfWork struct {
+	db           *sql.DB
+	pixels       storage.PixelStore
+	landRegistry LandRegistry
+	canvases     storage.CanvasStore
+	users        Users
+}
+
+// NewUnitOfWork returns a UnitOfWork on db. With memory stores db is nil, and
+// the work runs on the stores directly, without a transaction.
+func NewUnitOfWork(db *sql.DB, pixels storage.PixelStore, landRegistry LandRegistry, canvases storage.CanvasStore, users Users) *UnitOfWork {
+	return &UnitOfWork{db: db, pixels: pixels, landRegistry: landRegistry, canvases: canvases, users: users}
+}
+
+// Do runs fn in a transaction, committed if fn returns nil. It runs at Postgres's
+// default READ COMMITTED isolation, lease checks lock what they read instead,
+// see pgLandRegistry.CanDrawPixel. The transaction is retried on deadlocks, so
+// fn may run several times and must only write through work. Each attempt that
+// does not commit within workTimeout fails, fn must use the context it is given.
+func (u *UnitOfWork) Do(ctx context.Context, fn func(ctx context.Context, work Work) error) error {
+	return u.DoWithin(ctx, workTimeout, fn)
+}
+
+// DoWithin is Do with each attempt bounded by timeout instead, zero meaning no
+// bound. Work that is not bounded must not rely on its pixels and tile changes
+// being picked up by tile refreshes and the precache worker.
+func (u *UnitOfWork) DoWithin(ctx context.Context, timeout time.Duration, fn func(ctx context.Context, work Work) error) error {
+	if u.db == nil {
+		return fn(ctx, Work{Pixels: u.pixels, LandRegistry: u.landRegistry, Canvases: u.canvases, Users: u.users})
+	}
+	return storage.InTxWithin(ctx, u.db, timeout, func(ctx context.Context, tx *sql.Tx) error {
+		return fn(ctx, u.work(tx))
+	})
+}
+
+// View runs fn in a read-only transaction seeing the database as it was when
+// it started, so that fn reads a consistent state across stores and queries.
+func (u *UnitOfWork) View(ctx context.Context, fn func(ctx context.Context, work Work) error) error {
+	if u.db == nil {
+		return fn(ctx, Work{Pixels: u.pixels, LandRegistry: u.landRegistry, Canvases: u.canvases, Users: u.users})
+	}
+	return storage.InReadOnlyTx(ctx, u.db, func(tx *sql.Tx) error {
+		return fn(ctx, u.work(tx))
+	})
+}
+
+func (u *UnitOfWork) work(tx *sql.Tx) Work {
+	return Work{
+		Pixels:       u.pixels.WithTx(tx),
+		LandRegistry: u.landRegistry.WithTx(tx),
+		Canvases:     u.canvases.WithTx(tx),
+		Users:        u.users.WithTx(tx),
+	}
+}
@@ -0,0 +1,83 @@
+package services_test
+
+import (
+	"context"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitOfWork_LeaseBlocksDraw checks that a draw racing a lease on the same
+// pixel waits for the lease to commit, and is then refused.
+func TestUnitOfWork_LeaseBlocksDraw(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.DB(t)
+	landRegistry := services.NewPGLandRegistry(db)
+	unitOfWork := services.NewUnitOfWork(db, storage.NewPGPixelStore(db, nil, 0), landRegistry, storage.NewPGCanvasStore(db), services.NewPGUsers(db, storage.NewIAMStorePG()))
+
+	pixel := core.NewPixel(5, 5, color.RGBA{R: 255, A: 255})
+	lease := newTestLease("usr_owner", core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	t.Cleanup(func() { landRegistry.DeleteLease(ctx, lease.ID) })
+
+	// the lease is saved but not committed yet
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, landRegistry.WithTx(tx).SaveLease(ctx, lease))
+
+	drawn := make(chan bool)
+	go func() {
+		var ok bool
+		err := unitOfWork.Do(ctx, func(ctx context.Context, work services.Work) error {
+			var err error
+			if ok, err = work.LandRegistry.CanDrawPixel(ctx, lease.CanvasID, "usr_other", pixel); err != nil || !ok {
+				return err
+			}
+			return work.Pixels.DrawPixels(ctx, lease.CanvasID, "usr_other", []core.Pixel{pixel})
+		})
+		assert.NoError(t, err)
+		drawn <- ok
+	}()
+
+	select {
+	case <-drawn:
+		t.Fatal("the draw did not wait for the lease")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, tx.Commit())
+	assert.False(t, <-drawn, "the draw sees the committed lease")
+}
+
+// TestUnitOfWork_DoWithin checks that work outlasting its bound is rolled back.
+func TestUnitOfWork_DoWithin(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.DB(t)
+	landRegistry := services.NewPGLandRegistry(db)
+	unitOfWork := services.NewUnitOfWork(db, storage.NewPGPixelStore(db, nil, 0), landRegistry, storage.NewPGCanvasStore(db), services.NewPGUsers(db, storage.NewIAMStorePG()))
+
+	lease := newTestLease("usr_owner", core.NewArea(core.Pt(0, 0), core.Pt(10, 10)))
+	t.Cleanup(func() { landRegistry.DeleteLease(ctx, lease.ID) })
+
+	err := unitOfWork.DoWithin(ctx, 100*time.Millisecond, func(ctx context.Context, work services.Work) error {
+		if err := work.LandRegistry.SaveLease(ctx, lease); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	})
+	assert.Error(t, err)
+
+	leases, err := landRegistry.GetLeasesByLeaseholder(ctx, "usr_owner")
+	require.NoError(t, err)
+	for _, l := range leases {
+		assert.NotEqual(t, lease.ID, l.ID, "the lease was rolled back")
+	}
+}
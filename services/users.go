@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/dbtx"
+)
+
+// Users looks users up in a unit of work, e.g. to check that the users a
+// restored canvas refers to still exist.
+type Users interface {
+	// LoadUsers returns the users with the given IDs, keyed by ID. Unknown IDs are left out.
+	LoadUsers(ctx context.Context, ids ...core.UserID) (map[core.UserID]*core.User, error)
+
+	// WithTx returns the users running their queries in tx, see storage.InTx.
+	WithTx(tx dbtx.DBTx) Users
+}
+
+type pgUsers struct {
+	db  dbtx.DBTx
+	iam *storage.IAMStore
+}
+
+func NewPGUsers(db *sql.DB, iam *storage.IAMStore) Users {
+	return &pgUsers{db: db, iam: iam}
+}
+
+func (u *pgUsers) WithTx(tx dbtx.DBTx) Users {
+	return &pgUsers{db: tx, iam: u.iam}
+}
+
+func (u *pgUsers) LoadUsers(ctx context.Context, ids ...core.UserID) (map[core.UserID]*core.User, error) {
+	return u.iam.LoadUsers(ctx, u.db, ids...)
+}
+
+// memoryUsers holds a fixed set of users, for development and tests.
+type memoryUsers struct {
+	mu    sync.RWMutex
+	users map[core.UserID]core.User
+}
+
+func NewMemoryUsers(users ...core.User) Users {
+	mu := &memoryUsers{users: map[core.UserID]core.User{}}
+	for _, user := range users {
+		mu.users[user.ID] = user
+	}
+	return mu
+}
+
+// WithTx returns the users themselves, memory has no transactions.
+func (u *memoryUsers) WithTx(tx dbtx.DBTx) Users {
+	return u
+}
+
+func (u *memoryUsers) LoadUsers(ctx context.Context, ids ...core.UserID) (map[core.UserID]*core.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	users := map[core.UserID]*core.User{}
+	for _, id := range ids {
+		if user, ok := u.users[id]; ok {
+			users[id] = &user
+		}
+	}
+	return users, nil
+}
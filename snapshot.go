@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/lazharichir/draw/config"
+	"github.com/lazharichir/draw/services"
+	"github.com/lazharichir/draw/storage"
+)
+
+const snapshotUsage = `usage: main [flags] snapshot create canvas_id file
+       main [flags] snapshot restore [-replace] canvas_id file
+
+Writes a snapshot of the canvas's pixels, leases and settings to file, or
+restores one into the canvas, which must have neither pixels nor leases.
+Restored settings are saved, running servers pick them up within a minute.
+
+  -replace  delete the canvas's pixels and leases before restoring`
+
+// runSnapshot runs the snapshot subcommand and returns the process exit code.
+func runSnapshot(cfg *config.Config, args []string) int {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "restore") {
+		fmt.Fprintln(os.Stderr, snapshotUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("snapshot "+args[0], flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, snapshotUsage) }
+	replace := flags.Bool("replace", false, "delete the canvas's pixels and leases first")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 || (args[0] == "create" && *replace) {
+		fmt.Fprintln(os.Stderr, snapshotUsage)
+		return 2
+	}
+	canvasID, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, snapshotUsage)
+		return 2
+	}
+	path := flags.Arg(1)
+
+	ctx := context.Background()
+	db, err := storage.OpenPG(ctx, cfg.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	tileCacheBackend, err := newTileCacheBackend(cfg.TileCache)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	canvasStore := storage.NewPGCanvasStore(db)
+	canvases := services.NewCanvasRegistry()
+	canvases.SetDefaultTileSides(cfg.TileSides, cfg.MaxTileSide)
+	if err := canvases.Load(ctx, canvasStore); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	unitOfWork := services.NewUnitOfWork(db, newPixelStore(db, cfg), services.NewPGLandRegistry(db), canvasStore, services.NewPGUsers(db, storage.NewIAMStorePG()))
+	snapshots := services.NewSnapshots(unitOfWork, services.NewTileCache(tileCacheBackend), canvases)
+
+	if args[0] == "create" {
+		err = createSnapshot(ctx, snapshots, canvasID, path)
+	} else {
+		err = restoreSnapshot(ctx, snapshots, canvasID, path, *replace)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func createSnapshot(ctx context.Context, snapshots *services.Snapshots, canvasID int64, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := snapshots.Create(ctx, canvasID, f)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("canvas %d: wrote %d pixels and %d leases to %s\n", canvasID, manifest.Pixels, manifest.Leases, path)
+	return nil
+}
+
+func restoreSnapshot(ctx context.Context, snapshots *services.Snapshots, canvasID int64, path string, replace bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := snapshots.Restore(ctx, canvasID, f, info.Size(), replace)
+	if manifest != nil {
+		fmt.Printf("canvas %d: restored %d pixels and %d leases of canvas %d\n", canvasID, manifest.Pixels, manifest.Leases, manifest.CanvasID)
+	}
+	return err
+}
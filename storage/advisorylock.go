@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lazharichir/draw/storage/dbtx"
+)
+
+// TryAdvisoryLock tries to take the session-level Postgres advisory lock identified by key.
+// Advisory locks belong to the connection that took them, so the lock holds on to a
+// dedicated connection until release is called. If the lock is already held elsewhere,
+// ok is false and there is nothing to release.
+func TryAdvisoryLock(ctx context.Context, db *sql.DB, key int64) (release func() error, ok bool, err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("TryAdvisoryLock: %w", err)
+	}
+
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("TryAdvisoryLock: %w", err)
+	}
+
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	release = func() error {
+		defer conn.Close()
+		// the caller's context may be done by now, the lock must be released regardless
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+		return err
+	}
+
+	return release, true, nil
+}
+
+// canvasLockClass namespaces the transaction-level advisory locks of canvases,
+// whose two-key form never collides with the one-key session locks.
+const canvasLockClass int32 = 0x63616e76 // "canv"
+
+// LockCanvas takes the canvas's transaction-level advisory lock, held until tx
+// commits or rolls back: shared to read its leases and draw accordingly, or
+// exclusive to change them. Outside a transaction it is released right away.
+func LockCanvas(ctx context.Context, tx dbtx.DBTx, canvasID int64, exclusive bool) error {
+	query := `SELECT pg_advisory_xact_lock_shared($1, $2)`
+	if exclusive {
+		query = `SELECT pg_advisory_xact_lock($1, $2)`
+	}
+	// canvas IDs wrapping around only share a lock, at worst
+	if _, err := tx.ExecContext(ctx, query, canvasLockClass, int32(canvasID)); err != nil {
+		return fmt.Errorf("LockCanvas: %w", err)
+	}
+	return nil
+}
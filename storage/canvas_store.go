@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage/dbtx"
+	"github.com/lib/pq"
+	"golang.org/x/exp/slices"
+)
+
+// CanvasStore keeps the settings of the canvases configured away from the
+// server's defaults, so that every server serves them the same tiles.
+type CanvasStore interface {
+	SaveCanvas(ctx context.Context, canvas core.Canvas) error
+	// GetCanvas returns the saved canvas, or nil if it was never saved.
+	GetCanvas(ctx context.Context, canvasID int64) (*core.Canvas, error)
+	// LoadCanvases returns every saved canvas, by ID.
+	LoadCanvases(ctx context.Context) ([]core.Canvas, error)
+
+	// WithTx returns the store running its queries in tx, see InTx.
+	WithTx(tx dbtx.DBTx) CanvasStore
+}
+
+type pgCanvasStore struct {
+	db dbtx.DBTx
+}
+
+func NewPGCanvasStore(db *sql.DB) CanvasStore {
+	return &pgCanvasStore{db: db}
+}
+
+func (store *pgCanvasStore) WithTx(tx dbtx.DBTx) CanvasStore {
+	return &pgCanvasStore{db: tx}
+}
+
+func (store *pgCanvasStore) SaveCanvas(ctx context.Context, canvas core.Canvas) error {
+	formats := make(pq.StringArray, len(canvas.TileFormats))
+	for i, format := range canvas.TileFormats {
+		formats[i] = string(format)
+	}
+
+	_, err := store.db.ExecContext(ctx, `
+		INSERT INTO canvases (id, tile_sides, max_tile_side, tile_formats, updated_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (id) DO UPDATE SET
+			tile_sides = EXCLUDED.tile_sides,
+			max_tile_side = EXCLUDED.max_tile_side,
+			tile_formats = EXCLUDED.tile_formats,
+			updated_at = EXCLUDED.updated_at
+	`, canvas.ID, pq.Array(canvas.TileSides), canvas.MaxTileSide, formats)
+	if err != nil {
+		return fmt.Errorf("SaveCanvas: %w", err)
+	}
+	return nil
+}
+
+func scanCanvas(row interface{ Scan(dest ...any) error }) (*core.Canvas, error) {
+	var canvas core.Canvas
+	var formats pq.StringArray
+	if err := row.Scan(&canvas.ID, pq.Array(&canvas.TileSides), &canvas.MaxTileSide, &formats); err != nil {
+		return nil, err
+	}
+	for _, format := range formats {
+		canvas.TileFormats = append(canvas.TileFormats, core.TileFormat(format))
+	}
+	return &canvas, nil
+}
+
+func (store *pgCanvasStore) GetCanvas(ctx context.Context, canvasID int64) (*core.Canvas, error) {
+	row := store.db.QueryRowContext(ctx, `SELECT id, tile_sides, max_tile_side, tile_formats FROM canvases WHERE id = $1`, canvasID)
+	canvas, err := scanCanvas(row)
+	if err == ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("GetCanvas: %w", err)
+	}
+	return canvas, nil
+}
+
+func (store *pgCanvasStore) LoadCanvases(ctx context.Context) ([]core.Canvas, error) {
+	rows, err := store.db.QueryContext(ctx, `SELECT id, tile_sides, max_tile_side, tile_formats FROM canvases ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("LoadCanvases: %w", err)
+	}
+	defer rows.Close()
+
+	var canvases []core.Canvas
+	for rows.Next() {
+		canvas, err := scanCanvas(rows)
+		if err != nil {
+			return nil, fmt.Errorf("LoadCanvases: %w", err)
+		}
+		canvases = append(canvases, *canvas)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("LoadCanvases: %w", err)
+	}
+	return canvases, nil
+}
+
+// memoryCanvasStore keeps canvases in memory, for development and tests.
+type memoryCanvasStore struct {
+	mu       sync.RWMutex
+	canvases map[int64]core.Canvas
+}
+
+func NewMemoryCanvasStore() CanvasStore {
+	return &memoryCanvasStore{canvases: map[int64]core.Canvas{}}
+}
+
+// WithTx returns the store itself, writes to memory cannot be rolled back.
+func (store *memoryCanvasStore) WithTx(tx dbtx.DBTx) CanvasStore {
+	return store
+}
+
+func (store *memoryCanvasStore) SaveCanvas(ctx context.Context, canvas core.Canvas) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	canvas.TileSides = slices.Clone(canvas.TileSides)
+	canvas.TileFormats = slices.Clone(canvas.TileFormats)
+	store.canvases[canvas.ID] = canvas
+	return nil
+}
+
+func (store *memoryCanvasStore) GetCanvas(ctx context.Context, canvasID int64) (*core.Canvas, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	canvas, ok := store.canvases[canvasID]
+	if !ok {
+		return nil, nil
+	}
+	canvas.TileSides = slices.Clone(canvas.TileSides)
+	canvas.TileFormats = slices.Clone(canvas.TileFormats)
+	return &canvas, nil
+}
+
+func (store *memoryCanvasStore) LoadCanvases(ctx context.Context) ([]core.Canvas, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	canvases := make([]core.Canvas, 0, len(store.canvases))
+	for _, canvas := range store.canvases {
+		canvas.TileSides = slices.Clone(canvas.TileSides)
+		canvas.TileFormats = slices.Clone(canvas.TileFormats)
+		canvases = append(canvases, canvas)
+	}
+	sort.Slice(canvases, func(i, j int) bool { return canvases[i].ID < canvases[j].ID })
+	return canvases, nil
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+)
+
+const (
+	// chunkShift is log2 of chunkSide, shifting a coordinate right by it floors
+	// it to its chunk's, negative ones included.
+	chunkShift = 6
+	// chunkSide is the width and height in pixels of the chunks of the chunked store.
+	chunkSide   = 1 << chunkShift
+	chunkPixels = chunkSide * chunkSide
+)
+
+var ErrCorruptChunk = errors.New("the pixel chunk is corrupt")
+
+// chunkKey locates a chunk on its canvas, in chunks rather than pixels.
+type chunkKey struct {
+	cx, cy int64
+}
+
+func chunkKeyOf(p core.Point) chunkKey {
+	return chunkKey{p.X >> chunkShift, p.Y >> chunkShift}
+}
+
+// origin returns the chunk's top left pixel.
+func (k chunkKey) origin() core.Point {
+	return core.Pt(k.cx<<chunkShift, k.cy<<chunkShift)
+}
+
+// index returns where the pixel at p, which must lie in the chunk, is stored.
+func (k chunkKey) index(p core.Point) int {
+	origin := k.origin()
+	return int(p.Y-origin.Y)*chunkSide + int(p.X-origin.X)
+}
+
+// point is the inverse of index.
+func (k chunkKey) point(i int) core.Point {
+	origin := k.origin()
+	return core.Pt(origin.X+int64(i%chunkSide), origin.Y+int64(i/chunkSide))
+}
+
+// pixelChunk holds a square of chunkSide pixels, row by row. A pixel never drawn
+// has a zero drawnAt, which tells it from one erased to transparent.
+type pixelChunk struct {
+	rgba    []byte  // 4 bytes per pixel
+	drawnAt []int64 // unix microseconds
+	// drawer holds 1 + the index in drawers of who drew each pixel, 0 if it was
+	// drawn anonymously, so that a chunk stores each user id once. Chunks are
+	// compacted after each write, so drawers never outgrow the uint16 indices.
+	drawer  []uint16
+	drawers []core.UserID
+}
+
+func newPixelChunk() *pixelChunk {
+	return &pixelChunk{
+		rgba:    make([]byte, chunkPixels*4),
+		drawnAt: make([]int64, chunkPixels),
+		drawer:  make([]uint16, chunkPixels),
+	}
+}
+
+// isDrawn reports whether the pixel at i was ever drawn.
+func (c *pixelChunk) isDrawn(i int) bool {
+	return c.drawnAt[i] != 0
+}
+
+func (c *pixelChunk) color(i int) color.RGBA {
+	return color.RGBA{R: c.rgba[i*4], G: c.rgba[i*4+1], B: c.rgba[i*4+2], A: c.rgba[i*4+3]}
+}
+
+func (c *pixelChunk) drawnAtTime(i int) time.Time {
+	return time.UnixMicro(c.drawnAt[i]).UTC()
+}
+
+func (c *pixelChunk) drawnBy(i int) core.UserID {
+	if c.drawer[i] == 0 {
+		return ""
+	}
+	return c.drawers[c.drawer[i]-1]
+}
+
+// set draws the pixel at i. Drawers no pixel refers to anymore stay in drawers
+// until compact.
+func (c *pixelChunk) set(i int, rgba color.RGBA, at time.Time, by core.UserID) {
+	c.rgba[i*4], c.rgba[i*4+1], c.rgba[i*4+2], c.rgba[i*4+3] = rgba.R, rgba.G, rgba.B, rgba.A
+	c.drawnAt[i] = at.UnixMicro()
+	c.drawer[i] = c.drawerIndex(by)
+}
+
+func (c *pixelChunk) drawerIndex(by core.UserID) uint16 {
+	if by.IsAnonymous() {
+		return 0
+	}
+	for i, drawer := range c.drawers {
+		if drawer == by {
+			return uint16(i + 1)
+		}
+	}
+	c.drawers = append(c.drawers, by)
+	return uint16(len(c.drawers))
+}
+
+// anonymize detaches the user from the pixels they drew and returns how many there were.
+func (c *pixelChunk) anonymize(by core.UserID) int64 {
+	index := uint16(0)
+	for i, drawer := range c.drawers {
+		if drawer == by {
+			index = uint16(i + 1)
+		}
+	}
+	if index == 0 {
+		return 0
+	}
+
+	count := int64(0)
+	for i := range c.drawer {
+		if c.drawer[i] == index {
+			c.drawer[i] = 0
+			count++
+		}
+	}
+	c.compact()
+	return count
+}
+
+// compact drops the drawers of pixels since drawn over, so that a chunk only
+// lists, and is only found by, the users it holds pixels of.
+func (c *pixelChunk) compact() {
+	used := make([]bool, len(c.drawers)+1)
+	for _, index := range c.drawer {
+		used[index] = true
+	}
+
+	renumbered := make([]uint16, len(c.drawers)+1)
+	drawers := c.drawers[:0]
+	for i, drawer := range c.drawers {
+		if used[i+1] {
+			drawers = append(drawers, drawer)
+			renumbered[i+1] = uint16(len(drawers))
+		}
+	}
+	c.drawers = drawers
+
+	for i, index := range c.drawer {
+		c.drawer[i] = renumbered[index]
+	}
+}
+
+// encode returns the columns the chunk is stored in, see migration 0008.
+func (c *pixelChunk) encode() (rgba, drawnAt, drawer []byte, drawers []string) {
+	drawnAt = make([]byte, chunkPixels*8)
+	for i, at := range c.drawnAt {
+		binary.BigEndian.PutUint64(drawnAt[i*8:], uint64(at))
+	}
+	drawer = make([]byte, chunkPixels*2)
+	for i, index := range c.drawer {
+		binary.BigEndian.PutUint16(drawer[i*2:], index)
+	}
+	drawers = make([]string, len(c.drawers))
+	for i, by := range c.drawers {
+		drawers[i] = by.String()
+	}
+	return c.rgba, drawnAt, drawer, drawers
+}
+
+// decodePixelChunk is the inverse of encode.
+func decodePixelChunk(rgba, drawnAt, drawer []byte, drawers []string) (*pixelChunk, error) {
+	if len(rgba) != chunkPixels*4 || len(drawnAt) != chunkPixels*8 || len(drawer) != chunkPixels*2 {
+		return nil, fmt.Errorf("%w: %d, %d and %d bytes", ErrCorruptChunk, len(rgba), len(drawnAt), len(drawer))
+	}
+
+	c := &pixelChunk{
+		rgba:    rgba,
+		drawnAt: make([]int64, chunkPixels),
+		drawer:  make([]uint16, chunkPixels),
+		drawers: make([]core.UserID, len(drawers)),
+	}
+	for i := range c.drawnAt {
+		c.drawnAt[i] = int64(binary.BigEndian.Uint64(drawnAt[i*8:]))
+	}
+	for i := range c.drawer {
+		c.drawer[i] = binary.BigEndian.Uint16(drawer[i*2:])
+		if int(c.drawer[i]) > len(drawers) {
+			return nil, fmt.Errorf("%w: drawer %d of %d", ErrCorruptChunk, c.drawer[i], len(drawers))
+		}
+	}
+	for i, by := range drawers {
+		c.drawers[i] = core.UserID(by)
+	}
+	return c, nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkKey(t *testing.T) {
+	tests := []struct {
+		point core.Point
+		key   chunkKey
+		index int
+	}{
+		{core.Pt(0, 0), chunkKey{0, 0}, 0},
+		{core.Pt(63, 0), chunkKey{0, 0}, 63},
+		{core.Pt(0, 1), chunkKey{0, 0}, 64},
+		{core.Pt(64, 64), chunkKey{1, 1}, 0},
+		{core.Pt(-1, -1), chunkKey{-1, -1}, chunkPixels - 1},
+		{core.Pt(-64, 5), chunkKey{-1, 0}, 5 * chunkSide},
+		{core.Pt(-65, 0), chunkKey{-2, 0}, 63},
+	}
+	for _, tt := range tests {
+		key := chunkKeyOf(tt.point)
+		assert.Equal(t, tt.key, key, tt.point)
+		assert.Equal(t, tt.index, key.index(tt.point), tt.point)
+		assert.Equal(t, tt.point, key.point(tt.index), tt.point)
+	}
+}
+
+func TestPixelChunk_SetAndCompact(t *testing.T) {
+	at := time.Date(2023, 10, 5, 16, 14, 0, 123456000, time.UTC)
+	alice, bob := core.UserID("alice"), core.UserID("bob")
+	red := color.RGBA{R: 255, A: 255}
+
+	chunk := newPixelChunk()
+	assert.False(t, chunk.isDrawn(0))
+
+	chunk.set(0, red, at, alice)
+	chunk.set(1, red, at, bob)
+	chunk.set(2, color.RGBA{}, at, "")
+	assert.True(t, chunk.isDrawn(2), "erased pixels are drawn")
+	assert.Equal(t, red, chunk.color(0))
+	assert.Equal(t, at, chunk.drawnAtTime(0))
+	assert.Equal(t, bob, chunk.drawnBy(1))
+	assert.Equal(t, core.UserID(""), chunk.drawnBy(2))
+
+	// alice's only pixel is drawn over, she no longer belongs in the chunk
+	chunk.set(0, red, at, bob)
+	chunk.compact()
+	assert.Equal(t, []core.UserID{bob}, chunk.drawers)
+	assert.Equal(t, bob, chunk.drawnBy(0))
+	assert.Equal(t, bob, chunk.drawnBy(1))
+
+	assert.Equal(t, int64(0), chunk.anonymize(alice))
+	assert.Equal(t, int64(2), chunk.anonymize(bob))
+	assert.Empty(t, chunk.drawers)
+	assert.Equal(t, red, chunk.color(1), "anonymized pixels stay")
+}
+
+func TestPixelChunk_Encode(t *testing.T) {
+	at := time.Date(2023, 10, 5, 16, 14, 0, 123456000, time.UTC)
+
+	chunk := newPixelChunk()
+	chunk.set(0, color.RGBA{R: 1, G: 2, B: 3, A: 4}, at, "alice")
+	chunk.set(chunkPixels-1, color.RGBA{R: 5, G: 6, B: 7, A: 8}, at.Add(time.Second), "bob")
+
+	decoded, err := decodePixelChunk(chunk.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, chunk, decoded)
+
+	rgba, drawnAt, drawer, _ := chunk.encode()
+	_, err = decodePixelChunk(rgba[:10], drawnAt, drawer, nil)
+	assert.ErrorIs(t, err, ErrCorruptChunk)
+	_, err = decodePixelChunk(rgba, drawnAt, drawer, []string{"alice"})
+	assert.ErrorIs(t, err, ErrCorruptChunk, "bob is missing from the drawers")
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/lazharichir/draw/core"
+)
+
+// ConversionStats counts what ConvertCanvasToChunks converted.
+type ConversionStats struct {
+	Chunks int
+	Pixels int64
+}
+
+// ConvertCanvasToChunks copies a canvas's pixels from the row store's table to
+// the chunked store's, one chunk per transaction, so that it holds no lock for
+// long and can be resumed if interrupted. A pixel already in a chunk is only
+// overwritten by one drawn more recently, so converting again after the server
+// switched to the chunked store picks up the pixels drawn in between and loses
+// none drawn since. With deleteRows, the rows of each chunk are deleted in the
+// transaction converting it.
+func ConvertCanvasToChunks(ctx context.Context, db *sql.DB, canvasID int64, deleteRows bool) (ConversionStats, error) {
+	stats := ConversionStats{}
+
+	keys, err := rowChunkKeys(ctx, db, canvasID)
+	if err != nil {
+		return stats, fmt.Errorf("ConvertCanvasToChunks: %w", err)
+	}
+
+	store := &pgChunkPixelStore{&pgPixelStore{db: db}}
+	for _, key := range keys {
+		var pixels int64
+		err := InTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			pixels, err = store.WithTx(tx).(*pgChunkPixelStore).convertChunk(ctx, canvasID, key, deleteRows)
+			return err
+		})
+		if err != nil {
+			return stats, fmt.Errorf("ConvertCanvasToChunks %d,%d: %w", key.cx, key.cy, err)
+		}
+		stats.Chunks++
+		stats.Pixels += pixels
+	}
+
+	return stats, nil
+}
+
+// rowChunkKeys returns the chunks the canvas has pixels in in the row store, in order.
+func rowChunkKeys(ctx context.Context, db *sql.DB, canvasID int64) ([]chunkKey, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT x >> $2, y >> $2 FROM pixels WHERE canvas_id = $1 ORDER BY 1, 2
+	`, canvasID, chunkShift)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []chunkKey
+	for rows.Next() {
+		var key chunkKey
+		if err := rows.Scan(&key.cx, &key.cy); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// convertChunk merges the chunk's pixels from the row store into it and returns
+// how many there were. It must run in a transaction.
+func (store *pgChunkPixelStore) convertChunk(ctx context.Context, canvasID int64, key chunkKey, deleteRows bool) (int64, error) {
+	chunks, now, err := store.lockChunks(ctx, canvasID, []chunkKey{key})
+	if err != nil {
+		return 0, err
+	}
+	chunk := chunks[key]
+
+	min := key.origin()
+	max := core.Pt(min.X+chunkSide-1, min.Y+chunkSide-1)
+
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("x", "y", "r", "g", "b", "a", "drawn_at", "drawn_by")
+	sb.From("pixels")
+	sb.Where(
+		sb.Equal("canvas_id", canvasID),
+		sb.Between("x", min.X, max.X),
+		sb.Between("y", min.Y, max.Y),
+	)
+	query, args := sb.Build()
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := int64(0)
+	for rows.Next() {
+		var pixel core.Pixel
+		var drawnAt time.Time
+		var drawnBy sql.NullString
+		if err := rows.Scan(&pixel.X, &pixel.Y, &pixel.RGBA.R, &pixel.RGBA.G, &pixel.RGBA.B, &pixel.RGBA.A, &drawnAt, &drawnBy); err != nil {
+			return 0, err
+		}
+		count++
+
+		i := key.index(pixel.Point)
+		if chunk.drawnAt[i] >= drawnAt.UnixMicro() {
+			continue
+		}
+		chunk.set(i, pixel.RGBA, drawnAt, core.UserID(drawnBy.String))
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	chunk.compact()
+	if err := store.saveChunk(ctx, canvasID, key, chunk, now); err != nil {
+		return 0, err
+	}
+
+	if deleteRows {
+		db := sqlbuilder.PostgreSQL.NewDeleteBuilder()
+		db.DeleteFrom("pixels")
+		db.Where(
+			db.Equal("canvas_id", canvasID),
+			db.Between("x", min.X, max.X),
+			db.Between("y", min.Y, max.Y),
+		)
+		query, args := db.Build()
+		if _, err := store.db.ExecContext(ctx, query, args...); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image/color"
+	"sort"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage/dbtx"
+	"github.com/lib/pq"
+	"golang.org/x/exp/slog"
+)
+
+// pgChunkPixelStore keeps pixels in pixel_chunks, one row per chunkSide square
+// rather than one per pixel, which takes a fraction of the space and of the rows
+// read to render a tile. Tile changes and high-water marks are kept like in
+// pgPixelStore, whose methods it reuses for them.
+//
+// Writes read the chunks they touch, draw on them and write them back, holding
+// the chunks' row locks in between so that concurrent writes to a chunk wait on
+// each other rather than overwrite each other's pixels.
+type pgChunkPixelStore struct {
+	*pgPixelStore
+}
+
+func NewPGChunkPixelStore(db *sql.DB, log *slog.Logger, queryTimeout time.Duration) PixelStore {
+	return &pgChunkPixelStore{&pgPixelStore{db, log, queryTimeout}}
+}
+
+func (store *pgChunkPixelStore) WithTx(tx dbtx.DBTx) PixelStore {
+	return &pgChunkPixelStore{&pgPixelStore{tx, store.log, store.queryTimeout}}
+}
+
+// inTx runs fn with the store in a transaction, its own if it already runs in one.
+func (store *pgChunkPixelStore) inTx(ctx context.Context, fn func(store *pgChunkPixelStore) error) error {
+	db, ok := store.db.(*sql.DB)
+	if !ok {
+		return fn(store)
+	}
+	return InTx(ctx, db, func(tx *sql.Tx) error {
+		return fn(store.WithTx(tx).(*pgChunkPixelStore))
+	})
+}
+
+func (store *pgChunkPixelStore) ErasePixel(ctx context.Context, canvasID int64, erasedBy core.UserID, x int64, y int64) error {
+	return store.DrawPixelRGBA(ctx, canvasID, erasedBy, x, y, color.RGBA{})
+}
+
+func (store *pgChunkPixelStore) DrawPixelRGBA(ctx context.Context, canvasID int64, drawnBy core.UserID, x int64, y int64, color color.RGBA) error {
+	return store.DrawPixels(ctx, canvasID, drawnBy, []core.Pixel{
+		core.NewPixel(x, y, color),
+	})
+}
+
+// DrawPixels implements PixelStore
+// The chunks are locked in order, so that two images overlapping on several
+// chunks can't each hold one the other waits for
+func (store *pgChunkPixelStore) DrawPixels(ctx context.Context, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	if len(pixels) == 0 {
+		return ctx.Err()
+	}
+
+	byChunk := map[chunkKey][]core.Pixel{}
+	for _, pixel := range pixels {
+		key := chunkKeyOf(pixel.Point)
+		byChunk[key] = append(byChunk[key], pixel)
+	}
+	keys := make([]chunkKey, 0, len(byChunk))
+	for key := range byChunk {
+		keys = append(keys, key)
+	}
+	sortChunkKeys(keys)
+
+	return store.inTx(ctx, func(store *pgChunkPixelStore) error {
+		ctx, cancel := store.withQueryTimeout(ctx)
+		defer cancel()
+
+		chunks, now, err := store.lockChunks(ctx, canvasID, keys)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			chunk := chunks[key]
+			// in order, so that a pixel given twice keeps its last color
+			for _, pixel := range byChunk[key] {
+				chunk.set(key.index(pixel.Point), pixel.RGBA, now, drawnBy)
+			}
+			chunk.compact()
+			if err := store.saveChunk(ctx, canvasID, key, chunk, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lockChunks creates the chunks that don't exist yet, then locks and returns
+// them all, along with the transaction's time to draw with. It must run in a
+// transaction.
+func (store *pgChunkPixelStore) lockChunks(ctx context.Context, canvasID int64, keys []chunkKey) (map[chunkKey]*pixelChunk, time.Time, error) {
+	cxs := make([]int64, len(keys))
+	cys := make([]int64, len(keys))
+	for i, key := range keys {
+		cxs[i], cys[i] = key.cx, key.cy
+	}
+
+	// inserting then locking, rather than locking then inserting the missing
+	// ones, keeps two transactions creating the same chunk from both writing it
+	rgba, drawnAt, drawer, drawers := newPixelChunk().encode()
+	if _, err := store.db.ExecContext(ctx, `
+		INSERT INTO pixel_chunks (canvas_id, cx, cy, rgba, drawn_at, drawer_idx, drawers, updated_at)
+		SELECT $1::bigint, k.cx, k.cy, $4::bytea, $5::bytea, $6::bytea, $7::text[], NOW()
+		FROM unnest($2::bigint[], $3::bigint[]) AS k (cx, cy)
+		ORDER BY k.cx, k.cy
+		ON CONFLICT (canvas_id, cx, cy) DO NOTHING
+	`, canvasID, pq.Array(cxs), pq.Array(cys), rgba, drawnAt, drawer, pq.Array(drawers)); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	rows, err := store.db.QueryContext(ctx, `
+		SELECT c.cx, c.cy, c.rgba, c.drawn_at, c.drawer_idx, c.drawers, NOW()
+		FROM pixel_chunks c
+		JOIN unnest($2::bigint[], $3::bigint[]) AS k (cx, cy) ON c.cx = k.cx AND c.cy = k.cy
+		WHERE c.canvas_id = $1
+		ORDER BY c.cx, c.cy
+		FOR UPDATE OF c
+	`, canvasID, pq.Array(cxs), pq.Array(cys))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	chunks := make(map[chunkKey]*pixelChunk, len(keys))
+	var now time.Time
+	for rows.Next() {
+		var key chunkKey
+		chunk, err := scanChunk(rows, []any{&key.cx, &key.cy}, &now)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		chunks[key] = chunk
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(chunks) != len(keys) {
+		return nil, time.Time{}, fmt.Errorf("lockChunks: locked %d chunks out of %d", len(chunks), len(keys))
+	}
+
+	return chunks, now.UTC().Round(time.Microsecond), nil
+}
+
+// selectChunks selects the cx, cy, rgba, drawn_at, drawer_idx and drawers
+// columns of pixel_chunks, between the before and after ones.
+func selectChunks(before []string, after ...string) *sqlbuilder.SelectBuilder {
+	cols := append(append(before, "cx", "cy", "rgba", "drawn_at", "drawer_idx", "drawers"), after...)
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select(cols...)
+	sb.From("pixel_chunks")
+	return sb
+}
+
+// scanChunk scans the rgba, drawn_at, drawer_idx and drawers columns, between
+// those scanned into before and after.
+func scanChunk(rows *sql.Rows, before []any, after ...any) (*pixelChunk, error) {
+	var rgba, drawnAt, drawer []byte
+	var drawers pq.StringArray
+	dest := append(append(before, &rgba, &drawnAt, &drawer, &drawers), after...)
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return decodePixelChunk(rgba, drawnAt, drawer, drawers)
+}
+
+// saveChunk writes back a chunk locked by lockChunks.
+func (store *pgChunkPixelStore) saveChunk(ctx context.Context, canvasID int64, key chunkKey, chunk *pixelChunk, updatedAt time.Time) error {
+	rgba, drawnAt, drawer, drawers := chunk.encode()
+	_, err := store.db.ExecContext(ctx, `
+		UPDATE pixel_chunks SET rgba = $4, drawn_at = $5, drawer_idx = $6, drawers = $7, updated_at = $8
+		WHERE canvas_id = $1 AND cx = $2 AND cy = $3
+	`, canvasID, key.cx, key.cy, rgba, drawnAt, drawer, pq.Array(drawers), updatedAt)
+	return err
+}
+
+// GetLatestPixelsForArea implements PixelStore
+// Only the chunks updated after after are read, then only their pixels drawn after it are kept
+func (store *pgChunkPixelStore) GetLatestPixelsForArea(ctx context.Context, canvasID int64, topLeft core.Point, bottomRight core.Point, after time.Time) ([]core.Pixel, error) {
+	return store.findPixels(ctx, canvasID, topLeft, bottomRight, after)
+}
+
+// GetPixelsFromTopLeft implements PixelStore
+// Like the row store, it includes the pixels at x+width and y+width
+func (store *pgChunkPixelStore) GetPixelsFromTopLeft(ctx context.Context, canvasID int64, tlX int64, tlY int64, width int64) ([]core.Pixel, error) {
+	return store.findPixels(ctx, canvasID, core.Pt(tlX, tlY), core.Pt(tlX+width, tlY+width), time.Time{})
+}
+
+// findPixels returns the pixels between min and max, both inclusive, drawn after after.
+func (store *pgChunkPixelStore) findPixels(ctx context.Context, canvasID int64, min, max core.Point, after time.Time) ([]core.Pixel, error) {
+	minKey, maxKey := chunkKeyOf(min), chunkKeyOf(max)
+
+	sb := selectChunks(nil)
+	sb.Where(
+		sb.Equal("canvas_id", canvasID),
+		sb.Between("cx", minKey.cx, maxKey.cx),
+		sb.Between("cy", minKey.cy, maxKey.cy),
+		sb.GreaterThan("updated_at", after),
+	)
+	query, args := sb.Build()
+
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	afterMicro := after.UnixMicro()
+	var pixels []core.Pixel
+	for rows.Next() {
+		var key chunkKey
+		chunk, err := scanChunk(rows, []any{&key.cx, &key.cy})
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < chunkPixels; i++ {
+			if !chunk.isDrawn(i) || chunk.drawnAt[i] <= afterMicro {
+				continue
+			}
+			point := key.point(i)
+			if point.X < min.X || point.X > max.X || point.Y < min.Y || point.Y > max.Y {
+				continue
+			}
+			pixels = append(pixels, core.NewPixel(point.X, point.Y, chunk.color(i)))
+		}
+	}
+
+	return pixels, rows.Err()
+}
+
+// ForEachPixelDrawnBy implements PixelStore
+// The chunks holding the user's pixels are found through their drawers. As they
+// don't order the pixels by time, each canvas's pixels are sorted before fn is
+// called with them, so only one canvas's worth is held at once. Like the row
+// store's, it is bound by neither the query nor the statement timeout
+func (store *pgChunkPixelStore) ForEachPixelDrawnBy(ctx context.Context, drawnBy core.UserID, fn func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error) error {
+	return withoutStatementTimeout(ctx, store.db, func(db dbtx.DBTx) error {
+		return store.WithTx(db).(*pgChunkPixelStore).forEachPixelDrawnBy(ctx, drawnBy, fn)
+	})
+}
+
+func (store *pgChunkPixelStore) forEachPixelDrawnBy(ctx context.Context, drawnBy core.UserID, fn func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error) error {
+	if drawnBy.IsAnonymous() {
+		return nil
+	}
+
+	sb := selectChunks([]string{"canvas_id"})
+	sb.Where("drawers @> " + sb.Var(pq.Array([]string{drawnBy.String()})))
+	sb.OrderBy("canvas_id")
+	query, args := sb.Build()
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type drawnPixel struct {
+		pixel   core.Pixel
+		drawnAt time.Time
+	}
+	var canvasID int64
+	var drawn []drawnPixel
+	flush := func() error {
+		sort.SliceStable(drawn, func(i, j int) bool { return drawn[i].drawnAt.Before(drawn[j].drawnAt) })
+		for _, d := range drawn {
+			if err := fn(canvasID, d.pixel, d.drawnAt); err != nil {
+				return err
+			}
+		}
+		drawn = drawn[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var chunkCanvasID int64
+		var key chunkKey
+		chunk, err := scanChunk(rows, []any{&chunkCanvasID, &key.cx, &key.cy})
+		if err != nil {
+			return err
+		}
+		if chunkCanvasID != canvasID {
+			if err := flush(); err != nil {
+				return err
+			}
+			canvasID = chunkCanvasID
+		}
+		for i := 0; i < chunkPixels; i++ {
+			if chunk.drawnBy(i) == drawnBy {
+				point := key.point(i)
+				drawn = append(drawn, drawnPixel{core.NewPixel(point.X, point.Y, chunk.color(i)), chunk.drawnAtTime(i)})
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// AnonymizeDrawer implements PixelStore
+// The chunks are rewritten without touching updated_at, their pixels look the same
+func (store *pgChunkPixelStore) AnonymizeDrawer(ctx context.Context, drawnBy core.UserID) (int64, error) {
+	if drawnBy.IsAnonymous() {
+		return 0, nil
+	}
+
+	count := int64(0)
+	err := store.inTx(ctx, func(store *pgChunkPixelStore) error {
+		count = 0
+
+		sb := selectChunks([]string{"canvas_id"}, "updated_at")
+		sb.Where("drawers @> " + sb.Var(pq.Array([]string{drawnBy.String()})))
+		sb.OrderBy("canvas_id", "cx", "cy")
+		sb.ForUpdate()
+		query, args := sb.Build()
+
+		type lockedChunk struct {
+			canvasID  int64
+			key       chunkKey
+			chunk     *pixelChunk
+			updatedAt time.Time
+		}
+		var locked []lockedChunk
+
+		rows, err := store.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var l lockedChunk
+			l.chunk, err = scanChunk(rows, []any{&l.canvasID, &l.key.cx, &l.key.cy}, &l.updatedAt)
+			if err != nil {
+				return err
+			}
+			locked = append(locked, l)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, l := range locked {
+			count += l.chunk.anonymize(drawnBy)
+			if err := store.saveChunk(ctx, l.canvasID, l.key, l.chunk, l.updatedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// ForEachPixel implements PixelStore
+// Like the row store's, it is bound by neither the query nor the statement timeout
+func (store *pgChunkPixelStore) ForEachPixel(ctx context.Context, canvasID int64, fn func(pixel DrawnPixel) error) error {
+	return withoutStatementTimeout(ctx, store.db, func(db dbtx.DBTx) error {
+		return store.WithTx(db).(*pgChunkPixelStore).forEachPixel(ctx, canvasID, fn)
+	})
+}
+
+func (store *pgChunkPixelStore) forEachPixel(ctx context.Context, canvasID int64, fn func(pixel DrawnPixel) error) error {
+	sb := selectChunks(nil)
+	sb.Where(sb.Equal("canvas_id", canvasID))
+	query, args := sb.Build()
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key chunkKey
+		chunk, err := scanChunk(rows, []any{&key.cx, &key.cy})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < chunkPixels; i++ {
+			if !chunk.isDrawn(i) {
+				continue
+			}
+			point := key.point(i)
+			pixel := DrawnPixel{core.NewPixel(point.X, point.Y, chunk.color(i)), chunk.drawnAtTime(i), chunk.drawnBy(i)}
+			if err := fn(pixel); err != nil {
+				return err
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// RestorePixels implements PixelStore
+func (store *pgChunkPixelStore) RestorePixels(ctx context.Context, canvasID int64, pixels []DrawnPixel) error {
+	if len(pixels) == 0 {
+		return ctx.Err()
+	}
+
+	byChunk := map[chunkKey][]DrawnPixel{}
+	for _, pixel := range pixels {
+		key := chunkKeyOf(pixel.Point)
+		byChunk[key] = append(byChunk[key], pixel)
+	}
+	keys := make([]chunkKey, 0, len(byChunk))
+	for key := range byChunk {
+		keys = append(keys, key)
+	}
+	sortChunkKeys(keys)
+
+	return store.inTx(ctx, func(store *pgChunkPixelStore) error {
+		ctx, cancel := store.withQueryTimeout(ctx)
+		defer cancel()
+
+		chunks, now, err := store.lockChunks(ctx, canvasID, keys)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			chunk := chunks[key]
+			for _, pixel := range byChunk[key] {
+				chunk.set(key.index(pixel.Point), pixel.RGBA, pixel.DrawnAt, pixel.DrawnBy)
+			}
+			chunk.compact()
+			if err := store.saveChunk(ctx, canvasID, key, chunk, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePixels implements PixelStore
+// Like the row store's, it is bound by neither the query nor the statement timeout
+func (store *pgChunkPixelStore) DeletePixels(ctx context.Context, canvasID int64) error {
+	return withoutStatementTimeout(ctx, store.db, func(db dbtx.DBTx) error {
+		return store.WithTx(db).(*pgChunkPixelStore).deletePixels(ctx, canvasID)
+	})
+}
+
+func (store *pgChunkPixelStore) deletePixels(ctx context.Context, canvasID int64) error {
+	db := sqlbuilder.PostgreSQL.NewDeleteBuilder()
+	db.DeleteFrom("pixel_chunks")
+	db.Where(db.Equal("canvas_id", canvasID))
+
+	query, args := db.Build()
+	_, err := store.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func sortChunkKeys(keys []chunkKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].cx != keys[j].cx {
+			return keys[i].cx < keys[j].cx
+		}
+		return keys[i].cy < keys[j].cy
+	})
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lazharichir/draw/core"
+)
+
+// WithoutStatementTimeout runs fn on db without a statement timeout, see withoutStatementTimeout.
+var WithoutStatementTimeout = withoutStatementTimeout
+
+// DrawPixelsByInsert and DrawPixelsByCopy draw pixels with a Postgres store
+// through one path whatever their number, for benchmarks to compare.
+func DrawPixelsByInsert(ctx context.Context, store PixelStore, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	pg := store.(*pgPixelStore)
+	return InTx(ctx, pg.db.(*sql.DB), func(tx *sql.Tx) error {
+		return pg.WithTx(tx).(*pgPixelStore).drawPixelChunks(ctx, canvasID, drawnBy, chunkSlice(lastPixels(pixels), 1000))
+	})
+}
+
+func DrawPixelsByCopy(ctx context.Context, store PixelStore, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	return store.(*pgPixelStore).copyPixels(ctx, canvasID, drawnBy, pixels)
+}
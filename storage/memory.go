@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"context"
+	"image/color"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage/dbtx"
+)
+
+// memoryPixelStore keeps pixels and tile changes in memory, for development and
+// tests. It behaves like the Postgres store, including its quirks, which the
+// conformance suite in storagetest checks.
+type memoryPixelStore struct {
+	mu          sync.RWMutex
+	pixels      map[memoryPixelKey]memoryPixel
+	tilechanges map[tilechangeKey]time.Time
+	marks       map[string]time.Time
+}
+
+type memoryPixelKey struct {
+	canvasID int64
+	x, y     int64
+}
+
+type memoryPixel struct {
+	rgba    color.RGBA
+	drawnAt time.Time
+	drawnBy core.UserID
+}
+
+type tilechangeKey struct {
+	canvasID int64
+	x, y     int64
+	side     int64
+}
+
+func NewMemoryPixelStore() PixelStore {
+	return &memoryPixelStore{
+		pixels:      map[memoryPixelKey]memoryPixel{},
+		tilechanges: map[tilechangeKey]time.Time{},
+		marks:       map[string]time.Time{},
+	}
+}
+
+// pgNow returns the current time as Postgres stores it, in microseconds.
+func pgNow() time.Time {
+	return time.Now().UTC().Round(time.Microsecond)
+}
+
+// WithTx returns the store itself, writes to memory cannot be rolled back.
+func (store *memoryPixelStore) WithTx(tx dbtx.DBTx) PixelStore {
+	return store
+}
+
+func (store *memoryPixelStore) GetLatestPixelsForArea(ctx context.Context, canvasID int64, topLeft core.Point, bottomRight core.Point, after time.Time) ([]core.Pixel, error) {
+	return store.findPixels(ctx, canvasID, topLeft, bottomRight, func(pixel memoryPixel) bool {
+		return pixel.drawnAt.After(after)
+	})
+}
+
+// GetPixelsFromTopLeft includes the pixels at x+width and y+width, like the
+// BETWEEN of the Postgres store.
+func (store *memoryPixelStore) GetPixelsFromTopLeft(ctx context.Context, canvasID, x, y, width int64) ([]core.Pixel, error) {
+	return store.findPixels(ctx, canvasID, core.Pt(x, y), core.Pt(x+width, y+width), func(memoryPixel) bool {
+		return true
+	})
+}
+
+// findPixels returns the pixels between min and max, both inclusive, that match.
+func (store *memoryPixelStore) findPixels(ctx context.Context, canvasID int64, min, max core.Point, match func(memoryPixel) bool) ([]core.Pixel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var pixels []core.Pixel
+	for key, pixel := range store.pixels {
+		if key.canvasID != canvasID || key.x < min.X || key.x > max.X || key.y < min.Y || key.y > max.Y {
+			continue
+		}
+		if match(pixel) {
+			pixels = append(pixels, core.NewPixel(key.x, key.y, pixel.rgba))
+		}
+	}
+
+	sort.Slice(pixels, func(i, j int) bool {
+		if pixels[i].Y != pixels[j].Y {
+			return pixels[i].Y < pixels[j].Y
+		}
+		return pixels[i].X < pixels[j].X
+	})
+	return pixels, nil
+}
+
+func (store *memoryPixelStore) DrawPixelRGBA(ctx context.Context, canvasID int64, drawnBy core.UserID, x, y int64, color color.RGBA) error {
+	return store.DrawPixels(ctx, canvasID, drawnBy, []core.Pixel{core.NewPixel(x, y, color)})
+}
+
+func (store *memoryPixelStore) DrawPixels(ctx context.Context, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := pgNow()
+	for _, pixel := range pixels {
+		store.pixels[memoryPixelKey{canvasID, pixel.X, pixel.Y}] = memoryPixel{rgba: pixel.RGBA, drawnAt: now, drawnBy: drawnBy}
+	}
+	return nil
+}
+
+func (store *memoryPixelStore) ErasePixel(ctx context.Context, canvasID int64, erasedBy core.UserID, x, y int64) error {
+	return store.DrawPixelRGBA(ctx, canvasID, erasedBy, x, y, color.RGBA{})
+}
+
+func (store *memoryPixelStore) SetLastChangedForAreas(ctx context.Context, canvasID int64, side int64, areas ...core.Area) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := pgNow()
+	for _, area := range areas {
+		store.tilechanges[tilechangeKey{canvasID, area.Min.X, area.Min.Y, side}] = now
+	}
+	return nil
+}
+
+func (store *memoryPixelStore) SetLastChangedForPoints(ctx context.Context, canvasID int64, side int64, points ...core.Point) error {
+	changedAreas := core.GetTileAreasFromPoints(side, points...)
+	if len(changedAreas) == 0 {
+		return nil
+	}
+	return store.SetLastChangedForAreas(ctx, canvasID, side, changedAreas...)
+}
+
+func (store *memoryPixelStore) DeleteLastChangedForAreas(ctx context.Context, canvasID int64, side int64, areas ...core.Area) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key := range store.tilechanges {
+		if key.canvasID != canvasID || key.side != side {
+			continue
+		}
+		for _, area := range areas {
+			if key.x >= area.Min.X && key.x < area.Max.X && key.y >= area.Min.Y && key.y < area.Max.Y {
+				delete(store.tilechanges, key)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (store *memoryPixelStore) FindRecentlyChangedAreasBetweenDates(ctx context.Context, from, to time.Time) (map[int64][]core.Area, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// ensure from < to
+	if from.After(to) {
+		from, to = to, from
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	areas := map[int64][]core.Area{}
+	for key, lastChanged := range store.tilechanges {
+		if lastChanged.Before(from) || lastChanged.After(to) {
+			continue
+		}
+		item := tilechange{CanvasID: key.canvasID, X: key.x, Y: key.y, Side: key.side, LastChanged: lastChanged}
+		areas[key.canvasID] = append(areas[key.canvasID], item.Area())
+	}
+	return areas, nil
+}
+
+func (store *memoryPixelStore) GetHighWaterMark(ctx context.Context, name string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.marks[name], nil
+}
+
+func (store *memoryPixelStore) SetHighWaterMark(ctx context.Context, name string, mark time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.marks[name] = mark.UTC().Round(time.Microsecond)
+	return nil
+}
+
+func (store *memoryPixelStore) ForEachPixelDrawnBy(ctx context.Context, drawnBy core.UserID, fn func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error) error {
+	// anonymous pixels are drawn by NULL, which equals no one
+	if drawnBy.IsAnonymous() {
+		return nil
+	}
+
+	type drawn struct {
+		canvasID int64
+		pixel    core.Pixel
+		drawnAt  time.Time
+	}
+
+	// fn is called without the lock, so that it may use the store
+	store.mu.RLock()
+	var pixels []drawn
+	for key, pixel := range store.pixels {
+		if pixel.drawnBy == drawnBy {
+			pixels = append(pixels, drawn{key.canvasID, core.NewPixel(key.x, key.y, pixel.rgba), pixel.drawnAt})
+		}
+	}
+	store.mu.RUnlock()
+
+	sort.Slice(pixels, func(i, j int) bool {
+		if pixels[i].canvasID != pixels[j].canvasID {
+			return pixels[i].canvasID < pixels[j].canvasID
+		}
+		return pixels[i].drawnAt.Before(pixels[j].drawnAt)
+	})
+
+	for _, p := range pixels {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(p.canvasID, p.pixel, p.drawnAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *memoryPixelStore) AnonymizeDrawer(ctx context.Context, drawnBy core.UserID) (int64, error) {
+	if drawnBy.IsAnonymous() {
+		return 0, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var count int64
+	for key, pixel := range store.pixels {
+		if pixel.drawnBy == drawnBy {
+			pixel.drawnBy = ""
+			store.pixels[key] = pixel
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (store *memoryPixelStore) ForEachPixel(ctx context.Context, canvasID int64, fn func(pixel DrawnPixel) error) error {
+	// fn is called without the lock, so that it may use the store
+	store.mu.RLock()
+	var pixels []DrawnPixel
+	for key, pixel := range store.pixels {
+		if key.canvasID == canvasID {
+			pixels = append(pixels, DrawnPixel{core.NewPixel(key.x, key.y, pixel.rgba), pixel.drawnAt, pixel.drawnBy})
+		}
+	}
+	store.mu.RUnlock()
+
+	for _, pixel := range pixels {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(pixel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *memoryPixelStore) RestorePixels(ctx context.Context, canvasID int64, pixels []DrawnPixel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, pixel := range pixels {
+		store.pixels[memoryPixelKey{canvasID, pixel.X, pixel.Y}] = memoryPixel{rgba: pixel.RGBA, drawnAt: pixel.DrawnAt.UTC().Round(time.Microsecond), drawnBy: pixel.DrawnBy}
+	}
+	return nil
+}
+
+func (store *memoryPixelStore) DeletePixels(ctx context.Context, canvasID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for key := range store.pixels {
+		if key.canvasID == canvasID {
+			delete(store.pixels, key)
+		}
+	}
+	return nil
+}
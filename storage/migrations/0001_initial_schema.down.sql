@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS user_credentials;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS verification_tokens;
+DROP TABLE IF EXISTS user_profiles;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS leases;
+DROP TABLE IF EXISTS highwatermarks;
+DROP TABLE IF EXISTS tilechanges;
+DROP TABLE IF EXISTS pixels;
@@ -0,0 +1,120 @@
+-- The tables the app was first built on. Everything is created only if missing,
+-- so databases set up by hand before migrations existed can be migrated as is.
+-- Their integer user columns are converted to text by 0003.
+
+CREATE TABLE IF NOT EXISTS pixels (
+	canvas_id bigint NOT NULL,
+	x bigint NOT NULL,
+	y bigint NOT NULL,
+	r smallint NOT NULL,
+	g smallint NOT NULL,
+	b smallint NOT NULL,
+	a smallint NOT NULL,
+	drawn_at timestamptz NOT NULL DEFAULT now(),
+	drawn_by text,
+	PRIMARY KEY (canvas_id, x, y)
+);
+
+CREATE INDEX IF NOT EXISTS pixels_drawn_at_idx ON pixels (canvas_id, drawn_at);
+CREATE INDEX IF NOT EXISTS pixels_drawn_by_idx ON pixels (drawn_by);
+
+-- Tiles changed since they were last precached.
+CREATE TABLE IF NOT EXISTS tilechanges (
+	canvas_id bigint NOT NULL,
+	x bigint NOT NULL,
+	y bigint NOT NULL,
+	side bigint NOT NULL,
+	last_changed timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (canvas_id, x, y, side)
+);
+
+CREATE INDEX IF NOT EXISTS tilechanges_last_changed_idx ON tilechanges (last_changed);
+
+-- How far background jobs got, e.g. the precache worker.
+CREATE TABLE IF NOT EXISTS highwatermarks (
+	name text PRIMARY KEY,
+	mark timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS leases (
+	id text PRIMARY KEY,
+	leaseholder_id text NOT NULL,
+	canvas_id bigint NOT NULL,
+	tl_x bigint NOT NULL,
+	tl_y bigint NOT NULL,
+	br_x bigint NOT NULL,
+	br_y bigint NOT NULL,
+	width bigint NOT NULL,
+	height bigint NOT NULL,
+	status text NOT NULL,
+	"start" timestamptz NOT NULL,
+	"end" timestamptz NOT NULL,
+	price bigint NOT NULL DEFAULT 0,
+	metadata jsonb NOT NULL DEFAULT '{}',
+	updated_at timestamptz NOT NULL,
+	updated_by text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL,
+	created_by text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS leases_canvas_id_idx ON leases (canvas_id, tl_x, tl_y);
+CREATE INDEX IF NOT EXISTS leases_leaseholder_id_idx ON leases (leaseholder_id);
+
+CREATE TABLE IF NOT EXISTS users (
+	id text PRIMARY KEY,
+	status text NOT NULL,
+	username text NOT NULL,
+	email text NOT NULL,
+	created_at timestamptz NOT NULL,
+	last_signed_in_at timestamptz NOT NULL,
+	last_drawn_pixel_at timestamptz NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (username);
+
+CREATE TABLE IF NOT EXISTS user_profiles (
+	user_id text PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	first_name text NOT NULL DEFAULT '',
+	last_name text NOT NULL DEFAULT '',
+	gender text NOT NULL DEFAULT '',
+	dob timestamptz NOT NULL DEFAULT '0001-01-01 00:00:00+00',
+	bio text NOT NULL DEFAULT '',
+	website_url text NOT NULL DEFAULT '',
+	facebook_url text NOT NULL DEFAULT '',
+	twitter_url text NOT NULL DEFAULT '',
+	instagram_url text NOT NULL DEFAULT '',
+	linkedin_url text NOT NULL DEFAULT '',
+	tiktok_url text NOT NULL DEFAULT '',
+	youtube_url text NOT NULL DEFAULT ''
+);
+
+-- Single-use tokens sent by email, to sign in, reset a password or change an email address.
+CREATE TABLE IF NOT EXISTS verification_tokens (
+	token text PRIMARY KEY,
+	kind text NOT NULL,
+	user_id text NOT NULL,
+	email text,
+	created_at timestamptz NOT NULL,
+	expires_at timestamptz NOT NULL,
+	used_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS verification_tokens_user_id_idx ON verification_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token text PRIMARY KEY,
+	user_id text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at timestamptz NOT NULL,
+	expires_at timestamptz NOT NULL,
+	revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS user_credentials (
+	user_id text PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	password_hash bytea NOT NULL,
+	failed_attempts integer NOT NULL DEFAULT 0,
+	locked_until timestamptz,
+	updated_at timestamptz NOT NULL
+);
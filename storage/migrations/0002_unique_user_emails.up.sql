@@ -0,0 +1,5 @@
+-- Enforces one account per email address. Emails are stored normalized
+-- (trimmed and lowercased, see core.NormalizeEmail), so a plain unique index
+-- is enough. Fails if duplicates already exist; resolve them first with:
+--   SELECT email, array_agg(id) FROM users GROUP BY email HAVING count(*) > 1;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email);
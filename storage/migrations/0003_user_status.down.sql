@@ -0,0 +1,4 @@
+ALTER TABLE users
+	DROP COLUMN IF EXISTS status_reason,
+	DROP COLUMN IF EXISTS status_until,
+	DROP COLUMN IF EXISTS pixels_drawn;
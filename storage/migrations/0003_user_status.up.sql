@@ -0,0 +1,46 @@
+-- Adds what user status enforcement needs: why and until when a user is
+-- suspended or banned, and how many pixels they drew towards leaving probation.
+
+-- Databases set up before user IDs were strings (see 0001) still have integer
+-- user columns, converted here before anything compares them with users.id.
+-- Integer IDs never referred to actual users: anonymous ones (0) become NULL or
+-- empty, others are prefixed with "legacy_" so that they never match a real
+-- user but can still be mapped by hand later on.
+DO $$
+BEGIN
+	IF (SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'pixels' AND column_name = 'drawn_by') <> 'text' THEN
+		ALTER TABLE pixels ALTER COLUMN drawn_by DROP DEFAULT;
+		ALTER TABLE pixels ALTER COLUMN drawn_by DROP NOT NULL;
+		ALTER TABLE pixels ALTER COLUMN drawn_by TYPE text
+			USING CASE WHEN drawn_by = 0 THEN NULL ELSE 'legacy_' || drawn_by::text END;
+	END IF;
+
+	IF (SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'leases' AND column_name = 'leaseholder_id') <> 'text' THEN
+		ALTER TABLE leases ALTER COLUMN leaseholder_id TYPE text
+			USING 'legacy_' || leaseholder_id::text;
+	END IF;
+
+	IF (SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'leases' AND column_name = 'created_by') <> 'text' THEN
+		ALTER TABLE leases ALTER COLUMN created_by TYPE text
+			USING CASE WHEN created_by = 0 THEN '' ELSE 'legacy_' || created_by::text END;
+	END IF;
+
+	IF (SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'leases' AND column_name = 'updated_by') <> 'text' THEN
+		ALTER TABLE leases ALTER COLUMN updated_by TYPE text
+			USING CASE WHEN updated_by = 0 THEN '' ELSE 'legacy_' || updated_by::text END;
+	END IF;
+END
+$$;
+
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS status_reason text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS status_until timestamptz,
+	ADD COLUMN IF NOT EXISTS pixels_drawn bigint NOT NULL DEFAULT 0;
+
+UPDATE users SET pixels_drawn = counts.total
+FROM (SELECT drawn_by, count(*) AS total FROM pixels WHERE drawn_by IS NOT NULL GROUP BY drawn_by) AS counts
+WHERE users.id = counts.drawn_by;
@@ -0,0 +1,10 @@
+-- Roles granted to users, globally (canvas_id NULL) or on a single canvas.
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	role text NOT NULL,
+	canvas_id bigint,
+	granted_at timestamptz NOT NULL DEFAULT now(),
+	granted_by text
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_roles_grant_key ON user_roles (user_id, role, coalesce(canvas_id, -1));
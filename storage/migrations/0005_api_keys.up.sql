@@ -0,0 +1,14 @@
+-- API keys users create for their bots. Only a sha256 hash of the secret is kept.
+CREATE TABLE IF NOT EXISTS api_keys (
+	id text PRIMARY KEY,
+	user_id text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name text NOT NULL,
+	secret_hash bytea NOT NULL,
+	scopes text[] NOT NULL,
+	rate_limit_per_minute integer NOT NULL,
+	created_at timestamptz NOT NULL,
+	last_used_at timestamptz,
+	revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
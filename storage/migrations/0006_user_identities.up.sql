@@ -0,0 +1,12 @@
+-- Links users to their accounts at external OpenID Connect providers.
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider text NOT NULL,
+	subject text NOT NULL,
+	user_id text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	email text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL,
+	last_signed_in_at timestamptz NOT NULL,
+	PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
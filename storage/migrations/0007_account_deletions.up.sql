@@ -0,0 +1,11 @@
+-- Users' requests to delete their accounts, processed once their cooling-off period is over.
+CREATE TABLE IF NOT EXISTS account_deletions (
+	user_id text PRIMARY KEY REFERENCES users (id),
+	transfer_leases_to text REFERENCES users (id),
+	requested_at timestamptz NOT NULL,
+	scheduled_for timestamptz NOT NULL,
+	canceled_at timestamptz,
+	completed_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS account_deletions_due_idx ON account_deletions (scheduled_for) WHERE canceled_at IS NULL AND completed_at IS NULL;
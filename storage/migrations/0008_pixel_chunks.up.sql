@@ -0,0 +1,18 @@
+-- Pixels kept by square chunks of 64x64 rather than one row each, for the chunked pixel store.
+-- Each chunk stores its pixels row by row: rgba holds 4 bytes per pixel, drawn_at 8 bytes
+-- (big-endian unix microseconds, 0 if never drawn) and drawer_idx 2 bytes (big-endian,
+-- 1 + the index in drawers of who drew the pixel, 0 if drawn anonymously).
+CREATE TABLE IF NOT EXISTS pixel_chunks (
+	canvas_id bigint NOT NULL,
+	cx bigint NOT NULL,
+	cy bigint NOT NULL,
+	rgba bytea NOT NULL,
+	drawn_at bytea NOT NULL,
+	drawer_idx bytea NOT NULL,
+	drawers text[] NOT NULL DEFAULT '{}',
+	updated_at timestamptz NOT NULL,
+	PRIMARY KEY (canvas_id, cx, cy)
+);
+
+CREATE INDEX IF NOT EXISTS pixel_chunks_updated_at_idx ON pixel_chunks (canvas_id, updated_at);
+CREATE INDEX IF NOT EXISTS pixel_chunks_drawers_idx ON pixel_chunks USING gin (drawers);
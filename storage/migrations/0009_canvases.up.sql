@@ -0,0 +1,9 @@
+-- The settings of the canvases that do not use the server's defaults, e.g.
+-- restored from a snapshot. Canvases without a row use TILE_SIDES and MAX_TILE_SIDE.
+CREATE TABLE IF NOT EXISTS canvases (
+	id bigint PRIMARY KEY,
+	tile_sides bigint[] NOT NULL,
+	max_tile_side bigint NOT NULL,
+	tile_formats text[] NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+ALTER TABLE account_deletions DROP COLUMN IF EXISTS last_error;
+ALTER TABLE account_deletions DROP COLUMN IF EXISTS last_attempt_at;
+ALTER TABLE account_deletions DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,5 @@
+-- Failed attempts at deleting an account, so that a deletion failing every run
+-- is noticed and goes after the others.
+ALTER TABLE account_deletions ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE account_deletions ADD COLUMN IF NOT EXISTS last_attempt_at timestamptz;
+ALTER TABLE account_deletions ADD COLUMN IF NOT EXISTS last_error text NOT NULL DEFAULT '';
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	// versions are contiguous so that gaps from botched merges are noticed
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version, m.Name)
+		assert.NotEmpty(t, m.Up, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"0001_a.up.sql": {Data: []byte("SELECT 1")},
+		},
+		"two names": {
+			"0001_a.up.sql":   {Data: []byte("SELECT 1")},
+			"0001_b.down.sql": {Data: []byte("SELECT 1")},
+		},
+		"unexpected file": {
+			"0001_a.up.sql":   {Data: []byte("SELECT 1")},
+			"0001_a.down.sql": {Data: []byte("SELECT 1")},
+			"notes.txt":       {Data: []byte("hello")},
+		},
+	}
+	for name, fsys := range cases {
+		_, err := load(fsys)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoad_Order(t *testing.T) {
+	migrations, err := load(fstest.MapFS{
+		"0010_b.up.sql":   {Data: []byte("SELECT 10")},
+		"0010_b.down.sql": {Data: []byte("SELECT -10")},
+		"0002_a.up.sql":   {Data: []byte("SELECT 2")},
+		"0002_a.down.sql": {Data: []byte("SELECT -2")},
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, Migration{Version: 2, Name: "a", Up: "SELECT 2", Down: "SELECT -2"}, migrations[0])
+	assert.Equal(t, int64(10), migrations[1].Version)
+}
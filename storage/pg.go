@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lazharichir/draw/storage/dbtx"
+)
+
+// PGConfig configures the connection pool to Postgres.
+type PGConfig struct {
+	// DSN is a lib/pq connection string, as key=value pairs or a postgres:// URL.
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// StatementTimeout aborts any statement running longer, zero means no timeout.
+	StatementTimeout time.Duration
+	// QueryTimeout bounds each query of the stores given it, including the wait
+	// for a connection, zero means no timeout.
+	QueryTimeout time.Duration
+	// ConnectAttempts is how many times OpenPG pings before giving up, backing
+	// off exponentially from ConnectBackoff between attempts.
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+}
+
+// DefaultPGConfig connects to a local draw database.
+func DefaultPGConfig() PGConfig {
+	return PGConfig{
+		DSN:              "user=postgres dbname=draw sslmode=disable",
+		MaxOpenConns:     25,
+		MaxIdleConns:     25,
+		ConnMaxLifetime:  30 * time.Minute,
+		ConnMaxIdleTime:  5 * time.Minute,
+		StatementTimeout: 30 * time.Second,
+		QueryTimeout:     15 * time.Second,
+		ConnectAttempts:  5,
+		ConnectBackoff:   500 * time.Millisecond,
+	}
+}
+
+// maxConnectBackoff caps the wait between two connection attempts.
+const maxConnectBackoff = 10 * time.Second
+
+// OpenPG opens the connection pool and waits until Postgres answers, so that
+// servers started along with their database don't fail on the first query.
+func OpenPG(ctx context.Context, config PGConfig) (*sql.DB, error) {
+	dsn, err := withStatementTimeout(config.DSN, config.StatementTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPG: %w", err)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPG: %w", err)
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	attempts := max(config.ConnectAttempts, 1)
+	backoff := config.ConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		fmt.Printf("OpenPG: attempt %d/%d failed, retrying in %s: %v\n", attempt, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("OpenPG: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+
+	db.Close()
+	return nil, fmt.Errorf("OpenPG: could not connect after %d attempts: %w", attempts, err)
+}
+
+// withoutStatementTimeout runs fn on db with the statement_timeout lifted, for
+// the statements that take as long as their data or their reader does, e.g.
+// streams to a slow client. If db is a transaction it stays lifted until the
+// transaction ends, otherwise fn runs in a transaction of its own.
+func withoutStatementTimeout(ctx context.Context, db dbtx.DBTx, fn func(db dbtx.DBTx) error) error {
+	if pool, ok := db.(*sql.DB); ok {
+		return runTx(ctx, pool, func(tx *sql.Tx) error {
+			return withoutStatementTimeout(ctx, tx, fn)
+		})
+	}
+
+	if _, err := db.ExecContext(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+		return err
+	}
+	return fn(db)
+}
+
+// withStatementTimeout sets the statement_timeout run-time parameter in the DSN,
+// unless the DSN already sets one.
+func withStatementTimeout(dsn string, timeout time.Duration) (string, error) {
+	if timeout <= 0 || strings.Contains(dsn, "statement_timeout") {
+		return dsn, nil
+	}
+	ms := strconv.FormatInt(timeout.Milliseconds(), 10)
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("statement_timeout", ms)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	return strings.TrimSpace(dsn + " statement_timeout=" + ms), nil
+}
+
+// PublishPoolStats exposes the pool's sql.DBStats under name in expvar, for
+// monitoring to scrape.
+func PublishPoolStats(name string, db *sql.DB) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return db.Stats()
+	}))
+}
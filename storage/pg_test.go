@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithStatementTimeout(t *testing.T) {
+	cases := []struct {
+		dsn     string
+		timeout time.Duration
+		want    string
+	}{
+		{"user=postgres dbname=draw", 5 * time.Second, "user=postgres dbname=draw statement_timeout=5000"},
+		{"postgres://u:p@db:5432/draw?sslmode=disable", time.Second, "postgres://u:p@db:5432/draw?sslmode=disable&statement_timeout=1000"},
+		{"user=postgres statement_timeout=100", time.Second, "user=postgres statement_timeout=100"},
+		{"user=postgres", 0, "user=postgres"},
+	}
+	for _, c := range cases {
+		got, err := withStatementTimeout(c.dsn, c.timeout)
+		require.NoError(t, err)
+		assert.Equal(t, c.want, got)
+	}
+}
+
+func TestOpenPG_GivesUp(t *testing.T) {
+	config := DefaultPGConfig()
+	config.DSN = "host=127.0.0.1 port=1 user=nobody sslmode=disable connect_timeout=1"
+	config.ConnectAttempts = 2
+	config.ConnectBackoff = time.Millisecond
+
+	_, err := OpenPG(context.Background(), config)
+	assert.Error(t, err)
+}
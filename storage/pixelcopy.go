@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lib/pq"
+)
+
+// copyPixelsThreshold is the number of pixels from which DrawPixels streams them
+// through COPY rather than inserting them 1000 at a time. Below it, creating the
+// staging table costs more than it saves.
+const copyPixelsThreshold = 5000
+
+// copyPixels streams the pixels into a staging table with COPY, then merges them
+// into pixels in a single statement. The staging table lives as long as the
+// transaction, so the pixels are drawn in one.
+func (store *pgPixelStore) copyPixels(ctx context.Context, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	switch db := store.db.(type) {
+	case *sql.Tx:
+		return store.copyPixelsInTx(ctx, db, canvasID, drawnBy, pixels)
+	case *sql.DB:
+		return InTx(ctx, db, func(tx *sql.Tx) error {
+			return store.copyPixelsInTx(ctx, tx, canvasID, drawnBy, pixels)
+		})
+	default:
+		return store.drawPixelChunks(ctx, canvasID, drawnBy, chunkSlice(lastPixels(pixels), 1000))
+	}
+}
+
+func (store *pgPixelStore) copyPixelsInTx(ctx context.Context, tx *sql.Tx, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
+	// the table may already exist if pixels were copied earlier in the transaction
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TEMPORARY TABLE IF NOT EXISTS pixels_staging (
+			seq bigint NOT NULL,
+			x bigint NOT NULL,
+			y bigint NOT NULL,
+			r smallint NOT NULL,
+			g smallint NOT NULL,
+			b smallint NOT NULL,
+			a smallint NOT NULL
+		) ON COMMIT DROP
+	`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `TRUNCATE pixels_staging`); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("pixels_staging", "seq", "x", "y", "r", "g", "b", "a"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, pixel := range pixels {
+		if _, err := stmt.ExecContext(ctx, i, pixel.X, pixel.Y, pixel.RGBA.R, pixel.RGBA.G, pixel.RGBA.B, pixel.RGBA.A); err != nil {
+			return err
+		}
+	}
+	// flushes the rows buffered by the driver and ends the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+
+	// a pixel drawn twice keeps its last color, as if the pixels were drawn in order,
+	// and an upsert may not touch the same row twice anyway
+	drawer := sql.NullString{String: drawnBy.String(), Valid: !drawnBy.IsAnonymous()}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO pixels (canvas_id, x, y, r, g, b, a, drawn_at, drawn_by)
+		SELECT DISTINCT ON (x, y) $1::bigint, x, y, r, g, b, a, NOW(), $2::text
+		FROM pixels_staging
+		ORDER BY x, y, seq DESC
+		ON CONFLICT (canvas_id, x, y) DO UPDATE SET r = EXCLUDED.r, g = EXCLUDED.g, b = EXCLUDED.b, a = EXCLUDED.a, drawn_at = EXCLUDED.drawn_at, drawn_by = EXCLUDED.drawn_by
+	`, canvasID, drawer)
+	return err
+}
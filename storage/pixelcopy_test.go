@@ -0,0 +1,55 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/storagetest"
+)
+
+// BenchmarkDrawPixels compares drawing images with multi-row inserts and with
+// COPY, e.g. go test ./storage -run '^$' -bench DrawPixels
+func BenchmarkDrawPixels(b *testing.B) {
+	db := storagetest.DB(b)
+	store := storage.NewPGPixelStore(db, nil, 0)
+	ctx := context.Background()
+
+	paths := []struct {
+		name string
+		draw func(ctx context.Context, store storage.PixelStore, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error
+	}{
+		{"insert", storage.DrawPixelsByInsert},
+		{"copy", storage.DrawPixelsByCopy},
+	}
+
+	for _, side := range []int64{64, 256, 1024} {
+		pixels := make([]core.Pixel, 0, side*side)
+		for x := int64(0); x < side; x++ {
+			for y := int64(0); y < side; y++ {
+				pixels = append(pixels, core.NewPixel(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 255}))
+			}
+		}
+
+		for _, path := range paths {
+			b.Run(fmt.Sprintf("%s/%dx%d", path.name, side, side), func(b *testing.B) {
+				canvasID := rand.Int63()
+				b.Cleanup(func() {
+					db.Exec(`DELETE FROM pixels WHERE canvas_id = $1`, canvasID)
+				})
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := path.draw(ctx, store, canvasID, "", pixels); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(pixels)*b.N)/b.Elapsed().Seconds(), "pixels/s")
+			})
+		}
+	}
+}
@@ -9,100 +9,140 @@ import (
 
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage/dbtx"
 	_ "github.com/lib/pq"
 	"golang.org/x/exp/slog"
 )
 
 type PixelStore interface {
-	GetLatestPixelsForArea(canvasID int64, topLeft core.Point, bottomRight core.Point, after time.Time) ([]core.Pixel, error)
-	GetPixelsFromTopLeft(canvasID, x, y, z int64) ([]core.Pixel, error)
-	DrawPixelRGBA(canvasID, x, y int64, color color.RGBA) error
-	DrawPixels(canvasID int64, pixels []core.Pixel) error
-	ErasePixel(canvasID, x, y int64) error
+	GetLatestPixelsForArea(ctx context.Context, canvasID int64, topLeft core.Point, bottomRight core.Point, after time.Time) ([]core.Pixel, error)
+	GetPixelsFromTopLeft(ctx context.Context, canvasID, x, y, z int64) ([]core.Pixel, error)
+	DrawPixelRGBA(ctx context.Context, canvasID int64, drawnBy core.UserID, x, y int64, color color.RGBA) error
+	DrawPixels(ctx context.Context, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error
+	ErasePixel(ctx context.Context, canvasID int64, erasedBy core.UserID, x, y int64) error
 
-	//
 	SetLastChangedForAreas(ctx context.Context, canvasID int64, side int64, areas ...core.Area) error
 	SetLastChangedForPoints(ctx context.Context, canvasID int64, side int64, points ...core.Point) error
 	DeleteLastChangedForAreas(ctx context.Context, canvasID int64, side int64, areas ...core.Area) error
 	FindRecentlyChangedAreasBetweenDates(ctx context.Context, from, to time.Time) (map[int64][]core.Area, error)
+	GetHighWaterMark(ctx context.Context, name string) (time.Time, error)
+	SetHighWaterMark(ctx context.Context, name string, mark time.Time) error
+
+	// WithTx returns the store running its queries in tx, see InTx.
+	WithTx(tx dbtx.DBTx) PixelStore
+
+	ForEachPixelDrawnBy(ctx context.Context, drawnBy core.UserID, fn func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error) error
+	AnonymizeDrawer(ctx context.Context, drawnBy core.UserID) (int64, error)
+
+	// ForEachPixel calls fn with every pixel of the canvas, in no particular order.
+	ForEachPixel(ctx context.Context, canvasID int64, fn func(pixel DrawnPixel) error) error
+	// RestorePixels draws the pixels as they were drawn, when and by whom they
+	// were, e.g. from a snapshot. The pixels must be at distinct points.
+	RestorePixels(ctx context.Context, canvasID int64, pixels []DrawnPixel) error
+	// DeletePixels deletes every pixel of the canvas, leaving its tile changes.
+	DeletePixels(ctx context.Context, canvasID int64) error
+}
+
+// DrawnPixel is a pixel along with when and by whom it was last drawn.
+type DrawnPixel struct {
+	core.Pixel
+	DrawnAt time.Time
+	DrawnBy core.UserID
 }
 
 type pgPixelStore struct {
-	db  *sql.DB
+	db  dbtx.DBTx
 	log *slog.Logger
+	// queryTimeout bounds each query on top of the caller's context, zero means no bound.
+	queryTimeout time.Duration
 }
 
-func NewPGPixelStore(db *sql.DB, log *slog.Logger) PixelStore {
-	return &pgPixelStore{db, log}
+func NewPGPixelStore(db *sql.DB, log *slog.Logger, queryTimeout time.Duration) PixelStore {
+	return &pgPixelStore{db, log, queryTimeout}
 }
 
-// ErasePixel implements PixelStore
-// It deletes a pixel from the database
-func (store *pgPixelStore) ErasePixel(canvasID int64, x int64, y int64) error {
-
-	db := sqlbuilder.PostgreSQL.NewDeleteBuilder()
-	db.DeleteFrom("pixels")
-	db.Where(
-		db.And(
-			db.Equal("canvas_id", canvasID),
-			db.Equal("x", x),
-			db.Equal("y", y),
-		),
-	)
-
-	query, args := db.Build()
+func (store *pgPixelStore) WithTx(tx dbtx.DBTx) PixelStore {
+	return &pgPixelStore{tx, store.log, store.queryTimeout}
+}
 
-	_, err := store.db.Exec(query, args...)
-	if err != nil {
-		return err
+// withQueryTimeout bounds ctx by the store's query timeout, which unlike the
+// server's statement_timeout also covers waiting for a connection from the pool.
+func (store *pgPixelStore) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if store.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, store.queryTimeout)
+}
 
-	return nil
+// ErasePixel implements PixelStore
+// It overwrites the pixel with a fully transparent one rather than deleting its
+// row, so that erasures show up in GetLatestPixelsForArea like any other change
+func (store *pgPixelStore) ErasePixel(ctx context.Context, canvasID int64, erasedBy core.UserID, x int64, y int64) error {
+	return store.DrawPixelRGBA(ctx, canvasID, erasedBy, x, y, color.RGBA{})
 }
 
 // DrawPixelRGBA implements PixelStore
 // It upserts a pixel in the database
-func (store *pgPixelStore) DrawPixelRGBA(canvasID int64, x int64, y int64, color color.RGBA) error {
-	return store.DrawPixels(canvasID, []core.Pixel{
+func (store *pgPixelStore) DrawPixelRGBA(ctx context.Context, canvasID int64, drawnBy core.UserID, x int64, y int64, color color.RGBA) error {
+	return store.DrawPixels(ctx, canvasID, drawnBy, []core.Pixel{
 		core.NewPixel(x, y, color),
 	})
 }
 
-// DrawPixelRGBA implements PixelStore
-// It upserts a pixel in the database
-func (store *pgPixelStore) DrawPixels(canvasID int64, pixels []core.Pixel) error {
-	chunks := chunkSlice(pixels, 1000)
+// DrawPixels implements PixelStore
+// It upserts pixels in the database, recording who drew them (NULL for anonymous).
+// The pixels are drawn in a single transaction, so that a failure halfway through
+// an image leaves none of it behind, and a pixel given twice keeps its last color.
+// Large batches are streamed through COPY, see copyPixels
+func (store *pgPixelStore) DrawPixels(ctx context.Context, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	if len(pixels) >= copyPixelsThreshold {
+		return store.copyPixels(ctx, canvasID, drawnBy, pixels)
+	}
+
+	chunks := chunkSlice(lastPixels(pixels), 1000)
+
+	db, ok := store.db.(*sql.DB)
+	if !ok || len(chunks) <= 1 {
+		return store.drawPixelChunks(ctx, canvasID, drawnBy, chunks)
+	}
+	return InTx(ctx, db, func(tx *sql.Tx) error {
+		return store.WithTx(tx).(*pgPixelStore).drawPixelChunks(ctx, canvasID, drawnBy, chunks)
+	})
+}
+
+func (store *pgPixelStore) drawPixelChunks(ctx context.Context, canvasID int64, drawnBy core.UserID, chunks [][]core.Pixel) error {
 	for _, chunk := range chunks {
-		if err := store.drawPixelChunk(canvasID, chunk); err != nil {
+		if err := store.drawPixelChunk(ctx, canvasID, drawnBy, chunk); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (store *pgPixelStore) drawPixelChunk(canvasID int64, pixels []core.Pixel) error {
+func (store *pgPixelStore) drawPixelChunk(ctx context.Context, canvasID int64, drawnBy core.UserID, pixels []core.Pixel) error {
+	drawer := sql.NullString{String: drawnBy.String(), Valid: !drawnBy.IsAnonymous()}
+
 	sb := sqlbuilder.PostgreSQL.NewInsertBuilder()
 	sb.InsertInto("pixels")
 	sb.Cols("canvas_id", "x", "y", "r", "g", "b", "a", "drawn_at", "drawn_by")
 
 	for _, pixel := range pixels {
-		sb.Values(canvasID, pixel.X, pixel.Y, pixel.RGBA.R, pixel.RGBA.G, pixel.RGBA.B, pixel.RGBA.A, "NOW()", 0)
+		sb.Values(canvasID, pixel.X, pixel.Y, pixel.RGBA.R, pixel.RGBA.G, pixel.RGBA.B, pixel.RGBA.A, "NOW()", drawer)
 	}
 
 	sb.SQL("ON CONFLICT (canvas_id, x, y) DO UPDATE SET r = EXCLUDED.r, g = EXCLUDED.g, b = EXCLUDED.b, a = EXCLUDED.a, drawn_at = EXCLUDED.drawn_at, drawn_by = EXCLUDED.drawn_by")
 
 	query, args := sb.Build()
 
-	_, err := store.db.Exec(query, args...)
-	if err != nil {
-		return err
-	}
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
 
-	return nil
+	_, err := store.db.ExecContext(ctx, query, args...)
+	return err
 }
 
 // GetPixels implements PixelStore
-func (store *pgPixelStore) GetLatestPixelsForArea(canvasID int64, topLeft core.Point, bottomRight core.Point, after time.Time) ([]core.Pixel, error) {
+func (store *pgPixelStore) GetLatestPixelsForArea(ctx context.Context, canvasID int64, topLeft core.Point, bottomRight core.Point, after time.Time) ([]core.Pixel, error) {
 	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
 	sb.Select("x", "y", "r", "g", "b", "a")
 	sb.From("pixels")
@@ -116,30 +156,12 @@ func (store *pgPixelStore) GetLatestPixelsForArea(canvasID int64, topLeft core.P
 	)
 
 	query, args := sb.Build()
-	// fmt.Println(`query`, query)
-	// fmt.Printf("args %+#v \n", args)
-
-	rows, err := store.db.Query(query, args...)
-	if err != nil {
-		return nil, err
-	}
-
-	var pixels []core.Pixel
-
-	for rows.Next() {
-		var pixel core.Pixel
-		err := rows.Scan(&pixel.X, &pixel.Y, &pixel.RGBA.R, &pixel.RGBA.G, &pixel.RGBA.B, &pixel.RGBA.A)
-		if err != nil {
-			return nil, err
-		}
-		pixels = append(pixels, pixel)
-	}
 
-	return pixels, nil
+	return store.queryPixels(ctx, query, args...)
 }
 
 // GetPixels implements PixelStore
-func (store *pgPixelStore) GetPixelsFromTopLeft(canvasID int64, tlX int64, tlY int64, width int64) ([]core.Pixel, error) {
+func (store *pgPixelStore) GetPixelsFromTopLeft(ctx context.Context, canvasID int64, tlX int64, tlY int64, width int64) ([]core.Pixel, error) {
 
 	xFrom := tlX
 	xTo := tlX + width
@@ -159,10 +181,19 @@ func (store *pgPixelStore) GetPixelsFromTopLeft(canvasID int64, tlX int64, tlY i
 
 	query, args := sb.Build()
 
-	rows, err := store.db.Query(query, args...)
+	return store.queryPixels(ctx, query, args...)
+}
+
+// queryPixels runs a query selecting x, y, r, g, b and a, and scans the pixels it returns.
+func (store *pgPixelStore) queryPixels(ctx context.Context, query string, args ...any) ([]core.Pixel, error) {
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var pixels []core.Pixel
 
@@ -175,7 +206,7 @@ func (store *pgPixelStore) GetPixelsFromTopLeft(canvasID int64, tlX int64, tlY i
 		pixels = append(pixels, pixel)
 	}
 
-	return pixels, nil
+	return pixels, rows.Err()
 }
 
 // GetPixels implements PixelStore
@@ -224,10 +255,14 @@ func (store *pgPixelStore) FindRecentlyChangedAreasBetweenDates(ctx context.Cont
 	fmt.Println(`query`, query)
 	fmt.Println(`args`, args)
 
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
 	rows, err := store.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	areas := map[int64][]core.Area{}
 	for rows.Next() {
@@ -246,32 +281,42 @@ func (store *pgPixelStore) FindRecentlyChangedAreasBetweenDates(ctx context.Cont
 	}
 
 	// slices.SortFunc(areas, core.SortAreasFn)
-	return areas, nil
+	return areas, rows.Err()
 }
 
+// DeleteLastChangedForAreas forgets the changes of the tiles whose origin lies in one of the areas
 func (store *pgPixelStore) DeleteLastChangedForAreas(ctx context.Context, canvasID int64, side int64, areas ...core.Area) error {
+	if len(areas) == 0 {
+		return nil
+	}
+
 	db := sqlbuilder.PostgreSQL.NewDeleteBuilder()
 	db.DeleteFrom("tilechanges")
-	db.Where(
-		db.And(
-			db.Equal("canvas_id", canvasID),
-			db.Equal("side", side),
-		),
-	)
 
-	for _, area := range areas {
-		db.Or(
-			db.And(
-				db.Between("x", area.Min.X, area.Max.X),
-				db.Between("y", area.Min.Y, area.Max.Y),
-			),
+	// max is exclusive, so that the tile starting where an area ends is kept
+	inAreas := make([]string, len(areas))
+	for i, area := range areas {
+		inAreas[i] = db.And(
+			db.GreaterEqualThan("x", area.Min.X),
+			db.LessThan("x", area.Max.X),
+			db.GreaterEqualThan("y", area.Min.Y),
+			db.LessThan("y", area.Max.Y),
 		)
 	}
 
+	db.Where(
+		db.Equal("canvas_id", canvasID),
+		db.Equal("side", side),
+		db.Or(inAreas...),
+	)
+
 	query, args := db.Build()
 	fmt.Println(`query`, query)
 	fmt.Println(`args`, args)
 
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
 	_, err := store.db.ExecContext(ctx, query, args...)
 	return err
 }
@@ -291,6 +336,10 @@ func (store *pgPixelStore) SetLastChangedForAreas(ctx context.Context, canvasID
 	`)
 
 	query, args := ib.Build()
+
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
 	_, err := store.db.ExecContext(ctx, query, args...)
 	return err
 }
@@ -303,12 +352,253 @@ func (store *pgPixelStore) SetLastChangedForPoints(ctx context.Context, canvasID
 	return store.SetLastChangedForAreas(ctx, canvasID, side, changedAreas...)
 }
 
-func NewPG() *sql.DB {
-	db, err := sql.Open("postgres", "user=postgres dbname=draw sslmode=disable")
+// GetHighWaterMark returns the named high-water mark, or the zero time if it was never set
+func (store *pgPixelStore) GetHighWaterMark(ctx context.Context, name string) (time.Time, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("mark")
+	sb.From("highwatermarks")
+	sb.Where(sb.Equal("name", name))
+
+	query, args := sb.Build()
+
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
+	var mark time.Time
+	if err := store.db.QueryRowContext(ctx, query, args...).Scan(&mark); err != nil {
+		if err == ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return mark.UTC(), nil
+}
+
+func (store *pgPixelStore) SetHighWaterMark(ctx context.Context, name string, mark time.Time) error {
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertInto("highwatermarks")
+	ib.Cols("name", "mark")
+	ib.Values(name, mark)
+	ib.SQL(`
+		ON CONFLICT (name) DO UPDATE SET
+			mark = EXCLUDED.mark
+	`)
+
+	query, args := ib.Build()
+
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ForEachPixelDrawnBy calls fn with every pixel currently attributed to the user,
+// streaming them rather than loading them all at once. It is bound by neither
+// the query nor the statement timeout, exports of prolific users take as long
+// as they take
+func (store *pgPixelStore) ForEachPixelDrawnBy(ctx context.Context, drawnBy core.UserID, fn func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error) error {
+	return withoutStatementTimeout(ctx, store.db, func(db dbtx.DBTx) error {
+		return store.WithTx(db).(*pgPixelStore).forEachPixelDrawnBy(ctx, drawnBy, fn)
+	})
+}
+
+func (store *pgPixelStore) forEachPixelDrawnBy(ctx context.Context, drawnBy core.UserID, fn func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error) error {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("canvas_id", "x", "y", "r", "g", "b", "a", "drawn_at")
+	sb.From("pixels")
+	sb.Where(sb.Equal("drawn_by", drawnBy))
+	sb.OrderBy("canvas_id", "drawn_at")
+
+	query, args := sb.Build()
+	rows, err := store.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var canvasID int64
+		var pixel core.Pixel
+		var drawnAt time.Time
+		if err := rows.Scan(&canvasID, &pixel.X, &pixel.Y, &pixel.RGBA.R, &pixel.RGBA.G, &pixel.RGBA.B, &pixel.RGBA.A, &drawnAt); err != nil {
+			return err
+		}
+		if err := fn(canvasID, pixel, drawnAt.UTC()); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// anonymizeBatch is how many pixels AnonymizeDrawer detaches per statement.
+const anonymizeBatch = 10000
+
+// AnonymizeDrawer detaches the user from the pixels they drew, which stay on the
+// canvas as if drawn anonymously, and returns how many there were. The pixels
+// are updated anonymizeBatch at a time, so that however many a user drew, each
+// statement stays within the timeouts and holds few rows locked. Batches done
+// before a failure stay done, rerunning it picks up the rest
+func (store *pgPixelStore) AnonymizeDrawer(ctx context.Context, drawnBy core.UserID) (int64, error) {
+	if drawnBy.IsAnonymous() {
+		return 0, nil
+	}
+
+	count := int64(0)
+	for {
+		n, err := store.anonymizeDrawerBatch(ctx, drawnBy)
+		count += n
+		if err != nil || n < anonymizeBatch {
+			return count, err
+		}
+	}
+}
+
+func (store *pgPixelStore) anonymizeDrawerBatch(ctx context.Context, drawnBy core.UserID) (int64, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("canvas_id", "x", "y")
+	sb.From("pixels")
+	sb.Where(sb.Equal("drawn_by", drawnBy))
+	sb.Limit(anonymizeBatch)
+
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("pixels")
+	ub.Set("drawn_by = NULL")
+	ub.Where("(canvas_id, x, y) IN (" + ub.Var(sb) + ")")
+
+	query, args := ub.Build()
+
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
+	res, err := store.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ForEachPixel implements PixelStore
+// Like ForEachPixelDrawnBy, it streams the pixels and is bound by neither the
+// query nor the statement timeout
+func (store *pgPixelStore) ForEachPixel(ctx context.Context, canvasID int64, fn func(pixel DrawnPixel) error) error {
+	return withoutStatementTimeout(ctx, store.db, func(db dbtx.DBTx) error {
+		return store.WithTx(db).(*pgPixelStore).forEachPixel(ctx, canvasID, fn)
+	})
+}
+
+func (store *pgPixelStore) forEachPixel(ctx context.Context, canvasID int64, fn func(pixel DrawnPixel) error) error {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("x", "y", "r", "g", "b", "a", "drawn_at", "drawn_by")
+	sb.From("pixels")
+	sb.Where(sb.Equal("canvas_id", canvasID))
+
+	query, args := sb.Build()
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pixel DrawnPixel
+		var drawnBy sql.NullString
+		if err := rows.Scan(&pixel.X, &pixel.Y, &pixel.RGBA.R, &pixel.RGBA.G, &pixel.RGBA.B, &pixel.RGBA.A, &pixel.DrawnAt, &drawnBy); err != nil {
+			return err
+		}
+		pixel.DrawnAt = pixel.DrawnAt.UTC()
+		pixel.DrawnBy = core.UserID(drawnBy.String)
+		if err := fn(pixel); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// RestorePixels implements PixelStore
+// Like DrawPixels, it upserts the pixels 1000 at a time in a single transaction
+func (store *pgPixelStore) RestorePixels(ctx context.Context, canvasID int64, pixels []DrawnPixel) error {
+	chunks := chunkSlice(pixels, 1000)
+
+	db, ok := store.db.(*sql.DB)
+	if !ok || len(chunks) <= 1 {
+		return store.restorePixelChunks(ctx, canvasID, chunks)
+	}
+	return InTx(ctx, db, func(tx *sql.Tx) error {
+		return store.WithTx(tx).(*pgPixelStore).restorePixelChunks(ctx, canvasID, chunks)
+	})
+}
+
+func (store *pgPixelStore) restorePixelChunks(ctx context.Context, canvasID int64, chunks [][]DrawnPixel) error {
+	for _, chunk := range chunks {
+		if err := store.restorePixelChunk(ctx, canvasID, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *pgPixelStore) restorePixelChunk(ctx context.Context, canvasID int64, pixels []DrawnPixel) error {
+	sb := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	sb.InsertInto("pixels")
+	sb.Cols("canvas_id", "x", "y", "r", "g", "b", "a", "drawn_at", "drawn_by")
+
+	for _, pixel := range pixels {
+		drawer := sql.NullString{String: pixel.DrawnBy.String(), Valid: !pixel.DrawnBy.IsAnonymous()}
+		sb.Values(canvasID, pixel.X, pixel.Y, pixel.RGBA.R, pixel.RGBA.G, pixel.RGBA.B, pixel.RGBA.A, pixel.DrawnAt, drawer)
+	}
+
+	sb.SQL("ON CONFLICT (canvas_id, x, y) DO UPDATE SET r = EXCLUDED.r, g = EXCLUDED.g, b = EXCLUDED.b, a = EXCLUDED.a, drawn_at = EXCLUDED.drawn_at, drawn_by = EXCLUDED.drawn_by")
+
+	query, args := sb.Build()
+
+	ctx, cancel := store.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeletePixels implements PixelStore
+// It is bound by neither the query nor the statement timeout, large canvases
+// take as long as they take
+func (store *pgPixelStore) DeletePixels(ctx context.Context, canvasID int64) error {
+	return withoutStatementTimeout(ctx, store.db, func(db dbtx.DBTx) error {
+		return store.WithTx(db).(*pgPixelStore).deletePixels(ctx, canvasID)
+	})
+}
+
+func (store *pgPixelStore) deletePixels(ctx context.Context, canvasID int64) error {
+	db := sqlbuilder.PostgreSQL.NewDeleteBuilder()
+	db.DeleteFrom("pixels")
+	db.Where(db.Equal("canvas_id", canvasID))
+
+	query, args := db.Build()
+	_, err := store.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// lastPixels drops the pixels drawn over later in the slice, since an upsert
+// may not touch the same row twice.
+func lastPixels(pixels []core.Pixel) []core.Pixel {
+	last := make(map[core.Point]int, len(pixels))
+	for i, pixel := range pixels {
+		last[pixel.Point] = i
+	}
+	if len(last) == len(pixels) {
+		return pixels
+	}
+
+	kept := make([]core.Pixel, 0, len(last))
+	for i, pixel := range pixels {
+		if last[pixel.Point] == i {
+			kept = append(kept, pixel)
+		}
 	}
-	return db
+	return kept
 }
 
 func chunkSlice[T any](slice []T, chunkSize int) [][]T {
@@ -2,22 +2,76 @@ package storage_test
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"image/color"
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/lazharichir/draw/core"
 	storage "github.com/lazharichir/draw/storage"
+	"github.com/lazharichir/draw/storage/dbtx"
+	"github.com/lazharichir/draw/storage/storagetest"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
-var store storage.PixelStore
+func TestPixelStore(t *testing.T) {
+	t.Run("Postgres", func(t *testing.T) {
+		storagetest.RunPixelStoreSuite(t, func(t *testing.T) storage.PixelStore {
+			return storage.NewPGPixelStore(storagetest.DB(t), nil, 0)
+		})
+	})
+	t.Run("PostgresChunks", func(t *testing.T) {
+		storagetest.RunPixelStoreSuite(t, func(t *testing.T) storage.PixelStore {
+			return storage.NewPGChunkPixelStore(storagetest.DB(t), nil, 0)
+		})
+	})
+	t.Run("Memory", func(t *testing.T) {
+		storagetest.RunPixelStoreSuite(t, func(t *testing.T) storage.PixelStore {
+			return storage.NewMemoryPixelStore()
+		})
+	})
+}
+
+func TestCanvasStore(t *testing.T) {
+	implementations := map[string]func(t *testing.T) storage.CanvasStore{
+		"Postgres": func(t *testing.T) storage.CanvasStore { return storage.NewPGCanvasStore(storagetest.DB(t)) },
+		"Memory":   func(t *testing.T) storage.CanvasStore { return storage.NewMemoryCanvasStore() },
+	}
+
+	for name, newStore := range implementations {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+
+			canvas := core.Canvas{ID: rand.Int63(), TileSides: []int64{128, 256}, MaxTileSide: 256, TileFormats: []core.TileFormat{core.TileFormatRGBA, core.TileFormatPNG}}
+			assert.NoError(t, store.SaveCanvas(ctx, canvas))
+
+			canvas.TileSides = []int64{64}
+			canvas.MaxTileSide = 64
+			assert.NoError(t, store.SaveCanvas(ctx, canvas), "saving again replaces the settings")
 
-func init() {
-	store = storage.NewPGPixelStore(storage.NewPG(), nil)
+			canvases, err := store.LoadCanvases(ctx)
+			assert.NoError(t, err)
+			assert.Contains(t, canvases, canvas)
+
+			saved, err := store.GetCanvas(ctx, canvas.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, &canvas, saved)
+
+			missing, err := store.GetCanvas(ctx, canvas.ID+1)
+			assert.NoError(t, err)
+			assert.Nil(t, missing)
+		})
+	}
 }
 
 func TestDeleteLastChangedForAreas(t *testing.T) {
 	var err error
 	ctx := context.Background()
+	store := storage.NewPGPixelStore(storagetest.DB(t), nil, 0)
 
 	// Insert some test data.
 	canvasID := int64(0)
@@ -38,3 +92,114 @@ func TestDeleteLastChangedForAreas(t *testing.T) {
 	err = store.DeleteLastChangedForAreas(ctx, canvasID, side, areas...)
 	assert.NoError(t, err)
 }
+
+func TestConvertCanvasToChunks(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.DB(t)
+	rows := storage.NewPGPixelStore(db, nil, 0)
+	chunks := storage.NewPGChunkPixelStore(db, nil, 0)
+
+	canvasID := rand.Int63()
+	drawer := core.NewUserID()
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+
+	pixels := []core.Pixel{
+		core.NewPixel(0, 0, red),
+		core.NewPixel(63, 63, red),
+		core.NewPixel(64, 0, red),
+		core.NewPixel(-1, -100, red),
+	}
+	assert.NoError(t, rows.DrawPixels(ctx, canvasID, drawer, pixels))
+	// drawn with the chunked store since, it must not be overwritten
+	assert.NoError(t, chunks.DrawPixelRGBA(ctx, canvasID, "", 0, 0, blue))
+
+	stats, err := storage.ConvertCanvasToChunks(ctx, db, canvasID, true)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ConversionStats{Chunks: 3, Pixels: 4}, stats)
+
+	got, err := chunks.GetLatestPixelsForArea(ctx, canvasID, core.Pt(-100, -100), core.Pt(100, 100), time.Time{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []core.Pixel{
+		core.NewPixel(0, 0, blue),
+		core.NewPixel(63, 63, red),
+		core.NewPixel(64, 0, red),
+		core.NewPixel(-1, -100, red),
+	}, got)
+
+	count, err := chunks.AnonymizeDrawer(ctx, drawer)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count, "the drawers are converted too")
+
+	got, err = rows.GetLatestPixelsForArea(ctx, canvasID, core.Pt(-100, -100), core.Pt(100, 100), time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, got, "the rows are deleted")
+
+	stats, err = storage.ConvertCanvasToChunks(ctx, db, canvasID, true)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.ConversionStats{}, stats)
+}
+
+func TestInTx_RetriesConflicts(t *testing.T) {
+	db := storagetest.DB(t)
+
+	attempts := 0
+	err := storage.InTx(context.Background(), db, func(tx *sql.Tx) error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("conflict: %w", &pq.Error{Code: "40001"})
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestInTx_DoesNotRetryOtherErrors(t *testing.T) {
+	db := storagetest.DB(t)
+
+	attempts := 0
+	err := storage.InTx(context.Background(), db, func(tx *sql.Tx) error {
+		attempts++
+		return storage.ErrNoRows
+	})
+	assert.ErrorIs(t, err, storage.ErrNoRows)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestInReadOnlyTx_RejectsWrites(t *testing.T) {
+	db := storagetest.DB(t)
+	ctx := context.Background()
+
+	err := storage.InReadOnlyTx(ctx, db, func(tx *sql.Tx) error {
+		return storage.NewPGPixelStore(db, nil, 0).WithTx(tx).DrawPixelRGBA(ctx, rand.Int63(), "", 0, 0, color.RGBA{})
+	})
+	var pqErr *pq.Error
+	if assert.ErrorAs(t, err, &pqErr) {
+		assert.Equal(t, pq.ErrorCode("25006"), pqErr.Code, "read_only_sql_transaction")
+	}
+}
+
+func TestWithoutStatementTimeout(t *testing.T) {
+	db := storagetest.DB(t)
+	ctx := context.Background()
+
+	timeout := func(db dbtx.DBTx) string {
+		var timeout string
+		assert.NoError(t, db.QueryRowContext(ctx, "SHOW statement_timeout").Scan(&timeout))
+		return timeout
+	}
+	before := timeout(db)
+
+	// in a transaction of its own, and in the caller's until it ends
+	assert.NoError(t, storage.WithoutStatementTimeout(ctx, db, func(db dbtx.DBTx) error {
+		assert.Equal(t, "0", timeout(db))
+		return nil
+	}))
+	assert.NoError(t, storage.InTx(ctx, db, func(tx *sql.Tx) error {
+		assert.NoError(t, storage.WithoutStatementTimeout(ctx, tx, func(dbtx.DBTx) error { return nil }))
+		assert.Equal(t, "0", timeout(tx))
+		return nil
+	}))
+
+	assert.Equal(t, before, timeout(db), "the pool's connections keep their timeout")
+}
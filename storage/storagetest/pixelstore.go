@@ -0,0 +1,339 @@
+package storagetest
+
+import (
+	"context"
+	"image/color"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/lazharichir/draw/core"
+	"github.com/lazharichir/draw/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// RunPixelStoreSuite checks that the PixelStore returned by newStore behaves
+// like every other implementation. Each test draws on a canvas of its own, so
+// that stores sharing a database don't see each other's pixels.
+func RunPixelStoreSuite(t *testing.T, newStore func(t *testing.T) storage.PixelStore) {
+	tests := map[string]func(t *testing.T, store storage.PixelStore){
+		"DrawPixels":        testDrawPixels,
+		"DrawManyPixels":    testDrawManyPixels,
+		"DrawPixelTwice":    testDrawPixelTwice,
+		"LatestPixelsAfter": testLatestPixelsAfter,
+		"PixelsFromTopLeft": testPixelsFromTopLeft,
+		"TileChanges":       testTileChanges,
+		"HighWaterMark":     testHighWaterMark,
+		"PixelsDrawnBy":     testPixelsDrawnBy,
+		"RestorePixels":     testRestorePixels,
+		"CanceledContext":   testCanceledContext,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+func newCanvasID() int64 {
+	return rand.Int63()
+}
+
+func rgba(r, g, b uint8) color.RGBA {
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+func testDrawPixels(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+	canvasID := newCanvasID()
+	drawer := core.NewUserID()
+
+	pixels := []core.Pixel{
+		core.NewPixel(0, 0, rgba(1, 2, 3)),
+		core.NewPixel(-5, 7, rgba(4, 5, 6)),
+		core.NewPixel(9, 9, rgba(7, 8, 9)),
+	}
+	assert.NoError(t, store.DrawPixels(ctx, canvasID, drawer, pixels))
+
+	got, err := store.GetLatestPixelsForArea(ctx, canvasID, core.Pt(-10, -10), core.Pt(9, 9), time.Time{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, pixels, got, "both corners are inclusive")
+
+	// drawing over a pixel replaces it
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID, drawer, 0, 0, rgba(10, 11, 12)))
+	// erasing leaves a transparent pixel, so that pollers see the change
+	assert.NoError(t, store.ErasePixel(ctx, canvasID, drawer, 9, 9))
+
+	got, err = store.GetLatestPixelsForArea(ctx, canvasID, core.Pt(0, 0), core.Pt(9, 9), time.Time{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []core.Pixel{
+		core.NewPixel(0, 0, rgba(10, 11, 12)),
+		core.NewPixel(9, 9, color.RGBA{}),
+	}, got)
+
+	got, err = store.GetLatestPixelsForArea(ctx, canvasID+1, core.Pt(-10, -10), core.Pt(9, 9), time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, got, "on another canvas")
+}
+
+// newImage returns the pixels of a side x side image.
+func newImage(side int64, color func(x, y int64) color.RGBA) []core.Pixel {
+	var pixels []core.Pixel
+	for x := int64(0); x < side; x++ {
+		for y := int64(0); y < side; y++ {
+			pixels = append(pixels, core.NewPixel(x, y, color(x, y)))
+		}
+	}
+	return pixels
+}
+
+// assertSamePixels is assert.ElementsMatch for pixels, in linear time.
+func assertSamePixels(t *testing.T, want, got []core.Pixel) {
+	t.Helper()
+	colors := make(map[core.Point]color.RGBA, len(want))
+	for _, pixel := range want {
+		colors[pixel.Point] = pixel.RGBA
+	}
+	assert.Len(t, got, len(colors))
+	for _, pixel := range got {
+		if c, ok := colors[pixel.Point]; !ok || c != pixel.RGBA {
+			assert.Failf(t, "unexpected pixel", "%v", pixel)
+			return
+		}
+	}
+}
+
+func testDrawManyPixels(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+
+	// more than fit in a single insert, and enough to be copied in bulk
+	for _, side := range []int64{50, 100} {
+		canvasID := newCanvasID()
+		pixels := newImage(side, func(x, y int64) color.RGBA { return rgba(uint8(x), uint8(y), 0) })
+		assert.NoError(t, store.DrawPixels(ctx, canvasID, "", pixels))
+
+		got, err := store.GetLatestPixelsForArea(ctx, canvasID, core.Pt(0, 0), core.Pt(side-1, side-1), time.Time{})
+		assert.NoError(t, err)
+		assertSamePixels(t, pixels, got)
+
+		// drawing over the image
+		pixels = newImage(side, func(x, y int64) color.RGBA { return rgba(0, uint8(x), uint8(y)) })
+		assert.NoError(t, store.DrawPixels(ctx, canvasID, "", pixels))
+
+		got, err = store.GetLatestPixelsForArea(ctx, canvasID, core.Pt(0, 0), core.Pt(side-1, side-1), time.Time{})
+		assert.NoError(t, err)
+		assertSamePixels(t, pixels, got)
+	}
+}
+
+func testDrawPixelTwice(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+
+	// in a single insert, and copied in bulk
+	for _, side := range []int64{10, 100} {
+		canvasID := newCanvasID()
+		pixels := newImage(side, func(x, y int64) color.RGBA { return rgba(1, 1, 1) })
+		pixels = append(pixels, core.NewPixel(0, 0, rgba(2, 2, 2)))
+
+		assert.NoError(t, store.DrawPixels(ctx, canvasID, "", pixels))
+
+		got, err := store.GetPixelsFromTopLeft(ctx, canvasID, 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []core.Pixel{core.NewPixel(0, 0, rgba(2, 2, 2))}, got, "the last color wins")
+	}
+}
+
+func testLatestPixelsAfter(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+	canvasID := newCanvasID()
+	drawer := core.NewUserID()
+
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID, drawer, 1, 1, rgba(1, 1, 1)))
+
+	// read the time the store recorded, its clock may not be ours
+	var drawnAt time.Time
+	assert.NoError(t, store.ForEachPixelDrawnBy(ctx, drawer, func(_ int64, _ core.Pixel, at time.Time) error {
+		drawnAt = at
+		return nil
+	}))
+	assert.False(t, drawnAt.IsZero())
+
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID, drawer, 2, 2, rgba(2, 2, 2)))
+
+	got, err := store.GetLatestPixelsForArea(ctx, canvasID, core.Pt(0, 0), core.Pt(10, 10), drawnAt)
+	assert.NoError(t, err)
+	assert.Equal(t, []core.Pixel{core.NewPixel(2, 2, rgba(2, 2, 2))}, got, "after is exclusive")
+}
+
+func testPixelsFromTopLeft(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+	canvasID := newCanvasID()
+
+	pixels := []core.Pixel{
+		core.NewPixel(0, 0, rgba(1, 1, 1)),
+		core.NewPixel(10, 10, rgba(2, 2, 2)),
+		core.NewPixel(11, 0, rgba(3, 3, 3)),
+		core.NewPixel(0, -1, rgba(4, 4, 4)),
+	}
+	assert.NoError(t, store.DrawPixels(ctx, canvasID, "", pixels))
+
+	// x+width and y+width are included
+	got, err := store.GetPixelsFromTopLeft(ctx, canvasID, 0, 0, 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, pixels[:2], got)
+}
+
+func testTileChanges(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+	canvasID := newCanvasID()
+	side := int64(256)
+
+	from := time.Now().Add(-time.Hour)
+	to := time.Now().Add(time.Hour)
+
+	points := []core.Point{core.Pt(1, 1), core.Pt(2, 2), core.Pt(300, 1), core.Pt(-1, -1)}
+	assert.NoError(t, store.SetLastChangedForPoints(ctx, canvasID, side, points...))
+	assert.NoError(t, store.SetLastChangedForPoints(ctx, canvasID, side))
+
+	areas := core.GetTileAreasFromPoints(side, points...)
+	assert.Len(t, areas, 3)
+
+	changed, err := store.FindRecentlyChangedAreasBetweenDates(ctx, from, to)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, areas, changed[canvasID])
+
+	// from and to may come in any order
+	changed, err = store.FindRecentlyChangedAreasBetweenDates(ctx, to, from)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, areas, changed[canvasID])
+
+	changed, err = store.FindRecentlyChangedAreasBetweenDates(ctx, from, from.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, changed[canvasID])
+
+	// deleting the first tile keeps its neighbours, even the one starting on its edge
+	first := core.GetTileAreaFromPoint(core.Pt(1, 1), side)
+	assert.NoError(t, store.DeleteLastChangedForAreas(ctx, canvasID, side, first))
+	assert.NoError(t, store.DeleteLastChangedForAreas(ctx, canvasID, side))
+	assert.NoError(t, store.DeleteLastChangedForAreas(ctx, canvasID, side*2, areas...))
+
+	changed, err = store.FindRecentlyChangedAreasBetweenDates(ctx, from, to)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []core.Area{
+		core.GetTileAreaFromPoint(core.Pt(300, 1), side),
+		core.GetTileAreaFromPoint(core.Pt(-1, -1), side),
+	}, changed[canvasID])
+}
+
+func testHighWaterMark(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+	name := core.NewUserID().String()
+
+	mark, err := store.GetHighWaterMark(ctx, name)
+	assert.NoError(t, err)
+	assert.True(t, mark.IsZero(), "a mark never set is zero")
+
+	want := time.Now().UTC().Round(time.Microsecond)
+	assert.NoError(t, store.SetHighWaterMark(ctx, name, want))
+	assert.NoError(t, store.SetHighWaterMark(ctx, name, want))
+
+	mark, err = store.GetHighWaterMark(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, want, mark)
+}
+
+func testPixelsDrawnBy(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+	canvasID := newCanvasID()
+	drawer := core.NewUserID()
+
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID+1, drawer, 1, 1, rgba(1, 1, 1)))
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID, drawer, 2, 2, rgba(2, 2, 2)))
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID, core.NewUserID(), 3, 3, rgba(3, 3, 3)))
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID, "", 4, 4, rgba(4, 4, 4)))
+
+	type drawn struct {
+		canvasID int64
+		pixel    core.Pixel
+	}
+	collect := func(drawnBy core.UserID) []drawn {
+		var got []drawn
+		err := store.ForEachPixelDrawnBy(ctx, drawnBy, func(canvasID int64, pixel core.Pixel, drawnAt time.Time) error {
+			got = append(got, drawn{canvasID, pixel})
+			return nil
+		})
+		assert.NoError(t, err)
+		return got
+	}
+
+	assert.Equal(t, []drawn{
+		{canvasID, core.NewPixel(2, 2, rgba(2, 2, 2))},
+		{canvasID + 1, core.NewPixel(1, 1, rgba(1, 1, 1))},
+	}, collect(drawer), "ordered by canvas")
+	assert.Empty(t, collect(""), "anonymous pixels belong to no one")
+
+	count, err := store.AnonymizeDrawer(ctx, drawer)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.Empty(t, collect(drawer))
+
+	// the pixels stay on the canvas
+	got, err := store.GetLatestPixelsForArea(ctx, canvasID, core.Pt(0, 0), core.Pt(10, 10), time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, got, 3)
+}
+
+func testRestorePixels(t *testing.T, store storage.PixelStore) {
+	ctx := context.Background()
+	canvasID := newCanvasID()
+	drawer := core.NewUserID()
+	drawnAt := time.Date(2023, 10, 5, 16, 14, 0, 123456000, time.UTC)
+
+	collect := func(canvasID int64) []storage.DrawnPixel {
+		var got []storage.DrawnPixel
+		assert.NoError(t, store.ForEachPixel(ctx, canvasID, func(pixel storage.DrawnPixel) error {
+			got = append(got, pixel)
+			return nil
+		}))
+		return got
+	}
+
+	pixels := []storage.DrawnPixel{
+		{Pixel: core.NewPixel(0, 0, rgba(1, 1, 1)), DrawnAt: drawnAt, DrawnBy: drawer},
+		{Pixel: core.NewPixel(-70, 200, rgba(2, 2, 2)), DrawnAt: drawnAt.Add(time.Hour)},
+		{Pixel: core.NewPixel(5, 5, color.RGBA{}), DrawnAt: drawnAt, DrawnBy: drawer},
+	}
+	assert.NoError(t, store.RestorePixels(ctx, canvasID, pixels))
+	assert.NoError(t, store.RestorePixels(ctx, canvasID, nil))
+	assert.ElementsMatch(t, pixels, collect(canvasID), "the pixels keep their time and drawer")
+	assert.Empty(t, collect(canvasID+1))
+
+	// restored pixels are drawn like any other
+	got, err := store.GetPixelsFromTopLeft(ctx, canvasID, 0, 0, 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []core.Pixel{pixels[0].Pixel, pixels[2].Pixel}, got)
+
+	restored := 0
+	assert.NoError(t, store.ForEachPixelDrawnBy(ctx, drawer, func(int64, core.Pixel, time.Time) error {
+		restored++
+		return nil
+	}))
+	assert.Equal(t, 2, restored)
+
+	assert.NoError(t, store.DrawPixelRGBA(ctx, canvasID+1, "", 0, 0, rgba(3, 3, 3)))
+	assert.NoError(t, store.DeletePixels(ctx, canvasID))
+	assert.Empty(t, collect(canvasID))
+	assert.Len(t, collect(canvasID+1), 1, "other canvases are left alone")
+}
+
+func testCanceledContext(t *testing.T, store storage.PixelStore) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := store.DrawPixelRGBA(ctx, newCanvasID(), "", 0, 0, rgba(1, 1, 1))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = store.GetLatestPixelsForArea(ctx, newCanvasID(), core.Pt(0, 0), core.Pt(1, 1), time.Time{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
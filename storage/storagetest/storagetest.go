@@ -0,0 +1,42 @@
+// Package storagetest connects tests to the database configured for them, and
+// skips them when there is none.
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/lazharichir/draw/config"
+	"github.com/lazharichir/draw/storage"
+)
+
+var (
+	once sync.Once
+	db   *sql.DB
+	err  error
+)
+
+// DB returns the pool to the configured database, shared by every test of the
+// package, or skips the test if the database cannot be reached.
+func DB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	once.Do(func() {
+		var cfg *config.Config
+		cfg, _, err = config.Read(nil, os.LookupEnv)
+		if err != nil {
+			return
+		}
+		// a missing database should skip tests, not hold them up
+		cfg.Database.ConnectAttempts = 1
+		db, err = storage.OpenPG(context.Background(), cfg.Database)
+	})
+
+	if err != nil {
+		t.Skipf("no database to test against: %v", err)
+	}
+	return db
+}
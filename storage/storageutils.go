@@ -1,9 +1,27 @@
 package storage
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
 
 var ErrNoRows = sql.ErrNoRows
 
+// IsUniqueViolation reports whether err comes from a unique constraint, e.g. two users with the same email.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// IsRetryable reports whether err comes from a transaction that failed because of
+// concurrent ones, a serialization failure or a deadlock, and may succeed if rerun.
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
+
 func toAnySlice[T any](values []T) []any {
 	anyValues := make([]any, len(values))
 	for i, value := range values {
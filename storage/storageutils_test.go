@@ -3,8 +3,12 @@ package storage
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"image/color"
 	"testing"
 
+	"github.com/lazharichir/draw/core"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -12,3 +16,30 @@ func TestErrNoRows(t *testing.T) {
 	assert.True(t, errors.Is(ErrNoRows, sql.ErrNoRows))
 	assert.True(t, ErrNoRows == sql.ErrNoRows)
 }
+
+func TestIsUniqueViolation(t *testing.T) {
+	assert.True(t, IsUniqueViolation(&pq.Error{Code: "23505"}))
+	assert.True(t, IsUniqueViolation(fmt.Errorf("SaveUser: %w", &pq.Error{Code: "23505"})))
+	assert.False(t, IsUniqueViolation(&pq.Error{Code: "23503"}))
+	assert.False(t, IsUniqueViolation(ErrNoRows))
+	assert.False(t, IsUniqueViolation(nil))
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(&pq.Error{Code: "40001"}))
+	assert.True(t, IsRetryable(fmt.Errorf("DrawPixels: %w", &pq.Error{Code: "40P01"})))
+	assert.False(t, IsRetryable(&pq.Error{Code: "23505"}))
+	assert.False(t, IsRetryable(ErrNoRows))
+	assert.False(t, IsRetryable(nil))
+}
+
+func TestLastPixels(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+
+	pixels := []core.Pixel{core.NewPixel(0, 0, red), core.NewPixel(1, 0, red)}
+	assert.Equal(t, pixels, lastPixels(pixels))
+
+	pixels = append(pixels, core.NewPixel(0, 0, blue))
+	assert.Equal(t, []core.Pixel{core.NewPixel(1, 0, red), core.NewPixel(0, 0, blue)}, lastPixels(pixels))
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	// txMaxAttempts is how many times InTx runs a transaction failing on conflicts.
+	txMaxAttempts = 5
+	// txRetryBackoff is the wait before the first retry, doubled after each attempt.
+	txRetryBackoff = 20 * time.Millisecond
+)
+
+// InTx runs fn in a transaction, which is committed if fn succeeds and rolled
+// back otherwise. Transactions run at READ COMMITTED, where Postgres reports
+// deadlocks but no serialization failures, so fn must lock what it relies on
+// staying unchanged. Transactions failing on deadlocks are rerun from the start,
+// so fn may run several times and must not have effects outside tx.
+func InTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	return InTxWithin(ctx, db, 0, func(_ context.Context, tx *sql.Tx) error {
+		return fn(tx)
+	})
+}
+
+// InTxWithin is InTx with each attempt bounded by timeout, zero meaning no bound:
+// fn gets the attempt's context, which is canceled once timeout has passed since
+// the transaction began, rolling it back unless it has committed. Writes stamped
+// with the transaction's start time, e.g. by NOW(), are then committed less
+// than timeout after that time.
+func InTxWithin(ctx context.Context, db *sql.DB, timeout time.Duration, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	backoff := txRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := runTxWithin(ctx, db, timeout, fn)
+		if err == nil || !IsRetryable(err) || attempt == txMaxAttempts {
+			return err
+		}
+
+		// jitter keeps the conflicting transactions from retrying in lockstep
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("InTx: %w", ctx.Err())
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func runTxWithin(ctx context.Context, db *sql.DB, timeout time.Duration, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return runTx(ctx, db, func(tx *sql.Tx) error {
+		return fn(ctx, tx)
+	})
+}
+
+func runTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InReadOnlyTx runs fn in a read-only transaction that sees the database as it
+// was when the transaction started, so that what fn reads across several
+// queries is consistent. Such transactions cannot conflict, they are not retried.
+func InReadOnlyTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -2,12 +2,15 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/lazharichir/draw/core"
 	"github.com/lazharichir/draw/storage/dbtx"
+	"github.com/lib/pq"
 )
 
 type IAMStore struct {
@@ -17,14 +20,27 @@ func NewIAMStorePG() *IAMStore {
 	return &IAMStore{}
 }
 
+var userColumns = []string{"id", "status", "status_reason", "status_until", "username", "email", "created_at", "last_signed_in_at", "last_drawn_pixel_at", "pixels_drawn"}
+
+func scanUser(row interface{ Scan(dest ...any) error }) (*core.User, error) {
+	user := core.User{}
+	if err := row.Scan(&user.ID, &user.Status, &user.StatusReason, &user.StatusUntil, &user.Username, &user.Email, &user.CreatedAt, &user.LastSignedInAt, &user.LastDrawnPixelAt, &user.PixelsDrawn); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (iam *IAMStore) SaveUser(ctx context.Context, db dbtx.DBTx, user core.User) error {
 	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
 	ib.InsertInto("users")
-	ib.Cols("id", "status", "username", "email", "created_at", "last_signed_in_at", "last_drawn_pixel_at")
-	ib.Values(user.ID, user.Status, user.Username, user.Email, user.CreatedAt, user.LastSignedInAt, user.LastDrawnPixelAt)
+	ib.Cols(userColumns...)
+	ib.Values(user.ID, user.Status, user.StatusReason, user.StatusUntil, user.Username, user.Email, user.CreatedAt, user.LastSignedInAt, user.LastDrawnPixelAt, user.PixelsDrawn)
+	// pixels_drawn is left out on conflict, it only moves through AddPixelsDrawn
 	ib.SQL(`
 		ON CONFLICT (id) DO UPDATE SET
 			status = EXCLUDED.status,
+			status_reason = EXCLUDED.status_reason,
+			status_until = EXCLUDED.status_until,
 			username = EXCLUDED.username,
 			email = EXCLUDED.email,
 			last_signed_in_at = EXCLUDED.last_signed_in_at,
@@ -38,7 +54,7 @@ func (iam *IAMStore) SaveUser(ctx context.Context, db dbtx.DBTx, user core.User)
 
 func (iam *IAMStore) GetUserBy(ctx context.Context, db dbtx.DBTx, field string, value string) (*core.User, error) {
 	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
-	sb.Select("id", "status", "username", "email", "created_at", "last_signed_in_at", "last_drawn_pixel_at").From("users")
+	sb.Select(userColumns...).From("users")
 
 	switch strings.ToLower(field) {
 	case "id":
@@ -52,15 +68,113 @@ func (iam *IAMStore) GetUserBy(ctx context.Context, db dbtx.DBTx, field string,
 	query, args := sb.Build()
 	row := db.QueryRowContext(ctx, query, args...)
 
-	user := core.User{}
-	if err := row.Scan(&user.ID, &user.Status, &user.Username, &user.Email, &user.CreatedAt, &user.LastSignedInAt, &user.LastDrawnPixelAt); err != nil {
+	return scanUser(row)
+}
+
+// GetUserForUpdate loads the user and locks their row until the end of the
+// transaction, so that their status cannot change meanwhile.
+func (iam *IAMStore) GetUserForUpdate(ctx context.Context, tx dbtx.DBTx, userID core.UserID) (*core.User, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select(userColumns...).From("users")
+	sb.Where(sb.Equal("id", userID))
+	sb.ForUpdate()
+
+	query, args := sb.Build()
+	return scanUser(tx.QueryRowContext(ctx, query, args...))
+}
+
+// SetLastSignedIn records when the user last signed in, leaving the rest of their row alone.
+func (iam *IAMStore) SetLastSignedIn(ctx context.Context, db dbtx.DBTx, userID core.UserID, at time.Time) error {
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("users")
+	ub.Set(ub.Assign("last_signed_in_at", at))
+	ub.Where(ub.Equal("id", userID))
+
+	query, args := ub.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// AddPixelsDrawn adds to the user's drawn pixels counter and returns its new value.
+func (iam *IAMStore) AddPixelsDrawn(ctx context.Context, db dbtx.DBTx, userID core.UserID, count int64, at time.Time) (int64, error) {
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("users")
+	ub.Set(
+		ub.Add("pixels_drawn", count),
+		ub.Assign("last_drawn_pixel_at", at),
+	)
+	ub.Where(ub.Equal("id", userID))
+	ub.SQL("RETURNING pixels_drawn")
+
+	query, args := ub.Build()
+	var total int64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// PromoteUser lifts the user out of probation, unless their status changed since
+// it was read, e.g. they were banned meanwhile, and reports whether it did.
+// Lapsed suspensions and bans count as probation, see core.User.IsOnProbationAt.
+func (iam *IAMStore) PromoteUser(ctx context.Context, db dbtx.DBTx, userID core.UserID, at time.Time) (bool, error) {
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("users")
+	ub.Set(
+		ub.Assign("status", core.UserStatusActive),
+		ub.Assign("status_reason", ""),
+		"status_until = NULL",
+	)
+	ub.Where(
+		ub.Equal("id", userID),
+		ub.Or(
+			ub.Equal("status", core.UserStatusProbation),
+			ub.And(
+				ub.In("status", core.UserStatusSuspended, core.UserStatusBanned),
+				ub.LessEqualThan("status_until", at),
+			),
+		),
+	)
+
+	query, args := ub.Build()
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// LoadUsers returns the users with the given IDs, keyed by ID. Unknown IDs are left out.
+func (iam *IAMStore) LoadUsers(ctx context.Context, db dbtx.DBTx, ids ...core.UserID) (map[core.UserID]*core.User, error) {
+	users := map[core.UserID]*core.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select(userColumns...).From("users")
+	sb.Where(sb.In("id", toAnySlice(ids)...))
+
+	query, args := sb.Build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &user, nil
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users[user.ID] = user
+	}
+
+	return users, rows.Err()
 }
 
-func (iam *IAMStore) SaveUserProfile(ctx context.Context, db dbtx.DBTx, userID string, profile core.UserProfile) error {
+func (iam *IAMStore) SaveUserProfile(ctx context.Context, db dbtx.DBTx, userID core.UserID, profile core.UserProfile) error {
 	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
 	ib.InsertInto("user_profiles")
 	ib.Cols("user_id", "first_name", "last_name", "gender", "dob", "bio", "website_url", "facebook_url", "twitter_url", "instagram_url", "linkedin_url", "tiktok_url", "youtube_url")
@@ -101,7 +215,7 @@ func (iam *IAMStore) SaveUserProfile(ctx context.Context, db dbtx.DBTx, userID s
 	return err
 }
 
-func (iam *IAMStore) GetUserProfile(ctx context.Context, db dbtx.DBTx, userID string) (*core.UserProfile, error) {
+func (iam *IAMStore) GetUserProfile(ctx context.Context, db dbtx.DBTx, userID core.UserID) (*core.UserProfile, error) {
 	lookup, err := iam.LoadUserProfiles(ctx, db, userID)
 	if err != nil {
 		return nil, err
@@ -114,7 +228,11 @@ func (iam *IAMStore) GetUserProfile(ctx context.Context, db dbtx.DBTx, userID st
 	return lookup[userID], nil
 }
 
-func (iam *IAMStore) LoadUserProfiles(ctx context.Context, db dbtx.DBTx, ids ...string) (map[string]*core.UserProfile, error) {
+func (iam *IAMStore) LoadUserProfiles(ctx context.Context, db dbtx.DBTx, ids ...core.UserID) (map[core.UserID]*core.UserProfile, error) {
+	if len(ids) == 0 {
+		return map[core.UserID]*core.UserProfile{}, nil
+	}
+
 	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
 	sb.Select("user_id", "first_name", "last_name", "gender", "dob", "bio", "website_url", "facebook_url", "twitter_url", "instagram_url", "linkedin_url", "tiktok_url", "youtube_url")
 	sb.From("user_profiles")
@@ -127,9 +245,9 @@ func (iam *IAMStore) LoadUserProfiles(ctx context.Context, db dbtx.DBTx, ids ...
 	}
 	defer rows.Close()
 
-	userProfiles := map[string]*core.UserProfile{}
+	userProfiles := map[core.UserID]*core.UserProfile{}
 	for rows.Next() {
-		var userid string
+		var userid core.UserID
 		var profile core.UserProfile
 		if err = rows.Scan(
 			&userid,
@@ -191,3 +309,490 @@ func (iam *IAMStore) GetVerificationToken(ctx context.Context, db dbtx.DBTx, tok
 
 	return &vt, nil
 }
+
+// UseVerificationToken marks the token as used if it is still active and returns
+// false if it was already used, has expired or does not exist, so that a token
+// can only ever be redeemed once even under concurrent requests.
+func (iam *IAMStore) UseVerificationToken(ctx context.Context, db dbtx.DBTx, token string, at time.Time) (bool, error) {
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("verification_tokens")
+	ub.Set(ub.Assign("used_at", at))
+	ub.Where(
+		ub.Equal("token", token),
+		ub.IsNull("used_at"),
+		ub.GreaterThan("expires_at", at),
+	)
+
+	query, args := ub.Build()
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (iam *IAMStore) SaveSession(ctx context.Context, db dbtx.DBTx, session core.Session) error {
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertInto("sessions")
+	ib.Cols("token", "user_id", "created_at", "expires_at", "revoked_at")
+	ib.Values(session.Token, session.UserID, session.CreatedAt, session.ExpiresAt, session.RevokedAt)
+	ib.SQL(`
+		ON CONFLICT (token) DO UPDATE SET
+			expires_at = EXCLUDED.expires_at,
+			revoked_at = EXCLUDED.revoked_at
+	`)
+
+	query, args := ib.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (iam *IAMStore) GetSession(ctx context.Context, db dbtx.DBTx, token string) (*core.Session, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("token", "user_id", "created_at", "expires_at", "revoked_at")
+	sb.From("sessions")
+	sb.Where(sb.Equal("token", token))
+
+	query, args := sb.Build()
+	row := db.QueryRowContext(ctx, query, args...)
+
+	session := core.Session{}
+	if err := row.Scan(&session.Token, &session.UserID, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt); err != nil {
+		if err == ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RevokeUserSessions revokes every active session of the user
+func (iam *IAMStore) RevokeUserSessions(ctx context.Context, db dbtx.DBTx, userID core.UserID, at time.Time) error {
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("sessions")
+	ub.Set(ub.Assign("revoked_at", at))
+	ub.Where(
+		ub.Equal("user_id", userID),
+		ub.IsNull("revoked_at"),
+	)
+
+	query, args := ub.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (iam *IAMStore) SavePasswordCredential(ctx context.Context, db dbtx.DBTx, cred core.PasswordCredential) error {
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertInto("user_credentials")
+	ib.Cols("user_id", "password_hash", "failed_attempts", "locked_until", "updated_at")
+	ib.Values(cred.UserID, cred.Hash, cred.FailedAttempts, cred.LockedUntil, cred.UpdatedAt)
+	ib.SQL(`
+		ON CONFLICT (user_id) DO UPDATE SET
+			password_hash = EXCLUDED.password_hash,
+			failed_attempts = EXCLUDED.failed_attempts,
+			locked_until = EXCLUDED.locked_until,
+			updated_at = EXCLUDED.updated_at
+	`)
+
+	query, args := ib.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetPasswordCredentialForUpdate loads the user's password credential and locks
+// its row until the end of the transaction, or returns nil if the user has none
+func (iam *IAMStore) GetPasswordCredentialForUpdate(ctx context.Context, tx dbtx.DBTx, userID core.UserID) (*core.PasswordCredential, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("user_id", "password_hash", "failed_attempts", "locked_until", "updated_at")
+	sb.From("user_credentials")
+	sb.Where(sb.Equal("user_id", userID))
+	sb.ForUpdate()
+
+	query, args := sb.Build()
+	row := tx.QueryRowContext(ctx, query, args...)
+
+	cred := core.PasswordCredential{}
+	if err := row.Scan(&cred.UserID, &cred.Hash, &cred.FailedAttempts, &cred.LockedUntil, &cred.UpdatedAt); err != nil {
+		if err == ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+// SaveRoleGrant grants the role, doing nothing if the user already has it on the same scope.
+func (iam *IAMStore) SaveRoleGrant(ctx context.Context, db dbtx.DBTx, grant core.RoleGrant) error {
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertInto("user_roles")
+	ib.Cols("user_id", "role", "canvas_id", "granted_at", "granted_by")
+	ib.Values(grant.UserID, grant.Role, grant.CanvasID, grant.GrantedAt, sql.NullString{String: grant.GrantedBy.String(), Valid: !grant.GrantedBy.IsAnonymous()})
+	ib.SQL("ON CONFLICT DO NOTHING")
+
+	query, args := ib.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeleteRoleGrant revokes the role on the canvas, or the global one if canvasID is nil.
+func (iam *IAMStore) DeleteRoleGrant(ctx context.Context, db dbtx.DBTx, userID core.UserID, role core.Role, canvasID *int64) error {
+	del := sqlbuilder.PostgreSQL.NewDeleteBuilder()
+	del.DeleteFrom("user_roles")
+	del.Where(
+		del.Equal("user_id", userID),
+		del.Equal("role", role),
+		"canvas_id IS NOT DISTINCT FROM "+del.Var(canvasID),
+	)
+
+	query, args := del.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetRoleGrants returns every role granted to the user, global and per canvas.
+func (iam *IAMStore) GetRoleGrants(ctx context.Context, db dbtx.DBTx, userID core.UserID) (core.RoleGrants, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("user_id", "role", "canvas_id", "granted_at", "granted_by")
+	sb.From("user_roles")
+	sb.Where(sb.Equal("user_id", userID))
+	sb.OrderBy("granted_at")
+
+	query, args := sb.Build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := core.RoleGrants{}
+	for rows.Next() {
+		var grant core.RoleGrant
+		var grantedBy sql.NullString
+		if err := rows.Scan(&grant.UserID, &grant.Role, &grant.CanvasID, &grant.GrantedAt, &grantedBy); err != nil {
+			return nil, err
+		}
+		grant.GrantedBy = core.UserID(grantedBy.String)
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+var apiKeyColumns = []string{"id", "user_id", "name", "secret_hash", "scopes", "rate_limit_per_minute", "created_at", "last_used_at", "revoked_at"}
+
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (*core.APIKey, error) {
+	key := core.APIKey{}
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.SecretHash, pq.Array(&key.Scopes), &key.RateLimitPerMinute, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (iam *IAMStore) SaveAPIKey(ctx context.Context, db dbtx.DBTx, key core.APIKey) error {
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertInto("api_keys")
+	ib.Cols(apiKeyColumns...)
+	ib.Values(key.ID, key.UserID, key.Name, key.SecretHash, pq.Array(key.Scopes), key.RateLimitPerMinute, key.CreatedAt, key.LastUsedAt, key.RevokedAt)
+	ib.SQL(`
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			scopes = EXCLUDED.scopes,
+			rate_limit_per_minute = EXCLUDED.rate_limit_per_minute,
+			last_used_at = EXCLUDED.last_used_at,
+			revoked_at = EXCLUDED.revoked_at
+	`)
+
+	query, args := ib.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAPIKey returns the key with the given ID, or nil if there is none.
+func (iam *IAMStore) GetAPIKey(ctx context.Context, db dbtx.DBTx, id string) (*core.APIKey, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select(apiKeyColumns...).From("api_keys")
+	sb.Where(sb.Equal("id", id))
+
+	query, args := sb.Build()
+	key, err := scanAPIKey(db.QueryRowContext(ctx, query, args...))
+	if err == ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// ListAPIKeys returns the user's keys, revoked ones included, newest first.
+func (iam *IAMStore) ListAPIKeys(ctx context.Context, db dbtx.DBTx, userID core.UserID) ([]core.APIKey, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select(apiKeyColumns...).From("api_keys")
+	sb.Where(sb.Equal("user_id", userID))
+	sb.OrderBy("created_at").Desc()
+
+	query, args := sb.Build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []core.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records that the key was used at the given time.
+func (iam *IAMStore) TouchAPIKey(ctx context.Context, db dbtx.DBTx, id string, at time.Time) error {
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("api_keys")
+	ub.Set(ub.Assign("last_used_at", at))
+	ub.Where(ub.Equal("id", id))
+
+	query, args := ub.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (iam *IAMStore) SaveUserIdentity(ctx context.Context, db dbtx.DBTx, identity core.UserIdentity) error {
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertInto("user_identities")
+	ib.Cols("provider", "subject", "user_id", "email", "created_at", "last_signed_in_at")
+	ib.Values(identity.Provider, identity.Subject, identity.UserID, identity.Email, identity.CreatedAt, identity.LastSignedInAt)
+	ib.SQL(`
+		ON CONFLICT (provider, subject) DO UPDATE SET
+			email = EXCLUDED.email,
+			last_signed_in_at = EXCLUDED.last_signed_in_at
+	`)
+
+	query, args := ib.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetUserIdentity returns the identity of the provider's subject, or nil if it is not linked to a user.
+func (iam *IAMStore) GetUserIdentity(ctx context.Context, db dbtx.DBTx, provider string, subject string) (*core.UserIdentity, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("provider", "subject", "user_id", "email", "created_at", "last_signed_in_at")
+	sb.From("user_identities")
+	sb.Where(sb.Equal("provider", provider), sb.Equal("subject", subject))
+
+	query, args := sb.Build()
+	row := db.QueryRowContext(ctx, query, args...)
+
+	identity := core.UserIdentity{}
+	if err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt, &identity.LastSignedInAt); err != nil {
+		if err == ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// ListUserIdentities returns every identity linked to the user.
+func (iam *IAMStore) ListUserIdentities(ctx context.Context, db dbtx.DBTx, userID core.UserID) ([]core.UserIdentity, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("provider", "subject", "user_id", "email", "created_at", "last_signed_in_at")
+	sb.From("user_identities")
+	sb.Where(sb.Equal("user_id", userID))
+	sb.OrderBy("created_at")
+
+	query, args := sb.Build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []core.UserIdentity{}
+	for rows.Next() {
+		identity := core.UserIdentity{}
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt, &identity.LastSignedInAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// ListVerificationTokens returns every verification token issued to the user.
+func (iam *IAMStore) ListVerificationTokens(ctx context.Context, db dbtx.DBTx, userID core.UserID) ([]core.VerificationToken, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("token", "kind", "user_id", "email", "created_at", "expires_at", "used_at")
+	sb.From("verification_tokens")
+	sb.Where(sb.Equal("user_id", userID))
+	sb.OrderBy("created_at")
+
+	query, args := sb.Build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []core.VerificationToken{}
+	for rows.Next() {
+		vt := core.VerificationToken{}
+		if err := rows.Scan(&vt.Token, &vt.Kind, &vt.UserID, &vt.Email, &vt.CreatedAt, &vt.ExpiresAt, &vt.UsedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, vt)
+	}
+
+	return tokens, rows.Err()
+}
+
+// ListSessions returns every session of the user, revoked and expired ones included.
+func (iam *IAMStore) ListSessions(ctx context.Context, db dbtx.DBTx, userID core.UserID) ([]core.Session, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select("token", "user_id", "created_at", "expires_at", "revoked_at")
+	sb.From("sessions")
+	sb.Where(sb.Equal("user_id", userID))
+	sb.OrderBy("created_at")
+
+	query, args := sb.Build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []core.Session{}
+	for rows.Next() {
+		session := core.Session{}
+		if err := rows.Scan(&session.Token, &session.UserID, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+var accountDeletionColumns = []string{"user_id", "transfer_leases_to", "requested_at", "scheduled_for", "canceled_at", "completed_at", "attempts", "last_attempt_at", "last_error"}
+
+func scanAccountDeletion(row interface{ Scan(dest ...any) error }) (*core.AccountDeletion, error) {
+	d := core.AccountDeletion{}
+	if err := row.Scan(&d.UserID, &d.TransferLeasesTo, &d.RequestedAt, &d.ScheduledFor, &d.CanceledAt, &d.CompletedAt, &d.Attempts, &d.LastAttemptAt, &d.LastError); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SaveAccountDeletion saves the user's deletion request, replacing any earlier one.
+func (iam *IAMStore) SaveAccountDeletion(ctx context.Context, db dbtx.DBTx, d core.AccountDeletion) error {
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertInto("account_deletions")
+	ib.Cols(accountDeletionColumns...)
+	ib.Values(d.UserID, d.TransferLeasesTo, d.RequestedAt, d.ScheduledFor, d.CanceledAt, d.CompletedAt, d.Attempts, d.LastAttemptAt, d.LastError)
+	ib.SQL(`
+		ON CONFLICT (user_id) DO UPDATE SET
+			transfer_leases_to = EXCLUDED.transfer_leases_to,
+			requested_at = EXCLUDED.requested_at,
+			scheduled_for = EXCLUDED.scheduled_for,
+			canceled_at = EXCLUDED.canceled_at,
+			completed_at = EXCLUDED.completed_at,
+			attempts = EXCLUDED.attempts,
+			last_attempt_at = EXCLUDED.last_attempt_at,
+			last_error = EXCLUDED.last_error
+	`)
+
+	query, args := ib.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAccountDeletion returns the user's deletion request, or nil if they never made one.
+func (iam *IAMStore) GetAccountDeletion(ctx context.Context, db dbtx.DBTx, userID core.UserID) (*core.AccountDeletion, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select(accountDeletionColumns...).From("account_deletions")
+	sb.Where(sb.Equal("user_id", userID))
+
+	query, args := sb.Build()
+	d, err := scanAccountDeletion(db.QueryRowContext(ctx, query, args...))
+	if err == ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// RecordAccountDeletionAttempt counts a failed attempt at deleting the user's account.
+func (iam *IAMStore) RecordAccountDeletionAttempt(ctx context.Context, db dbtx.DBTx, userID core.UserID, at time.Time, attemptErr error) error {
+	ub := sqlbuilder.PostgreSQL.NewUpdateBuilder()
+	ub.Update("account_deletions")
+	ub.Set(
+		ub.Incr("attempts"),
+		ub.Assign("last_attempt_at", at),
+		ub.Assign("last_error", attemptErr.Error()),
+	)
+	ub.Where(ub.Equal("user_id", userID))
+
+	query, args := ub.Build()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ListDueAccountDeletions returns up to limit pending deletions whose cooling-off
+// period is over, those that failed the fewest times first.
+func (iam *IAMStore) ListDueAccountDeletions(ctx context.Context, db dbtx.DBTx, at time.Time, limit int) ([]core.AccountDeletion, error) {
+	sb := sqlbuilder.PostgreSQL.NewSelectBuilder()
+	sb.Select(accountDeletionColumns...).From("account_deletions")
+	sb.Where(
+		sb.IsNull("canceled_at"),
+		sb.IsNull("completed_at"),
+		sb.LessEqualThan("scheduled_for", at),
+	)
+	sb.OrderBy("attempts", "scheduled_for")
+	sb.Limit(limit)
+
+	query, args := sb.Build()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []core.AccountDeletion{}
+	for rows.Next() {
+		d, err := scanAccountDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, *d)
+	}
+
+	return deletions, rows.Err()
+}
+
+// DeleteUserData deletes everything personal attached to the user, leaving the
+// users row itself, which the caller anonymizes.
+func (iam *IAMStore) DeleteUserData(ctx context.Context, db dbtx.DBTx, userID core.UserID) error {
+	tables := []string{"user_profiles", "verification_tokens", "sessions", "user_credentials", "user_roles", "api_keys", "user_identities"}
+	for _, table := range tables {
+		del := sqlbuilder.PostgreSQL.NewDeleteBuilder()
+		del.DeleteFrom(table)
+		del.Where(del.Equal("user_id", userID))
+
+		query, args := del.Build()
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("DeleteUserData %s: %w", table, err)
+		}
+	}
+	return nil
+}
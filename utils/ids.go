@@ -10,6 +10,8 @@ var eightNanoID, _ = nanoid.Custom("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqr
 
 // var twelveNanoID, _ = nanoid.Custom("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", 12)
 var eighteenNanoID, _ = nanoid.Custom("ABCDEFGHIJKLMNPQRSTUVWXYZabcdefghijklmnpqrstuvwxyz123456789", 18)
+var thirtyTwoNanoID, _ = nanoid.Custom("ABCDEFGHIJKLMNPQRSTUVWXYZabcdefghijklmnpqrstuvwxyz123456789", 32)
+var fourLowerNanoID, _ = nanoid.Custom("abcdefghijkmnpqrstuvwxyz23456789", 4)
 
 func NewLeaseID() string {
 	return fmt.Sprintf("lea_%s", eighteenNanoID())
@@ -34,3 +36,19 @@ func NewVerificationTokenChangeEmail() string {
 func NewVerificationTokenResetPassword() string {
 	return eighteenNanoID()
 }
+
+func NewSessionToken() string {
+	return fmt.Sprintf("ses_%s", thirtyTwoNanoID())
+}
+
+func NewUsernameSuffix() string {
+	return fourLowerNanoID()
+}
+
+func NewAPIKeyID() string {
+	return eighteenNanoID()
+}
+
+func NewAPIKeySecret() string {
+	return thirtyTwoNanoID()
+}
@@ -17,3 +17,17 @@ func TestNewLeaseID(t *testing.T) {
 	// Test that the lease ID has the correct length.
 	assert.GreaterOrEqual(t, 22, len(leaseID))
 }
+
+func TestNewSessionToken(t *testing.T) {
+	token := utils.NewSessionToken()
+	assert.Equal(t, "ses_", token[:4])
+	assert.Len(t, token, 36)
+	assert.NotEqual(t, token, utils.NewSessionToken())
+}
+
+func TestNewAPIKeyIDAndSecret(t *testing.T) {
+	// API key tokens are dk_<id>_<secret>, so neither part may contain an underscore
+	assert.NotContains(t, utils.NewAPIKeyID(), "_")
+	assert.NotContains(t, utils.NewAPIKeySecret(), "_")
+	assert.Len(t, utils.NewAPIKeySecret(), 32)
+}
@@ -2,7 +2,12 @@ package utils
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"image"
+	"image/color"
+	"image/gif"
 	"image/png"
 
 	"golang.org/x/image/draw"
@@ -36,3 +41,94 @@ func ConvertImageToBytes(img image.Image) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// EncodePNG encodes the image as a png with the given compression level.
+func EncodePNG(img image.Image, level png.CompressionLevel) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	encoder := png.Encoder{CompressionLevel: level}
+	if err := encoder.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ToPaletted converts the image to a palette-indexed image.
+// It returns false if the image has more than 256 distinct colors.
+func ToPaletted(img image.Image) (*image.Paletted, bool) {
+	bounds := img.Bounds()
+	palette := color.Palette{}
+	indexes := map[color.RGBA]uint8{}
+	dst := image.NewPaletted(bounds, nil)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			index, ok := indexes[c]
+			if !ok {
+				if len(palette) == 256 {
+					return nil, false
+				}
+				index = uint8(len(palette))
+				indexes[c] = index
+				palette = append(palette, c)
+			}
+			dst.SetColorIndex(x, y, index)
+		}
+	}
+
+	dst.Palette = palette
+	return dst, true
+}
+
+// EncodeRGBA encodes the image in the raw RGBA format: the width and height as
+// big-endian uint32s followed by 4 bytes (R, G, B, A) per pixel, row by row.
+func EncodeRGBA(img image.Image) []byte {
+	bounds := img.Bounds()
+	rgba, ok := img.(*image.RGBA)
+	if !ok || rgba.Stride != 4*bounds.Dx() {
+		rgba = image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	}
+
+	buf := make([]byte, 8, 8+len(rgba.Pix))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(bounds.Dy()))
+	return append(buf, rgba.Pix...)
+}
+
+// DecodeRGBA decodes an image encoded by EncodeRGBA.
+func DecodeRGBA(data []byte) (*image.RGBA, error) {
+	if len(data) < 8 {
+		return nil, errors.New("rgba data is too short")
+	}
+	width := int(binary.BigEndian.Uint32(data[0:4]))
+	height := int(binary.BigEndian.Uint32(data[4:8]))
+	if len(data)-8 != width*height*4 {
+		return nil, fmt.Errorf("rgba data has %d bytes, expected %d for %dx%d", len(data)-8, width*height*4, width, height)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	copy(img.Pix, data[8:])
+	return img, nil
+}
+
+// UpscaleImage enlarges the image by an integer factor, turning every pixel
+// into a factor x factor block so that pixel art stays crisp.
+func UpscaleImage(src image.Image, factor int64) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*int(factor), bounds.Dy()*int(factor)))
+	draw.NearestNeighbor.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+// EncodeGIF encodes the image as a gif, losslessly if it has at most 256 colors.
+func EncodeGIF(img image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if paletted, ok := ToPaletted(img); ok {
+		img = paletted
+	}
+	if err := gif.Encode(buf, img, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
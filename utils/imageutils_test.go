@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"bytes"
 	"image"
 	"image/color"
+	"image/gif"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,3 +37,75 @@ func TestResizeImage(t *testing.T) {
 	assert.Equal(t, 200, dst.Bounds().Dx())
 	assert.Equal(t, 200, dst.Bounds().Dy())
 }
+
+func TestToPaletted(t *testing.T) {
+	// Test an image with few colors.
+	src := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	src.SetRGBA(1, 2, color.RGBA{255, 0, 0, 255})
+	src.SetRGBA(3, 4, color.RGBA{0, 255, 0, 255})
+
+	dst, ok := ToPaletted(src)
+	assert.True(t, ok)
+	assert.Len(t, dst.Palette, 3)
+	for x := 0; x < 10; x++ {
+		for y := 0; y < 10; y++ {
+			assert.True(t, CompareColors(src.At(x, y), dst.At(x, y)))
+		}
+	}
+
+	// Test an image with too many colors.
+	src = image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 100; y++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	_, ok = ToPaletted(src)
+	assert.False(t, ok)
+}
+
+func TestEncodeDecodeRGBA(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.SetRGBA(0, 0, color.RGBA{1, 2, 3, 4})
+	src.SetRGBA(2, 1, color.RGBA{5, 6, 7, 8})
+
+	data := EncodeRGBA(src)
+	assert.Len(t, data, 8+3*2*4)
+	assert.Equal(t, []byte{0, 0, 0, 3, 0, 0, 0, 2, 1, 2, 3, 4}, data[:12])
+
+	dst, err := DecodeRGBA(data)
+	assert.NoError(t, err)
+	assert.Equal(t, src.Bounds(), dst.Bounds())
+	assert.Equal(t, src.Pix, dst.Pix)
+
+	_, err = DecodeRGBA(data[:10])
+	assert.Error(t, err)
+}
+
+func TestUpscaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.SetRGBA(1, 0, color.RGBA{255, 0, 0, 255})
+
+	dst := UpscaleImage(src, 3)
+	assert.Equal(t, 6, dst.Bounds().Dx())
+	assert.Equal(t, 6, dst.Bounds().Dy())
+	for x := 0; x < 6; x++ {
+		for y := 0; y < 6; y++ {
+			assert.True(t, CompareColors(src.At(x/3, y/3), dst.At(x, y)), "(%d,%d)", x, y)
+		}
+	}
+}
+
+func TestEncodeGIF(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	src.SetRGBA(1, 2, color.RGBA{255, 0, 0, 255})
+
+	data, err := EncodeGIF(src)
+	assert.NoError(t, err)
+
+	decoded, err := gif.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.True(t, CompareColors(src.At(1, 2), decoded.At(1, 2)))
+	assert.True(t, CompareColors(src.At(0, 0), decoded.At(0, 0)))
+}
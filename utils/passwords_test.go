@@ -0,0 +1,18 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/lazharichir/draw/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := utils.HashPassword("correct horse battery staple")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "correct horse battery staple", string(hash))
+
+	assert.True(t, utils.CheckPassword(hash, "correct horse battery staple"))
+	assert.False(t, utils.CheckPassword(hash, "Correct horse battery staple"))
+	assert.False(t, utils.CheckPassword([]byte("not a hash"), "correct horse battery staple"))
+}
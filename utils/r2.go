@@ -46,7 +46,7 @@ func NewS3Client(
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, accessKeySecret, "")),
 	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	fmt.Println(`[R2] Connected to R2 Cloudflare Storage`, accountID, cfg.Region)